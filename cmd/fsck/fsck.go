@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/EatonEmmerich/cloudStorage/pkg/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/fsck"
+	"os"
+)
+
+var repair = flag.Bool("repair", false, "Repair the issues found instead of only reporting them")
+var verifyChecksums = flag.Bool("verify-checksums", false, "Read back every blob and verify its checksum")
+
+// Checks the documents table against the blob store and prints a JSON report of the issues found. Without -repair it
+// is a dry run that changes nothing. Exits with status 1 if any issue is left unrepaired. Stop the server, or at least
+// uploads, before running it.
+func main() {
+	flag.Parse()
+	ctx := context.Background()
+
+	dbc, err := db.New()
+	if err != nil {
+		panic(err)
+	}
+
+	report, err := fsck.Run(ctx, dbc, *repair, *verifyChecksums)
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encodeErr := encoder.Encode(report)
+	if err != nil {
+		panic(err)
+	}
+	if encodeErr != nil {
+		panic(encodeErr)
+	}
+
+	if fsck.Unrepaired(report) > 0 {
+		os.Exit(1)
+	}
+}
@@ -2,13 +2,31 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"github.com/EatonEmmerich/cloudStorage/pkg/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents"
 )
 
 func main() {
+	flag.Parse()
 	ctx := context.Background()
 	err := db.SetupSchema(ctx)
 	if err != nil {
 		panic(err)
 	}
+
+	dbc, err := db.New()
+	if err != nil {
+		panic(err)
+	}
+	defer dbc.Close()
+
+	n, err := documents.MigrateLegacyPaths(ctx, dbc)
+	if err != nil {
+		panic(err)
+	}
+	if n > 0 {
+		fmt.Printf("Moved %d documents and versions stored before the blob store onto keys in it\n", n)
+	}
 }
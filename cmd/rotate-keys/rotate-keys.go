@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/EatonEmmerich/cloudStorage/pkg/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents"
+	"github.com/EatonEmmerich/cloudStorage/pkg/encryption"
+	"strings"
+)
+
+var newKeyFile = flag.String("new-key-file", "", "File holding the master key to wrap data keys with")
+var oldKeyFiles = flag.String("old-key-files", "", "Comma separated files holding the master keys data keys are currently wrapped with")
+var generate = flag.Bool("generate", false, "Generate a new random master key in 'new-key-file' before rotating")
+
+// Re-wraps every data key under a new master key. Run the server with CLOUD_STORAGE_MASTER_KEY_FILE set to the new key
+// and CLOUD_STORAGE_PREVIOUS_MASTER_KEY_FILES to the old ones until this has completed.
+func main() {
+	flag.Parse()
+	ctx := context.Background()
+
+	if *newKeyFile == "" {
+		panic("new-key-file is required")
+	}
+	if *generate {
+		err := encryption.GenerateMasterKeyFile(*newKeyFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var previous []string
+	if *oldKeyFiles != "" {
+		previous = strings.Split(*oldKeyFiles, ",")
+	}
+	provider, err := encryption.NewFileKeyProvider(*newKeyFile, previous...)
+	if err != nil {
+		panic(err)
+	}
+
+	dbc, err := db.New()
+	if err != nil {
+		panic(err)
+	}
+
+	n, err := documents.RotateDataKeys(ctx, dbc, provider)
+	fmt.Printf("Re-wrapped %d data keys under master key %s\n", n, provider.CurrentKeyID())
+	if err != nil {
+		panic(err)
+	}
+}
@@ -5,6 +5,7 @@ go 1.17
 require (
 	github.com/go-sql-driver/mysql v1.6.0
 	github.com/google/gofuzz v1.2.0
+	github.com/klauspost/compress v1.15.15
 	github.com/stretchr/testify v1.7.0
 )
 
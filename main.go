@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/EatonEmmerich/cloudStorage/pkg/api"
 	"github.com/EatonEmmerich/cloudStorage/pkg/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents"
+	"github.com/EatonEmmerich/cloudStorage/pkg/uploads"
 	"net/http"
 )
 
@@ -13,6 +16,9 @@ func main() {
 		panic( err)
 	}
 
+	go documents.RunPruner(context.Background(), dbc)
+	go uploads.RunCleaner(context.Background(), dbc)
+
 	server := api.New(dbc)
 
 	fmt.Printf("Starting server on %s, with routes: %#v\n", server.Addr, server)
@@ -0,0 +1,83 @@
+package access_control
+
+import (
+	"context"
+	"database/sql"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control/internal/db"
+	fsckModels "github.com/EatonEmmerich/cloudStorage/pkg/fsck/models"
+)
+
+// Fsck finds permissions and audit log entries referring to documents or users that no longer exist. With repair
+// set, such permissions are deleted and the audit log entries are kept, with the missing reference cleared.
+func Fsck(ctx context.Context, dbc *sql.DB, repair bool) ([]fsckModels.Issue, error) {
+	var issues []fsckModels.Issue
+
+	permissions, err := db.ListDanglingPermissions(ctx, dbc)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range permissions {
+		detail := "permission on a document that does not exist"
+		if p.DocumentExists {
+			detail = "permission for a user that does not exist"
+		}
+		issue := fsckModels.Issue{
+			Kind:     fsckModels.DanglingPermission,
+			Document: p.Document,
+			User:     p.User,
+			Detail:   detail,
+			Repair:   "delete permission",
+		}
+		if repair {
+			err = db.DeletePermission(ctx, dbc, p.ID)
+			if err != nil {
+				return nil, err
+			}
+			issue.Repaired = true
+		}
+		issues = append(issues, issue)
+	}
+
+	documentIDs, err := db.ListDanglingAuditDocuments(ctx, dbc)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range documentIDs {
+		issue := fsckModels.Issue{
+			Kind:     fsckModels.DanglingAuditLog,
+			Document: id,
+			Detail:   "audit log refers to a document that does not exist",
+			Repair:   "clear document from audit log",
+		}
+		if repair {
+			err = db.ClearAuditDocument(ctx, dbc, id)
+			if err != nil {
+				return nil, err
+			}
+			issue.Repaired = true
+		}
+		issues = append(issues, issue)
+	}
+
+	userIDs, err := db.ListDanglingAuditUsers(ctx, dbc)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range userIDs {
+		issue := fsckModels.Issue{
+			Kind:   fsckModels.DanglingAuditLog,
+			User:   id,
+			Detail: "audit log refers to a user that does not exist",
+			Repair: "clear user from audit log",
+		}
+		if repair {
+			err = db.ClearAuditUser(ctx, dbc, id)
+			if err != nil {
+				return nil, err
+			}
+			issue.Repaired = true
+		}
+		issues = append(issues, issue)
+	}
+	return issues, nil
+}
@@ -7,9 +7,11 @@ import (
 	"time"
 )
 
+// Log records an action in the audit log. Actions the server takes by itself are logged with a userID of 0, and
+// actions on documents that no longer exist with a documentID of 0.
 func Log(ctx context.Context, dbc *sql.DB, userID int64, documentID int64, action string) error {
 	resp, err := dbc.ExecContext(ctx, "insert into audit_log set `user` = ?, `document` = ?, `action` = ?, `timestamp` = ?",
-		userID, documentID, action, time.Now())
+		sql.NullInt64{Int64: userID, Valid: userID != 0}, sql.NullInt64{Int64: documentID, Valid: documentID != 0}, action, time.Now())
 	if err != nil {
 		return err
 	}
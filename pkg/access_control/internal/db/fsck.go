@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+type DanglingPermission struct {
+	ID       int64
+	Document int64
+	User     int64
+	// DocumentExists and UserExists tell which side of the permission is missing.
+	DocumentExists bool
+	UserExists     bool
+}
+
+// ListDanglingPermissions returns permissions on documents or for users that no longer exist.
+func ListDanglingPermissions(ctx context.Context, dbc *sql.DB) ([]DanglingPermission, error) {
+	rows, err := dbc.QueryContext(ctx, "select p.`id`, p.`document`, p.`user`, d.`id` is not null, u.`id` is not null from `permissions` p "+
+		"left join `documents` d on d.`id` = p.`document` left join `users` u on u.`id` = p.`user` where d.`id` is null or u.`id` is null")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []DanglingPermission
+	for rows.Next() {
+		var p DanglingPermission
+		err = rows.Scan(&p.ID, &p.Document, &p.User, &p.DocumentExists, &p.UserExists)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, p)
+	}
+	return permissions, rows.Err()
+}
+
+func DeletePermission(ctx context.Context, dbc *sql.DB, id int64) error {
+	_, err := dbc.ExecContext(ctx, "delete from `permissions` where `id` = ?", id)
+	return err
+}
+
+// ListDanglingAuditDocuments returns the IDs of documents that no longer exist but are still referred to by the audit log.
+func ListDanglingAuditDocuments(ctx context.Context, dbc *sql.DB) ([]int64, error) {
+	return listIDs(ctx, dbc, "select distinct a.`document` from `audit_log` a left join `documents` d on d.`id` = a.`document` "+
+		"where a.`document` is not null and d.`id` is null")
+}
+
+// ListDanglingAuditUsers returns the IDs of users that no longer exist but are still referred to by the audit log.
+func ListDanglingAuditUsers(ctx context.Context, dbc *sql.DB) ([]int64, error) {
+	return listIDs(ctx, dbc, "select distinct a.`user` from `audit_log` a left join `users` u on u.`id` = a.`user` "+
+		"where a.`user` is not null and u.`id` is null")
+}
+
+// ClearAuditDocument detaches audit log entries from a deleted document, as the foreign key would have on delete.
+func ClearAuditDocument(ctx context.Context, dbc *sql.DB, documentID int64) error {
+	_, err := dbc.ExecContext(ctx, "update `audit_log` set `document` = null where `document` = ?", documentID)
+	return err
+}
+
+// ClearAuditUser detaches audit log entries from a deleted user, as the foreign key would have on delete.
+func ClearAuditUser(ctx context.Context, dbc *sql.DB, userID int64) error {
+	_, err := dbc.ExecContext(ctx, "update `audit_log` set `user` = null where `user` = ?", userID)
+	return err
+}
+
+func listIDs(ctx context.Context, dbc *sql.DB, query string) ([]int64, error) {
+	rows, err := dbc.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
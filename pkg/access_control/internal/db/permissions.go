@@ -40,20 +40,3 @@ func ShareDocument(ctx context.Context, dbc *sql.DB, documentID int64, shareUser
 
 	return nil
 }
-
-func ListSharedDocuments(ctx context.Context, dbc *sql.DB, userID int64) ([]int64, error) {
-	rows, err := dbc.QueryContext(ctx, "select `document` from permissions where `user` = ?", userID)
-	if err != nil {
-		return nil, err
-	}
-	var documentIDs []int64
-	for rows.Next() {
-		var docID int64
-		err = rows.Scan(&docID)
-		if err != nil {
-			return nil, err
-		}
-		documentIDs = append(documentIDs, docID)
-	}
-	return documentIDs, nil
-}
\ No newline at end of file
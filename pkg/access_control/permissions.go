@@ -50,6 +50,7 @@ func ShareDocument(ctx context.Context, dbc *sql.DB, doc documentModels.Document
 	return db.Log(ctx, dbc, userID, doc.ID, "Share - Perm:" + permissions.String() + " - Authorised")
 }
 
-func SharedDocuments(ctx context.Context, dbc *sql.DB, userID int64) ([]int64, error) {
-	return db.ListSharedDocuments(ctx, dbc, userID)
-}
\ No newline at end of file
+// Log records an action a user took on a document in the audit log.
+func Log(ctx context.Context, dbc *sql.DB, userID int64, documentID int64, action string) error {
+	return db.Log(ctx, dbc, userID, documentID, action)
+}
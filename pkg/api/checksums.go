@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"strings"
+)
+
+type header interface {
+	Get(key string) string
+}
+
+// expectedChecksums reads the Content-MD5 and Digest (RFC 3230) headers a client may send along with an upload. The
+// first header that has them is used.
+func expectedChecksums(headers ...header) (documents.Checksums, error) {
+	var checksums documents.Checksums
+	for _, h := range headers {
+		if contentMD5 := h.Get("Content-MD5"); contentMD5 != "" && checksums.MD5 == nil {
+			sum, err := base64.StdEncoding.DecodeString(contentMD5)
+			if err != nil {
+				return documents.Checksums{}, errors.New("invalid Content-MD5 header")
+			}
+			checksums.MD5 = sum
+		}
+
+		for _, digest := range strings.Split(h.Get("Digest"), ",") {
+			parts := strings.SplitN(strings.TrimSpace(digest), "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			algorithm := parts[0]
+			sum, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return documents.Checksums{}, errors.New("invalid Digest header")
+			}
+			switch strings.ToLower(algorithm) {
+			case "sha-256":
+				if checksums.SHA256 == nil {
+					checksums.SHA256 = sum
+				}
+			case "md5":
+				if checksums.MD5 == nil {
+					checksums.MD5 = sum
+				}
+			}
+		}
+	}
+	return checksums, nil
+}
+
+// digestHeader formats the checksums of a document as a Digest header value.
+func digestHeader(doc models.Document) string {
+	var digests []string
+	if sum, err := hex.DecodeString(doc.SHA256); err == nil && len(sum) > 0 {
+		digests = append(digests, "sha-256="+base64.StdEncoding.EncodeToString(sum))
+	}
+	if sum, err := hex.DecodeString(doc.MD5); err == nil && len(sum) > 0 {
+		digests = append(digests, "md5="+base64.StdEncoding.EncodeToString(sum))
+	}
+	return strings.Join(digests, ", ")
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Documents are tagged by their version, so a tag changes whenever a document is updated or restored. It covers the
+// contents only: renaming, moving or labelling a document leaves it the same.
+func etag(version int64) string {
+	return `"v` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ifMatchVersions returns the versions an If-Match header value allows an update to apply to, or nil if it allows any
+// version. Weak and malformed tags can't match, so a header holding only those allows none.
+func ifMatchVersions(ifMatch string) []int64 {
+	ifMatch = strings.TrimSpace(ifMatch)
+	if ifMatch == "" || ifMatch == "*" {
+		return nil
+	}
+
+	versions := []int64{-1}
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if !strings.HasPrefix(tag, `"v`) || !strings.HasSuffix(tag, `"`) {
+			continue
+		}
+		version, err := strconv.ParseInt(tag[2:len(tag)-1], 10, 64)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	return versions
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Files and folders can be looked up by path under filesPath, such as /files/projects/2024/report.pdf.
+const filesPath = "/files/"
+
+// formID parses an optional ID from a form value, which is 0 if it is empty.
+func formID(req *http.Request, key string) (int64, error) {
+	value := req.FormValue(key)
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+func createFolder(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		err := req.ParseForm()
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		parentID, err := formID(req, "parent_id")
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		folder, err := documents.CreateFolder(req.Context(), dbc, userID, parentID, req.FormValue("name"))
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		resp.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(resp)
+		err = encoder.Encode(folder)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+	}
+}
+
+func listFolder(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		folderID, err := formID(req, "folder_id")
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		contents, err := documents.ListFolder(req.Context(), dbc, folderID, userID)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		resp.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(resp)
+		err = encoder.Encode(contents)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+	}
+}
+
+func renameFolder(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		err := req.ParseForm()
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		folderID, err := strconv.ParseInt(req.FormValue("folder_id"), 10, 64)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		err = documents.RenameFolder(req.Context(), dbc, folderID, userID, req.FormValue("name"))
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+		resp.WriteHeader(http.StatusOK)
+	}
+}
+
+func deleteFolder(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		err := req.ParseForm()
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		folderID, err := strconv.ParseInt(req.FormValue("folder_id"), 10, 64)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		err = documents.DeleteFolder(req.Context(), dbc, folderID, userID)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+		resp.WriteHeader(http.StatusOK)
+	}
+}
+
+func moveDocument(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		err := req.ParseForm()
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		documentID, err := strconv.ParseInt(req.FormValue("document_id"), 10, 64)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		folderID, err := formID(req, "folder_id")
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		err = documents.MoveDocument(req.Context(), dbc, documentID, folderID, userID)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+		resp.WriteHeader(http.StatusOK)
+	}
+}
+
+// getFile sends the document at a path, or lists the folder at it.
+func getFile(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		folder, documentID, err := documents.ResolvePath(req.Context(), dbc, userID, strings.TrimPrefix(req.URL.Path, filesPath))
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		if documentID != 0 {
+			serveDocument(dbc, userID, documentID, 0, resp, req)
+			return
+		}
+
+		contents, err := documents.ListFolder(req.Context(), dbc, folder.ID, userID)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		resp.Header().Set("Content-Type", "application/json")
+		resp.WriteHeader(http.StatusOK)
+		if req.Method == http.MethodHead {
+			return
+		}
+		encoder := json.NewEncoder(resp)
+		err = encoder.Encode(contents)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents"
+	documentModels "github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"net/http"
+	"strconv"
+)
+
+// documentListing reads which documents to list from parsed form values: the filter out of any number of tag values
+// and metadata properties, media_type, min_size, max_size and name_prefix, and the page out of limit, cursor, sort
+// and order, which is asc or desc.
+func documentListing(req *http.Request) (documentModels.Filter, documentModels.Page, error) {
+	filter := documentModels.Filter{
+		Tags:       req.Form["tag"],
+		Metadata:   formMetadata(req),
+		MediaType:  req.FormValue("media_type"),
+		NamePrefix: req.FormValue("name_prefix"),
+	}
+
+	var err error
+	filter.MinSize, err = formSize(req, "min_size")
+	if err != nil {
+		return documentModels.Filter{}, documentModels.Page{}, err
+	}
+	filter.MaxSize, err = formSize(req, "max_size")
+	if err != nil {
+		return documentModels.Filter{}, documentModels.Page{}, err
+	}
+
+	var limit int
+	if value := req.FormValue("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil {
+			return documentModels.Filter{}, documentModels.Page{}, err
+		}
+	}
+
+	var descending bool
+	switch req.FormValue("order") {
+	case "", "asc":
+	case "desc":
+		descending = true
+	default:
+		return documentModels.Filter{}, documentModels.Page{}, fmt.Errorf("%w: order must be asc or desc", documents.ErrInvalidPage)
+	}
+
+	page, err := documents.ParsePage(limit, req.FormValue("cursor"), documentModels.Sort(req.FormValue("sort")), descending)
+	if err != nil {
+		return documentModels.Filter{}, documentModels.Page{}, err
+	}
+	return filter, page, nil
+}
+
+// formSize parses an optional size from a form value, which is nil if it is empty.
+func formSize(req *http.Request, key string) (*int64, error) {
+	value := req.FormValue(key)
+	if value == "" {
+		return nil, nil
+	}
+	size, err := strconv.ParseUint(value, 10, 63)
+	if err != nil {
+		return nil, err
+	}
+	signed := int64(size)
+	return &signed, nil
+}
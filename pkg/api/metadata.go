@@ -0,0 +1,93 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Metadata properties are given as form values prefixed with metaPrefix, such as meta.client=acme.
+const metaPrefix = "meta."
+
+func formMetadata(req *http.Request) map[string]string {
+	metadata := make(map[string]string)
+	for key, values := range req.Form {
+		if strings.HasPrefix(key, metaPrefix) && len(values) > 0 {
+			metadata[strings.TrimPrefix(key, metaPrefix)] = values[0]
+		}
+	}
+	return metadata
+}
+
+func getLabels(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		documentID, err := strconv.ParseInt(req.FormValue("document_id"), 10, 64)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		labels, err := documents.GetLabels(req.Context(), dbc, documentID, userID)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		resp.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(resp)
+		err = encoder.Encode(labels)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+	}
+}
+
+func setTags(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		err := req.ParseForm()
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		documentID, err := strconv.ParseInt(req.FormValue("document_id"), 10, 64)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		err = documents.SetTags(req.Context(), dbc, documentID, userID, req.Form["tag"])
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+		resp.WriteHeader(http.StatusOK)
+	}
+}
+
+func setMetadata(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		err := req.ParseForm()
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		documentID, err := strconv.ParseInt(req.FormValue("document_id"), 10, 64)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		err = documents.SetMetadata(req.Context(), dbc, documentID, userID, formMetadata(req))
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+		resp.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/EatonEmmerich/cloudStorage/pkg/uploads"
+	"github.com/EatonEmmerich/cloudStorage/pkg/uploads/models"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+func initiateMultipart(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		err := req.ParseForm()
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		var documentID int64
+		if id := req.FormValue("document_id"); id != "" {
+			documentID, err = strconv.ParseInt(id, 10, 64)
+			if err != nil {
+				respondError(resp, err)
+				return
+			}
+		}
+
+		upload, err := uploads.InitiateMultipart(req.Context(), dbc, userID, documentID, req.FormValue("media_type"), req.FormValue("filename"))
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		resp.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(resp)
+		err = encoder.Encode(upload)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+	}
+}
+
+func uploadPart(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		number, err := strconv.Atoi(req.URL.Query().Get("part_number"))
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		checksums, err := expectedChecksums(req.Header)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		part, err := uploads.UploadPart(req.Context(), dbc, req.URL.Query().Get("upload_id"), userID, number, req.Body, checksums)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		resp.Header().Set("ETag", part.ETag)
+		resp.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(resp)
+		err = encoder.Encode(part)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+	}
+}
+
+func listParts(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		parts, err := uploads.ListParts(req.Context(), dbc, req.URL.Query().Get("upload_id"), userID)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		resp.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(resp)
+		err = encoder.Encode(parts)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+	}
+}
+
+// completeMultipart joins the parts listed in the JSON body, {"Parts": [{"Number": 1, "ETag": "..."}, ...]}, or all
+// parts uploaded if the body is empty.
+func completeMultipart(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		var body struct {
+			Parts []models.CompletedPart
+		}
+		err := json.NewDecoder(req.Body).Decode(&body)
+		if err != nil && !errors.Is(err, io.EOF) {
+			respondError(resp, err)
+			return
+		}
+
+		documentID, err := uploads.CompleteMultipart(req.Context(), dbc, req.URL.Query().Get("upload_id"), userID, body.Parts)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		resp.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(resp)
+		err = encoder.Encode(struct {
+			DocumentID int64
+		}{
+			DocumentID: documentID,
+		})
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+	}
+}
+
+func abortMultipart(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		err := uploads.AbortMultipart(req.Context(), dbc, req.URL.Query().Get("upload_id"), userID)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+		resp.WriteHeader(http.StatusOK)
+	}
+}
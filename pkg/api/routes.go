@@ -7,6 +7,8 @@ import (
 	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
 	"github.com/EatonEmmerich/cloudStorage/pkg/access_control/models"
 	"github.com/EatonEmmerich/cloudStorage/pkg/documents"
+	documentModels "github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/uploads"
 	"github.com/EatonEmmerich/cloudStorage/pkg/users"
 	"html"
 	"io"
@@ -14,6 +16,7 @@ import (
 	"net/http"
 	"regexp"
 	"strconv"
+	"time"
 )
 
 type middleware func(http.HandlerFunc) http.HandlerFunc
@@ -54,6 +57,30 @@ func get(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+var _ middleware = put
+
+func put(next http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != "PUT" {
+			resp.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(resp, req)
+	}
+}
+
+var _ middleware = getOrHead
+
+func getOrHead(next http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" && req.Method != "HEAD" {
+			resp.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(resp, req)
+	}
+}
+
 type authorisedHandler func(userID int64, resp http.ResponseWriter, req *http.Request)
 
 func basicAuth(dbc *sql.DB, next authorisedHandler) http.HandlerFunc {
@@ -79,8 +106,40 @@ func New(dbc *sql.DB) http.Server {
 	mux.HandleFunc("/update", patch(basicAuth(dbc, updateDocument(dbc))))
 	mux.HandleFunc("/documents", get(basicAuth(dbc, listDocuments(dbc))))
 	mux.HandleFunc("/shared", get(basicAuth(dbc, getShared(dbc))))
-	mux.HandleFunc("/document", get(basicAuth(dbc, getDocument(dbc))))
+	mux.HandleFunc("/search", get(basicAuth(dbc, searchDocuments(dbc))))
+	mux.HandleFunc("/document", getOrHead(basicAuth(dbc, getDocument(dbc))))
+	mux.HandleFunc("/document/versions", get(basicAuth(dbc, listVersions(dbc))))
+	mux.HandleFunc("/document/restore", post(basicAuth(dbc, restoreVersion(dbc))))
+	mux.HandleFunc("/document/retention", get(basicAuth(dbc, getRetentionPolicy(dbc))))
+	mux.HandleFunc("/retention", post(basicAuth(dbc, setRetentionPolicy(dbc))))
+	mux.HandleFunc("/document/lock", post(basicAuth(dbc, lockDocument(dbc))))
+	mux.HandleFunc("/document/lock/refresh", post(basicAuth(dbc, refreshLock(dbc))))
+	mux.HandleFunc("/document/unlock", post(basicAuth(dbc, unlockDocument(dbc))))
+	mux.HandleFunc("/document/move", post(basicAuth(dbc, moveDocument(dbc))))
+	mux.HandleFunc("/document/rename", post(basicAuth(dbc, renameDocument(dbc))))
+	mux.HandleFunc("/document/copy", post(basicAuth(dbc, copyDocument(dbc))))
+	mux.HandleFunc("/document/labels", get(basicAuth(dbc, getLabels(dbc))))
+	mux.HandleFunc("/document/tags", post(basicAuth(dbc, setTags(dbc))))
+	mux.HandleFunc("/document/metadata", post(basicAuth(dbc, setMetadata(dbc))))
+	mux.HandleFunc("/document/delete", post(basicAuth(dbc, deleteDocument(dbc))))
+	mux.HandleFunc("/trash", get(basicAuth(dbc, listTrash(dbc))))
+	mux.HandleFunc("/trash/restore", post(basicAuth(dbc, restoreDeleted(dbc))))
+	mux.HandleFunc("/trash/purge", post(basicAuth(dbc, purgeDocument(dbc))))
+	mux.HandleFunc("/folder", get(basicAuth(dbc, listFolder(dbc))))
+	mux.HandleFunc("/folder/create", post(basicAuth(dbc, createFolder(dbc))))
+	mux.HandleFunc("/folder/rename", post(basicAuth(dbc, renameFolder(dbc))))
+	mux.HandleFunc("/folder/delete", post(basicAuth(dbc, deleteFolder(dbc))))
+	mux.HandleFunc(filesPath, getOrHead(basicAuth(dbc, getFile(dbc))))
 	mux.HandleFunc("/share", post(basicAuth(dbc, shareDocument(dbc))))
+	mux.HandleFunc("/usage", get(basicAuth(dbc, getUsage(dbc))))
+	mux.HandleFunc("/quota", post(basicAuth(dbc, setQuota(dbc))))
+	mux.HandleFunc("/media-types", post(basicAuth(dbc, setMediaTypePolicy(dbc))))
+	mux.HandleFunc(tusPath, tus(dbc))
+	mux.HandleFunc("/multipart/initiate", post(basicAuth(dbc, initiateMultipart(dbc))))
+	mux.HandleFunc("/multipart/part", put(basicAuth(dbc, uploadPart(dbc))))
+	mux.HandleFunc("/multipart/parts", get(basicAuth(dbc, listParts(dbc))))
+	mux.HandleFunc("/multipart/complete", post(basicAuth(dbc, completeMultipart(dbc))))
+	mux.HandleFunc("/multipart/abort", post(basicAuth(dbc, abortMultipart(dbc))))
 
 	httpServer := http.Server{
 		Handler:  mux,
@@ -110,6 +169,30 @@ func registerUser(dbc *sql.DB) http.HandlerFunc {
 func respondError(resp http.ResponseWriter, err error) {
 	if errors.Is(err, access_control.ErrAccessDenied){
 		resp.WriteHeader(http.StatusUnauthorized)
+	} else if errors.Is(err, documents.ErrNotFound) || errors.Is(err, documents.ErrVersionNotFound) {
+		resp.WriteHeader(http.StatusNotFound)
+	} else if errors.Is(err, documents.ErrVersionConflict) {
+		resp.WriteHeader(http.StatusPreconditionFailed)
+	} else if errors.Is(err, documents.ErrLocked) {
+		resp.WriteHeader(http.StatusLocked)
+	} else if errors.Is(err, documents.ErrNotLocked) {
+		resp.WriteHeader(http.StatusConflict)
+	} else if errors.Is(err, uploads.ErrNotFound) {
+		resp.WriteHeader(http.StatusNotFound)
+	} else if errors.Is(err, uploads.ErrOffsetMismatch) {
+		resp.WriteHeader(http.StatusConflict)
+	} else if errors.Is(err, uploads.ErrTooLarge) || errors.Is(err, documents.ErrFileTooLarge) {
+		resp.WriteHeader(http.StatusRequestEntityTooLarge)
+	} else if errors.Is(err, documents.ErrQuotaExceeded) {
+		resp.WriteHeader(http.StatusInsufficientStorage)
+	} else if errors.Is(err, documents.ErrMediaTypeNotAllowed) {
+		resp.WriteHeader(http.StatusUnsupportedMediaType)
+	} else if errors.Is(err, documents.ErrQuarantined) {
+		resp.WriteHeader(http.StatusForbidden)
+	} else if errors.Is(err, documents.ErrFolderNotFound) || errors.Is(err, documents.ErrPathNotFound) {
+		resp.WriteHeader(http.StatusNotFound)
+	} else if errors.Is(err, documents.ErrNameTaken) || errors.Is(err, documents.ErrFolderNotEmpty) {
+		resp.WriteHeader(http.StatusConflict)
 	} else {
 		resp.WriteHeader(http.StatusBadRequest)
 	}
@@ -135,8 +218,18 @@ func uploadDocument(dbc *sql.DB) authorisedHandler {
 			if errors.Is(err, io.EOF) {
 				break
 			}
-			documentID, err := documents.Upload(req.Context(), dbc, userID, part, part.Header.Get("Content-Type"), part.FileName())
+			checksums, err := expectedChecksums(part.Header)
 			if err != nil {
+				respondError(resp, err)
+				return
+			}
+
+			documentID, err := documents.Upload(req.Context(), dbc, userID, documents.NewVerifyingReader(part, checksums), part.Header.Get("Content-Type"), part.FileName())
+			if errors.Is(err, documents.ErrChecksumMismatch) || errors.Is(err, documents.ErrFileTooLarge) || errors.Is(err, documents.ErrQuotaExceeded) ||
+				errors.Is(err, documents.ErrMediaTypeNotAllowed) {
+				respondError(resp, err)
+				return
+			} else if err != nil {
 				resp.WriteHeader(http.StatusInternalServerError)
 				io.WriteString(resp, err.Error())
 				log.Default().Println(err)
@@ -190,7 +283,27 @@ func updateDocument(dbc *sql.DB) authorisedHandler {
 			return
 		}
 
-		err = documents.Update(req.Context(), dbc, documentID, userID, part, part.Header.Get("Content-Type"), part.FileName())
+		checksums, err := expectedChecksums(part.Header, req.Header)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		version, err := documents.Update(req.Context(), dbc, documentID, userID, documents.NewVerifyingReader(part, checksums), part.Header.Get("Content-Type"), part.FileName(),
+			ifMatchVersions(req.Header.Get("If-Match"))...)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		resp.Header().Set("ETag", etag(version))
+		resp.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(resp)
+		err = encoder.Encode(struct {
+			Version int64
+		}{
+			Version: version,
+		})
 		if err != nil {
 			respondError(resp, err)
 			return
@@ -209,7 +322,13 @@ func listDocuments(dbc *sql.DB) authorisedHandler {
 			return
 		}
 
-		docs, err := documents.ListDocuments(req.Context(), dbc, userID)
+		filter, page, err := documentListing(req)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		docs, err := documents.ListDocuments(req.Context(), dbc, userID, filter, page)
 		if err != nil {
 			respondError(resp, err)
 			return
@@ -245,20 +364,88 @@ func getDocument(dbc *sql.DB) authorisedHandler {
 			return
 		}
 
-		doc, reader, err := documents.OpenDocument(req.Context(), dbc, documentID, userID)
+		var version int64
+		if req.FormValue("version") != "" {
+			version, err = strconv.ParseInt(req.FormValue("version"), 10, 64)
+			if err != nil {
+				respondError(resp, err)
+				return
+			}
+		}
+
+		serveDocument(dbc, userID, documentID, version, resp, req)
+	}
+}
+
+// serveDocument sends a version of a document, or its current version if version is 0.
+func serveDocument(dbc *sql.DB, userID int64, documentID int64, version int64, resp http.ResponseWriter, req *http.Request) {
+	doc, reader, err := documents.OpenDocumentVersion(req.Context(), dbc, documentID, version, userID)
+	if err != nil {
+		respondError(resp, err)
+		return
+	}
+
+	resp.Header().Set("Content-Disposition", "attachment; filename=\""+html.EscapeString(doc.FileName)+"\"")
+	resp.Header().Set("Content-Type", documents.ContentType(doc.MediaType, doc.DetectedMediaType))
+	resp.Header().Set("X-Content-Type-Options", "nosniff")
+	resp.Header().Set("ETag", etag(doc.Version))
+	if doc.SHA256 != "" {
+		resp.Header().Set("Digest", digestHeader(doc))
+	}
+	// A previous version never changes, but the current version of a document must be revalidated.
+	if version != 0 {
+		resp.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	} else {
+		resp.Header().Set("Cache-Control", "private, no-cache")
+	}
+
+	// ServeContent handles HEAD, Range and conditional requests, but ignores errors reading the contents.
+	contents := &errorRecorder{ReadSeeker: reader}
+	http.ServeContent(resp, req, "", doc.Modified, contents)
+	if errors.Is(contents.err, documents.ErrChecksumMismatch) {
+		// The body has already been sent, abort the response so the client can't mistake it for a complete one.
+		log.Default().Println(contents.err)
+		panic(http.ErrAbortHandler)
+	} else if contents.err != nil {
+		log.Default().Println(contents.err)
+	}
+	err = reader.Close()
+	if err != nil {
+		log.Default().Println(err)
+	}
+}
+
+// errorRecorder keeps the first error, other than io.EOF, returned reading from a ReadSeeker.
+type errorRecorder struct {
+	io.ReadSeeker
+	err error
+}
+
+func (e *errorRecorder) Read(p []byte) (int, error) {
+	n, err := e.ReadSeeker.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) && e.err == nil {
+		e.err = err
+	}
+	return n, err
+}
+
+func listVersions(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		documentID, err := strconv.ParseInt(req.URL.Query().Get("document_id"), 10, 64)
 		if err != nil {
 			respondError(resp, err)
 			return
 		}
 
-		resp.Header().Set("Content-Disposition", "attachment; filename=\""+html.EscapeString(doc.FileName)+"\"")
-		resp.Header().Set("Content-Type", doc.MediaType)
-		_, err = io.Copy(resp, reader)
+		versions, err := documents.ListVersions(req.Context(), dbc, documentID, userID)
 		if err != nil {
 			respondError(resp, err)
 			return
 		}
-		err = reader.Close()
+
+		resp.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(resp)
+		err = encoder.Encode(versions)
 		if err != nil {
 			respondError(resp, err)
 			return
@@ -266,6 +453,344 @@ func getDocument(dbc *sql.DB) authorisedHandler {
 	}
 }
 
+func restoreVersion(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		err := req.ParseForm()
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		documentID, err := strconv.ParseInt(req.FormValue("document_id"), 10, 64)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		version, err := strconv.ParseInt(req.FormValue("version"), 10, 64)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		newVersion, err := documents.Restore(req.Context(), dbc, documentID, version, userID)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		resp.Header().Set("ETag", etag(newVersion))
+		resp.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(resp)
+		err = encoder.Encode(struct {
+			Version int64
+		}{
+			Version: newVersion,
+		})
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+	}
+}
+
+func renameDocument(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		err := req.ParseForm()
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		documentID, err := strconv.ParseInt(req.FormValue("document_id"), 10, 64)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		err = documents.Rename(req.Context(), dbc, documentID, userID, req.FormValue("name"))
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+		resp.WriteHeader(http.StatusOK)
+	}
+}
+
+func copyDocument(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		err := req.ParseForm()
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		documentID, err := strconv.ParseInt(req.FormValue("document_id"), 10, 64)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		folderID, err := formID(req, "folder_id")
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		copyID, err := documents.Copy(req.Context(), dbc, documentID, userID, folderID, req.FormValue("name"))
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		resp.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(resp)
+		err = encoder.Encode(struct {
+			DocumentID int64
+		}{
+			DocumentID: copyID,
+		})
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+	}
+}
+
+func getRetentionPolicy(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		documentID, err := strconv.ParseInt(req.URL.Query().Get("document_id"), 10, 64)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		policy, err := documents.GetRetentionPolicy(req.Context(), dbc, documentID, userID)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		resp.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(resp)
+		err = encoder.Encode(policy)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+	}
+}
+
+func getUsage(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		usage, err := documents.Usage(req.Context(), dbc, userID)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		resp.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(resp)
+		err = encoder.Encode(usage)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+	}
+}
+
+// Sets the quota, in bytes, of the user with user_id. A quota of "default" removes it. Only administrators may set
+// quotas.
+func setQuota(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		err := req.ParseForm()
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		quotaUserID, err := strconv.ParseInt(req.FormValue("user_id"), 10, 64)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		var quota *int64
+		if req.FormValue("quota") != "default" {
+			q, err := strconv.ParseInt(req.FormValue("quota"), 10, 64)
+			if err != nil {
+				respondError(resp, err)
+				return
+			}
+			quota = &q
+		}
+
+		err = documents.SetQuota(req.Context(), dbc, userID, quotaUserID, quota)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+		resp.WriteHeader(http.StatusOK)
+	}
+}
+
+// Sets the media types the user with user_id may and may not upload, as comma separated lists in allowed and denied.
+// Only administrators may set them.
+func setMediaTypePolicy(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		err := req.ParseForm()
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		policyUserID, err := strconv.ParseInt(req.FormValue("user_id"), 10, 64)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		policy := documentModels.MediaTypePolicy{
+			Allowed: documents.ParseMediaTypes(req.FormValue("allowed")),
+			Denied:  documents.ParseMediaTypes(req.FormValue("denied")),
+		}
+		err = documents.SetMediaTypePolicy(req.Context(), dbc, userID, policyUserID, policy)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+		resp.WriteHeader(http.StatusOK)
+	}
+}
+
+// Sets the retention policy of a document if document_id is given, otherwise of all the user's documents. A policy of
+// "default" removes it.
+func setRetentionPolicy(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		err := req.ParseForm()
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		var policy *documentModels.RetentionPolicy
+		if req.FormValue("policy") != "default" {
+			p, err := documents.ParseRetentionPolicy(req.FormValue("policy"))
+			if err != nil {
+				respondError(resp, err)
+				return
+			}
+			policy = &p
+		}
+
+		if req.FormValue("document_id") == "" {
+			err = documents.SetUserRetentionPolicy(req.Context(), dbc, userID, policy)
+		} else {
+			var documentID int64
+			documentID, err = strconv.ParseInt(req.FormValue("document_id"), 10, 64)
+			if err != nil {
+				respondError(resp, err)
+				return
+			}
+			err = documents.SetRetentionPolicy(req.Context(), dbc, documentID, userID, policy)
+		}
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		resp.WriteHeader(http.StatusOK)
+	}
+}
+
+// lockForm reads the document and, if given, the lock duration of a lock request.
+func lockForm(req *http.Request) (int64, time.Duration, error) {
+	err := req.ParseForm()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	documentID, err := strconv.ParseInt(req.FormValue("document_id"), 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var duration time.Duration
+	if req.FormValue("duration") != "" {
+		duration, err = time.ParseDuration(req.FormValue("duration"))
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return documentID, duration, nil
+}
+
+func lockDocument(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		documentID, duration, err := lockForm(req)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		lock, err := documents.Lock(req.Context(), dbc, documentID, userID, duration, req.FormValue("reason"))
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		resp.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(resp)
+		err = encoder.Encode(lock)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+	}
+}
+
+func refreshLock(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		documentID, duration, err := lockForm(req)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		lock, err := documents.RefreshLock(req.Context(), dbc, documentID, userID, duration)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		resp.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(resp)
+		err = encoder.Encode(lock)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+	}
+}
+
+func unlockDocument(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		documentID, _, err := lockForm(req)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		err = documents.Unlock(req.Context(), dbc, documentID, userID)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		resp.WriteHeader(http.StatusOK)
+	}
+}
+
 func shareDocument(dbc *sql.DB) authorisedHandler {
 	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
 		err := req.ParseForm()
@@ -322,9 +847,22 @@ func shareDocument(dbc *sql.DB) authorisedHandler {
 
 func getShared(dbc *sql.DB) authorisedHandler {
 	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
-		docs, err := documents.ListSharedDocuments(req.Context(), dbc, userID)
-		if err != nil{
+		err := req.ParseForm()
+		if err != nil {
 			respondError(resp, err)
+			return
+		}
+
+		filter, page, err := documentListing(req)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		docs, err := documents.ListSharedDocuments(req.Context(), dbc, userID, filter, page)
+		if err != nil {
+			respondError(resp, err)
+			return
 		}
 
 		resp.WriteHeader(http.StatusOK)
@@ -3,15 +3,27 @@ package api
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
 	"github.com/EatonEmmerich/cloudStorage/pkg/access_control/models"
 	"github.com/EatonEmmerich/cloudStorage/pkg/db"
 	"github.com/EatonEmmerich/cloudStorage/pkg/documents"
+	documentModels "github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/scanner"
+	uploadModels "github.com/EatonEmmerich/cloudStorage/pkg/uploads/models"
 	"github.com/EatonEmmerich/cloudStorage/pkg/users"
 	fuzz "github.com/google/gofuzz"
 	"github.com/stretchr/testify/require"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
+	"net/url"
 	"strconv"
 	"testing"
 )
@@ -181,3 +193,915 @@ func TestShareDocument_PermFuzz(t *testing.T) {
 //	require.Equal(t, resp.Code, http.StatusOK)
 //
 //}
+
+func multipartBody(t *testing.T, filename string, data []byte, header textproto.MIMEHeader) (*bytes.Buffer, string) {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	if header == nil {
+		header = make(textproto.MIMEHeader)
+	}
+	header.Set("Content-Disposition", `form-data; name="file"; filename="`+filename+`"`)
+	header.Set("Content-Type", "text/plain")
+	part, err := writer.CreatePart(header)
+	require.NoError(t, err)
+	_, err = part.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	return body, writer.FormDataContentType()
+}
+
+func TestUploadDocument_Checksums(t *testing.T) {
+	dbc := db.ConnectForTesting(t)
+	ctx := context.Background()
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	md5Sum := md5.Sum([]byte("contents"))
+	sha256Sum := sha256.Sum256([]byte("contents"))
+	tests := []struct {
+		name         string
+		header       textproto.MIMEHeader
+		expectedCode int
+	}{
+		{
+			name:         "None",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Content-MD5",
+			header:       textproto.MIMEHeader{"Content-Md5": {base64.StdEncoding.EncodeToString(md5Sum[:])}},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Digest",
+			header:       textproto.MIMEHeader{"Digest": {"sha-256=" + base64.StdEncoding.EncodeToString(sha256Sum[:])}},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Mismatch",
+			header:       textproto.MIMEHeader{"Digest": {"SHA-256=" + base64.StdEncoding.EncodeToString(md5Sum[:])}},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Invalid",
+			header:       textproto.MIMEHeader{"Content-Md5": {"not base64"}},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, contentType := multipartBody(t, "file.txt", []byte("contents"), tt.header)
+			req := httptest.NewRequest("POST", "/upload", body)
+			req.Header.Set("Content-Type", contentType)
+			resp := httptest.NewRecorder()
+			uploadDocument(dbc)(userID, resp, req)
+
+			require.Equal(t, tt.expectedCode, resp.Code, resp.Body.String())
+		})
+	}
+}
+
+func TestGetDocument_Checksums(t *testing.T) {
+	dbc := db.ConnectForTesting(t)
+	ctx := context.Background()
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	documentID, err := documents.Upload(ctx, dbc, userID, io.NopCloser(bytes.NewReader([]byte("contents"))), "text/plain", "file.txt")
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/document?document_id="+strconv.Itoa(documentID), nil)
+	resp := httptest.NewRecorder()
+	getDocument(dbc)(userID, resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "contents", resp.Body.String())
+	sha256Sum := sha256.Sum256([]byte("contents"))
+	md5Sum := md5.Sum([]byte("contents"))
+	require.Equal(t, `"v1"`, resp.Header().Get("ETag"))
+	require.Equal(t, "sha-256="+base64.StdEncoding.EncodeToString(sha256Sum[:])+", md5="+base64.StdEncoding.EncodeToString(md5Sum[:]), resp.Header().Get("Digest"))
+}
+
+func TestDocumentVersions(t *testing.T) {
+	dbc := db.ConnectForTesting(t)
+	ctx := context.Background()
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	documentID, err := documents.Upload(ctx, dbc, userID, io.NopCloser(bytes.NewReader([]byte("first"))), "text/plain", "file.txt")
+	require.NoError(t, err)
+	_, err = documents.Update(ctx, dbc, int64(documentID), userID, io.NopCloser(bytes.NewReader([]byte("second"))), "text/plain", "file.txt")
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/document/versions?document_id="+strconv.Itoa(documentID), nil)
+	resp := httptest.NewRecorder()
+	listVersions(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var versions []documentModels.Version
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&versions))
+	require.Len(t, versions, 2)
+	require.Equal(t, int64(2), versions[0].Version)
+	require.Equal(t, userID, versions[1].Uploader)
+
+	req = httptest.NewRequest("GET", "/document?document_id="+strconv.Itoa(documentID)+"&version=1", nil)
+	resp = httptest.NewRecorder()
+	getDocument(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "first", resp.Body.String())
+
+	req = httptest.NewRequest("GET", "/document?document_id="+strconv.Itoa(documentID)+"&version=3", nil)
+	resp = httptest.NewRecorder()
+	getDocument(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestRestoreVersion(t *testing.T) {
+	dbc := db.ConnectForTesting(t)
+	ctx := context.Background()
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	documentID, err := documents.Upload(ctx, dbc, userID, io.NopCloser(bytes.NewReader([]byte("first"))), "text/plain", "file.txt")
+	require.NoError(t, err)
+	_, err = documents.Update(ctx, dbc, int64(documentID), userID, io.NopCloser(bytes.NewReader([]byte("second"))), "text/plain", "file.txt")
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/document/restore?document_id="+strconv.Itoa(documentID)+"&version=1", nil)
+	resp := httptest.NewRecorder()
+	restoreVersion(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.JSONEq(t, `{"Version":3}`, resp.Body.String())
+
+	req = httptest.NewRequest("GET", "/document?document_id="+strconv.Itoa(documentID), nil)
+	resp = httptest.NewRecorder()
+	getDocument(dbc)(userID, resp, req)
+	require.Equal(t, "first", resp.Body.String())
+}
+
+func TestUpdateDocument_IfMatch(t *testing.T) {
+	dbc := db.ConnectForTesting(t)
+	ctx := context.Background()
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	documentID, err := documents.Upload(ctx, dbc, userID, io.NopCloser(bytes.NewReader([]byte("first"))), "text/plain", "file.txt")
+	require.NoError(t, err)
+
+	update := func(ifMatch string, contents string) *httptest.ResponseRecorder {
+		body, contentType := multipartBody(t, "file.txt", []byte(contents), nil)
+		req := httptest.NewRequest("PATCH", "/update?document_id="+strconv.Itoa(documentID), body)
+		req.Header.Set("Content-Type", contentType)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		resp := httptest.NewRecorder()
+		updateDocument(dbc)(userID, resp, req)
+		return resp
+	}
+
+	req := httptest.NewRequest("GET", "/document?document_id="+strconv.Itoa(documentID), nil)
+	resp := httptest.NewRecorder()
+	getDocument(dbc)(userID, resp, req)
+	tag := resp.Header().Get("ETag")
+	require.Equal(t, `"v1"`, tag)
+
+	resp = update(tag, "second")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, `"v2"`, resp.Header().Get("ETag"))
+	require.JSONEq(t, `{"Version":2}`, resp.Body.String())
+
+	// A client still holding the first version's tag must not overwrite the second.
+	resp = update(tag, "conflicting")
+	require.Equal(t, http.StatusPreconditionFailed, resp.Code)
+	resp = update(`W/"v2"`, "weak")
+	require.Equal(t, http.StatusPreconditionFailed, resp.Code)
+
+	resp = update(`"v1", "v2"`, "third")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, `"v3"`, resp.Header().Get("ETag"))
+
+	resp = update("", "fourth")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, `"v4"`, resp.Header().Get("ETag"))
+
+	req = httptest.NewRequest("GET", "/document?document_id="+strconv.Itoa(documentID), nil)
+	resp = httptest.NewRecorder()
+	getDocument(dbc)(userID, resp, req)
+	require.Equal(t, "fourth", resp.Body.String())
+}
+
+func TestLockDocument(t *testing.T) {
+	dbc := db.ConnectForTesting(t)
+	ctx := context.Background()
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+
+	documentID, err := documents.Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("first"))), "text/plain", "file.txt")
+	require.NoError(t, err)
+	doc, err := documents.Get(ctx, dbc, int64(documentID))
+	require.NoError(t, err)
+	require.NoError(t, access_control.ShareDocument(ctx, dbc, doc, john, jane, models.READ|models.WRITE))
+
+	req := httptest.NewRequest("POST", "/document/lock?document_id="+strconv.Itoa(documentID)+"&duration=10m&reason=editing", nil)
+	resp := httptest.NewRecorder()
+	lockDocument(dbc)(jane, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	body, contentType := multipartBody(t, "file.txt", []byte("second"), nil)
+	req = httptest.NewRequest("PATCH", "/update?document_id="+strconv.Itoa(documentID), body)
+	req.Header.Set("Content-Type", contentType)
+	resp = httptest.NewRecorder()
+	updateDocument(dbc)(john, resp, req)
+	require.Equal(t, http.StatusLocked, resp.Code)
+
+	req = httptest.NewRequest("GET", "/documents", nil)
+	resp = httptest.NewRecorder()
+	listDocuments(dbc)(john, resp, req)
+	var page documentModels.DocumentPage
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	require.Len(t, page.Documents, 1)
+	require.NotNil(t, page.Documents[0].Lock)
+	require.Equal(t, jane, page.Documents[0].Lock.User)
+	require.Equal(t, "editing", page.Documents[0].Lock.Reason)
+
+	req = httptest.NewRequest("POST", "/document/unlock?document_id="+strconv.Itoa(documentID), nil)
+	resp = httptest.NewRecorder()
+	unlockDocument(dbc)(john, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	req = httptest.NewRequest("POST", "/document/lock/refresh?document_id="+strconv.Itoa(documentID), nil)
+	resp = httptest.NewRecorder()
+	refreshLock(dbc)(jane, resp, req)
+	require.Equal(t, http.StatusConflict, resp.Code)
+}
+
+func TestTusUpload(t *testing.T) {
+	dbc := db.ConnectForTesting(t)
+	ctx := context.Background()
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	handler := tus(dbc)
+	request := func(method string, path string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.SetBasicAuth("john", "password")
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp := httptest.NewRecorder()
+		handler(resp, req)
+		return resp
+	}
+
+	resp := request("OPTIONS", "/tus/", nil, nil)
+	require.Equal(t, http.StatusNoContent, resp.Code)
+	require.Equal(t, "creation,termination,expiration", resp.Header().Get("Tus-Extension"))
+
+	resp = request("POST", "/tus/", nil, map[string]string{
+		"Upload-Length":   "11",
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("file.txt")) + ",filetype " + base64.StdEncoding.EncodeToString([]byte("text/plain")),
+	})
+	require.Equal(t, http.StatusCreated, resp.Code)
+	location := resp.Header().Get("Location")
+	require.Regexp(t, `^/tus/[0-9a-f]{32}$`, location)
+	require.NotEmpty(t, resp.Header().Get("Upload-Expires"))
+
+	appendData := func(offset string, data string) *httptest.ResponseRecorder {
+		return request("PATCH", location, []byte(data), map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": offset,
+		})
+	}
+
+	resp = appendData("0", "hello ")
+	require.Equal(t, http.StatusNoContent, resp.Code)
+	require.Equal(t, "6", resp.Header().Get("Upload-Offset"))
+
+	resp = appendData("0", "world")
+	require.Equal(t, http.StatusConflict, resp.Code)
+
+	resp = request("HEAD", location, nil, nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "6", resp.Header().Get("Upload-Offset"))
+	require.Equal(t, "11", resp.Header().Get("Upload-Length"))
+
+	resp = appendData("6", "world")
+	require.Equal(t, http.StatusNoContent, resp.Code)
+	require.Equal(t, "11", resp.Header().Get("Upload-Offset"))
+	documentID := resp.Header().Get("Document-ID")
+	require.NotEmpty(t, documentID)
+
+	id, err := strconv.ParseInt(documentID, 10, 64)
+	require.NoError(t, err)
+	doc, reader, err := documents.OpenDocument(ctx, dbc, id, userID)
+	require.NoError(t, err)
+	defer reader.Close()
+	contents, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(contents))
+	require.Equal(t, "file.txt", doc.FileName)
+	require.Equal(t, "text/plain", doc.MediaType)
+
+	resp = request("POST", "/tus/", nil, map[string]string{"Upload-Length": "5"})
+	require.Equal(t, http.StatusCreated, resp.Code)
+	location = resp.Header().Get("Location")
+
+	resp = request("DELETE", location, nil, nil)
+	require.Equal(t, http.StatusNoContent, resp.Code)
+
+	resp = request("HEAD", location, nil, nil)
+	require.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestMultipartUpload(t *testing.T) {
+	dbc := db.ConnectForTesting(t)
+	ctx := context.Background()
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/multipart/initiate?filename=file.txt&media_type=text/plain", nil)
+	resp := httptest.NewRecorder()
+	initiateMultipart(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	var upload uploadModels.MultipartUpload
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&upload))
+
+	sendPart := func(number int, data string, contentMD5 string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/multipart/part?upload_id="+upload.ID+"&part_number="+strconv.Itoa(number), bytes.NewReader([]byte(data)))
+		req.Header.Set("Content-MD5", contentMD5)
+		resp := httptest.NewRecorder()
+		uploadPart(dbc)(userID, resp, req)
+		return resp
+	}
+
+	sum := md5.Sum([]byte("hello "))
+	resp = sendPart(2, "world", base64.StdEncoding.EncodeToString(sum[:]))
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	resp = sendPart(2, "world", "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	resp = sendPart(1, "hello ", base64.StdEncoding.EncodeToString(sum[:]))
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, `"`+hex.EncodeToString(sum[:])+`"`, resp.Header().Get("ETag"))
+
+	req = httptest.NewRequest("GET", "/multipart/parts?upload_id="+upload.ID, nil)
+	resp = httptest.NewRecorder()
+	listParts(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	var parts []uploadModels.Part
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&parts))
+	require.Len(t, parts, 2)
+
+	body, err := json.Marshal(map[string]interface{}{"Parts": []uploadModels.CompletedPart{
+		{Number: 1, ETag: parts[0].ETag},
+		{Number: 2, ETag: parts[1].ETag},
+	}})
+	require.NoError(t, err)
+	req = httptest.NewRequest("POST", "/multipart/complete?upload_id="+upload.ID, bytes.NewReader(body))
+	resp = httptest.NewRecorder()
+	completeMultipart(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	var completed struct {
+		DocumentID int64
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&completed))
+
+	req = httptest.NewRequest("GET", "/document?document_id="+strconv.FormatInt(completed.DocumentID, 10), nil)
+	resp = httptest.NewRecorder()
+	getDocument(dbc)(userID, resp, req)
+	require.Equal(t, "hello world", resp.Body.String())
+
+	req = httptest.NewRequest("POST", "/multipart/abort?upload_id="+upload.ID, nil)
+	resp = httptest.NewRecorder()
+	abortMultipart(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestGetDocument_Ranges(t *testing.T) {
+	dbc := db.ConnectForTesting(t)
+	ctx := context.Background()
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	documentID, err := documents.Upload(ctx, dbc, userID, io.NopCloser(bytes.NewReader([]byte("0123456789"))), "text/plain", "file.txt")
+	require.NoError(t, err)
+
+	download := func(method string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/document?document_id="+strconv.Itoa(documentID), nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp := httptest.NewRecorder()
+		getDocument(dbc)(userID, resp, req)
+		return resp
+	}
+
+	resp := download("GET", nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "bytes", resp.Header().Get("Accept-Ranges"))
+	require.Equal(t, "private, no-cache", resp.Header().Get("Cache-Control"))
+	lastModified := resp.Header().Get("Last-Modified")
+	require.NotEmpty(t, lastModified)
+
+	resp = download("HEAD", nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "10", resp.Header().Get("Content-Length"))
+	require.Equal(t, `"v1"`, resp.Header().Get("ETag"))
+	require.Empty(t, resp.Body.String())
+
+	resp = download("GET", map[string]string{"Range": "bytes=2-5"})
+	require.Equal(t, http.StatusPartialContent, resp.Code)
+	require.Equal(t, "bytes 2-5/10", resp.Header().Get("Content-Range"))
+	require.Equal(t, "2345", resp.Body.String())
+
+	resp = download("GET", map[string]string{"Range": "bytes=7-,0-1"})
+	require.Equal(t, http.StatusPartialContent, resp.Code)
+	require.Contains(t, resp.Header().Get("Content-Type"), "multipart/byteranges")
+	require.Contains(t, resp.Body.String(), "Content-Range: bytes 7-9/10")
+	require.Contains(t, resp.Body.String(), "789")
+	require.Contains(t, resp.Body.String(), "Content-Range: bytes 0-1/10")
+
+	resp = download("GET", map[string]string{"Range": "bytes=20-30"})
+	require.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.Code)
+
+	resp = download("GET", map[string]string{"If-None-Match": `"v1"`})
+	require.Equal(t, http.StatusNotModified, resp.Code)
+	require.Empty(t, resp.Body.String())
+
+	resp = download("GET", map[string]string{"If-Modified-Since": lastModified})
+	require.Equal(t, http.StatusNotModified, resp.Code)
+
+	resp = download("GET", map[string]string{"If-None-Match": `"v2"`})
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "0123456789", resp.Body.String())
+
+	req := httptest.NewRequest("GET", "/document?document_id="+strconv.Itoa(documentID)+"&version=1", nil)
+	resp = httptest.NewRecorder()
+	getDocument(dbc)(userID, resp, req)
+	require.Equal(t, "private, max-age=31536000, immutable", resp.Header().Get("Cache-Control"))
+}
+
+func TestUsage(t *testing.T) {
+	dbc := db.ConnectForTesting(t)
+	ctx := context.Background()
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	quota := int64(5)
+	require.NoError(t, users.SetQuota(ctx, dbc, userID, &quota))
+
+	body, contentType := multipartBody(t, "file.txt", []byte("123456"), nil)
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", contentType)
+	resp := httptest.NewRecorder()
+	uploadDocument(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusInsufficientStorage, resp.Code)
+
+	body, contentType = multipartBody(t, "file.txt", []byte("123"), nil)
+	req = httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", contentType)
+	resp = httptest.NewRecorder()
+	uploadDocument(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	req = httptest.NewRequest("GET", "/usage", nil)
+	resp = httptest.NewRecorder()
+	getUsage(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	var usage documentModels.Usage
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&usage))
+	require.Equal(t, int64(3), usage.Used)
+	require.Equal(t, int64(1), usage.Files)
+	require.Equal(t, int64(2), *usage.Remaining)
+
+	req = httptest.NewRequest("POST", "/quota?user_id="+strconv.FormatInt(userID, 10)+"&quota=default", nil)
+	resp = httptest.NewRecorder()
+	setQuota(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusUnauthorized, resp.Code)
+}
+
+func TestGetDocument_DetectedMediaType(t *testing.T) {
+	dbc := db.ConnectForTesting(t)
+	ctx := context.Background()
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="file"; filename="cat.png"`)
+	header.Set("Content-Type", "image/png")
+	part, err := writer.CreatePart(header)
+	require.NoError(t, err)
+	_, err = part.Write([]byte("<html><script>alert(1)</script></html>"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp := httptest.NewRecorder()
+	uploadDocument(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	var uploaded struct {
+		DocumentIDs []int
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&uploaded))
+
+	req = httptest.NewRequest("GET", "/document?document_id="+strconv.Itoa(uploaded.DocumentIDs[0]), nil)
+	resp = httptest.NewRecorder()
+	getDocument(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "application/octet-stream", resp.Header().Get("Content-Type"))
+	require.Equal(t, "nosniff", resp.Header().Get("X-Content-Type-Options"))
+}
+
+// infectedScanner finds every file infected.
+type infectedScanner struct{}
+
+func (infectedScanner) Scan(context.Context, io.Reader) (scanner.Result, error) {
+	return scanner.Result{Infected: true, Signature: "Test.Infected"}, nil
+}
+
+func TestGetDocument_Quarantined(t *testing.T) {
+	dbc := db.ConnectForTesting(t)
+	ctx := context.Background()
+
+	documents.SetScanner(infectedScanner{})
+	t.Cleanup(func() { documents.SetScanner(nil) })
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+
+	documentID, err := documents.Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("infected"))), "text/plain", "file.txt")
+	require.NoError(t, err)
+	doc, err := documents.Get(ctx, dbc, int64(documentID))
+	require.NoError(t, err)
+	require.NoError(t, access_control.ShareDocument(ctx, dbc, doc, john, jane, models.READ))
+
+	req := httptest.NewRequest("GET", "/document?document_id="+strconv.Itoa(documentID), nil)
+	resp := httptest.NewRecorder()
+	getDocument(dbc)(jane, resp, req)
+	require.Equal(t, http.StatusForbidden, resp.Code)
+
+	req = httptest.NewRequest("GET", "/document?document_id="+strconv.Itoa(documentID), nil)
+	resp = httptest.NewRecorder()
+	getDocument(dbc)(john, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "infected", resp.Body.String())
+}
+
+func TestFiles(t *testing.T) {
+	dbc := db.ConnectForTesting(t)
+	ctx := context.Background()
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	create := func(parentID int64, name string) documentModels.Folder {
+		req := httptest.NewRequest("POST", "/folder/create?parent_id="+strconv.FormatInt(parentID, 10)+"&name="+name, nil)
+		resp := httptest.NewRecorder()
+		createFolder(dbc)(userID, resp, req)
+		require.Equal(t, http.StatusOK, resp.Code)
+		var folder documentModels.Folder
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&folder))
+		return folder
+	}
+	projects := create(0, "projects")
+	year := create(projects.ID, "2024")
+
+	req := httptest.NewRequest("POST", "/folder/create?name=projects", nil)
+	resp := httptest.NewRecorder()
+	createFolder(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusConflict, resp.Code)
+
+	documentID, err := documents.Upload(ctx, dbc, userID, io.NopCloser(bytes.NewReader([]byte("report"))), "text/plain", "report.pdf")
+	require.NoError(t, err)
+	req = httptest.NewRequest("POST", "/document/move?document_id="+strconv.Itoa(documentID)+"&folder_id="+strconv.FormatInt(year.ID, 10), nil)
+	resp = httptest.NewRecorder()
+	moveDocument(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	req = httptest.NewRequest("GET", "/files/projects/2024/report.pdf", nil)
+	resp = httptest.NewRecorder()
+	getFile(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "report", resp.Body.String())
+
+	req = httptest.NewRequest("GET", "/files/projects/2024", nil)
+	resp = httptest.NewRecorder()
+	getFile(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	var contents documentModels.FolderContents
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&contents))
+	require.Equal(t, year.ID, contents.Folder.ID)
+	require.Len(t, contents.Documents, 1)
+
+	req = httptest.NewRequest("GET", "/files/projects/2023/report.pdf", nil)
+	resp = httptest.NewRecorder()
+	getFile(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusNotFound, resp.Code)
+
+	req = httptest.NewRequest("POST", "/folder/delete?folder_id="+strconv.FormatInt(year.ID, 10), nil)
+	resp = httptest.NewRecorder()
+	deleteFolder(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusConflict, resp.Code)
+
+	req = httptest.NewRequest("POST", "/folder/rename?folder_id="+strconv.FormatInt(year.ID, 10)+"&name=2025", nil)
+	resp = httptest.NewRecorder()
+	renameFolder(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	req = httptest.NewRequest("GET", "/folder?folder_id="+strconv.FormatInt(projects.ID, 10), nil)
+	resp = httptest.NewRecorder()
+	listFolder(dbc)(userID, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&contents))
+	require.Len(t, contents.Folders, 1)
+	require.Equal(t, "2025", contents.Folders[0].Name)
+}
+
+func TestRenameAndCopyDocument(t *testing.T) {
+	dbc := db.ConnectForTesting(t)
+	ctx := context.Background()
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+
+	documentID, err := documents.Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("contents"))), "text/plain", "draft.txt")
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/document/rename?document_id="+strconv.Itoa(documentID)+"&name=final.txt", nil)
+	resp := httptest.NewRecorder()
+	renameDocument(dbc)(john, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	req = httptest.NewRequest("POST", "/document/copy?document_id="+strconv.Itoa(documentID), nil)
+	resp = httptest.NewRecorder()
+	copyDocument(dbc)(jane, resp, req)
+	require.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	doc, err := documents.Get(ctx, dbc, int64(documentID))
+	require.NoError(t, err)
+	require.NoError(t, access_control.ShareDocument(ctx, dbc, doc, john, jane, models.READ))
+
+	req = httptest.NewRequest("POST", "/document/copy?document_id="+strconv.Itoa(documentID), nil)
+	resp = httptest.NewRecorder()
+	copyDocument(dbc)(jane, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	var copied struct {
+		DocumentID int64
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&copied))
+
+	req = httptest.NewRequest("GET", "/files/final.txt", nil)
+	resp = httptest.NewRecorder()
+	getFile(dbc)(jane, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "contents", resp.Body.String())
+}
+
+func TestTrash(t *testing.T) {
+	dbc := db.ConnectForTesting(t)
+	ctx := context.Background()
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+
+	documentID, err := documents.Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("contents"))), "text/plain", "old.txt")
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/document/delete?document_id="+strconv.Itoa(documentID), nil)
+	resp := httptest.NewRecorder()
+	deleteDocument(dbc)(jane, resp, req)
+	require.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	req = httptest.NewRequest("POST", "/document/delete?document_id="+strconv.Itoa(documentID), nil)
+	resp = httptest.NewRecorder()
+	deleteDocument(dbc)(john, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	req = httptest.NewRequest("GET", "/document?document_id="+strconv.Itoa(documentID), nil)
+	resp = httptest.NewRecorder()
+	getDocument(dbc)(john, resp, req)
+	require.Equal(t, http.StatusNotFound, resp.Code)
+
+	req = httptest.NewRequest("GET", "/trash", nil)
+	resp = httptest.NewRecorder()
+	listTrash(dbc)(john, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	var docs []documentModels.Document
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&docs))
+	require.Len(t, docs, 1)
+	require.Equal(t, int64(documentID), docs[0].ID)
+
+	req = httptest.NewRequest("POST", "/trash/restore?document_id="+strconv.Itoa(documentID), nil)
+	resp = httptest.NewRecorder()
+	restoreDeleted(dbc)(john, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	req = httptest.NewRequest("GET", "/document?document_id="+strconv.Itoa(documentID), nil)
+	resp = httptest.NewRecorder()
+	getDocument(dbc)(john, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "contents", resp.Body.String())
+
+	req = httptest.NewRequest("POST", "/trash/purge?document_id="+strconv.Itoa(documentID), nil)
+	resp = httptest.NewRecorder()
+	purgeDocument(dbc)(john, resp, req)
+	require.Equal(t, http.StatusNotFound, resp.Code)
+
+	require.NoError(t, documents.Delete(ctx, dbc, int64(documentID), john))
+	req = httptest.NewRequest("POST", "/trash/purge?document_id="+strconv.Itoa(documentID), nil)
+	resp = httptest.NewRecorder()
+	purgeDocument(dbc)(john, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	req = httptest.NewRequest("POST", "/trash/restore?document_id="+strconv.Itoa(documentID), nil)
+	resp = httptest.NewRecorder()
+	restoreDeleted(dbc)(john, resp, req)
+	require.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestLabels(t *testing.T) {
+	dbc := db.ConnectForTesting(t)
+	ctx := context.Background()
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+
+	invoiceID, err := documents.Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("invoice"))), "text/plain", "invoice.txt")
+	require.NoError(t, err)
+	_, err = documents.Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("other"))), "text/plain", "other.txt")
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/document/tags?document_id="+strconv.Itoa(invoiceID)+"&tag=invoice&tag=2024", nil)
+	resp := httptest.NewRecorder()
+	setTags(dbc)(john, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	req = httptest.NewRequest("POST", "/document/metadata?document_id="+strconv.Itoa(invoiceID)+"&meta.client=acme", nil)
+	resp = httptest.NewRecorder()
+	setMetadata(dbc)(jane, resp, req)
+	require.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	req = httptest.NewRequest("POST", "/document/metadata?document_id="+strconv.Itoa(invoiceID)+"&meta.client=acme", nil)
+	resp = httptest.NewRecorder()
+	setMetadata(dbc)(john, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	req = httptest.NewRequest("GET", "/document/labels?document_id="+strconv.Itoa(invoiceID), nil)
+	resp = httptest.NewRecorder()
+	getLabels(dbc)(john, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	var labels documentModels.Labels
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&labels))
+	require.Equal(t, []string{"2024", "invoice"}, labels.Tags)
+	require.Equal(t, map[string]string{"client": "acme"}, labels.Metadata)
+
+	req = httptest.NewRequest("GET", "/documents?tag=invoice&meta.client=acme", nil)
+	resp = httptest.NewRecorder()
+	listDocuments(dbc)(john, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	var page documentModels.DocumentPage
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	require.Len(t, page.Documents, 1)
+	require.Equal(t, int64(invoiceID), page.Documents[0].ID)
+
+	doc, err := documents.Get(ctx, dbc, int64(invoiceID))
+	require.NoError(t, err)
+	require.NoError(t, access_control.ShareDocument(ctx, dbc, doc, john, jane, models.READ))
+
+	req = httptest.NewRequest("GET", "/shared?meta.client=initech", nil)
+	resp = httptest.NewRecorder()
+	getShared(dbc)(jane, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	page = documentModels.DocumentPage{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	require.Empty(t, page.Documents)
+
+	req = httptest.NewRequest("GET", "/shared?tag=2024", nil)
+	resp = httptest.NewRecorder()
+	getShared(dbc)(jane, resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	require.Len(t, page.Documents, 1)
+}
+
+func TestSearch(t *testing.T) {
+	dbc := db.ConnectForTesting(t)
+	ctx := context.Background()
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+
+	documentID, err := documents.Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("minutes of the board meeting"))), "text/plain", "minutes.txt")
+	require.NoError(t, err)
+
+	search := func(userID int64, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/search?q="+url.QueryEscape(query), nil)
+		resp := httptest.NewRecorder()
+		searchDocuments(dbc)(userID, resp, req)
+		return resp
+	}
+
+	resp := search(jane, "board meeting")
+	require.Equal(t, http.StatusOK, resp.Code)
+	var docs []documentModels.Document
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&docs))
+	require.Empty(t, docs)
+
+	doc, err := documents.Get(ctx, dbc, int64(documentID))
+	require.NoError(t, err)
+	require.NoError(t, access_control.ShareDocument(ctx, dbc, doc, john, jane, models.READ))
+
+	resp = search(jane, "board meeting")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&docs))
+	require.Len(t, docs, 1)
+	require.Equal(t, int64(documentID), docs[0].ID)
+	require.Equal(t, "minutes.txt", docs[0].FileName)
+
+	resp = search(jane, "")
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestListDocuments_Pages(t *testing.T) {
+	dbc := db.ConnectForTesting(t)
+	ctx := context.Background()
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	for _, name := range []string{"c.txt", "a.txt", "b.txt"} {
+		_, err := documents.Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte(name))), "text/plain", name)
+		require.NoError(t, err)
+	}
+	_, err = documents.Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("{}"))), "application/json", "d.json")
+	require.NoError(t, err)
+
+	list := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/documents?"+query, nil)
+		resp := httptest.NewRecorder()
+		listDocuments(dbc)(john, resp, req)
+		return resp
+	}
+
+	var names []string
+	query := "limit=2&sort=name&order=desc"
+	for {
+		resp := list(query)
+		require.Equal(t, http.StatusOK, resp.Code)
+		var page documentModels.DocumentPage
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+		require.LessOrEqual(t, len(page.Documents), 2)
+		for _, doc := range page.Documents {
+			names = append(names, doc.FileName)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query = "limit=2&sort=name&order=desc&cursor=" + url.QueryEscape(page.NextCursor)
+	}
+	require.Equal(t, []string{"d.json", "c.txt", "b.txt", "a.txt"}, names)
+
+	resp := list("media_type=text/*&name_prefix=b")
+	require.Equal(t, http.StatusOK, resp.Code)
+	var page documentModels.DocumentPage
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	require.Len(t, page.Documents, 1)
+	require.Equal(t, "b.txt", page.Documents[0].FileName)
+	require.Empty(t, page.NextCursor)
+
+	resp = list("min_size=6")
+	require.Equal(t, http.StatusOK, resp.Code)
+	page = documentModels.DocumentPage{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	require.Len(t, page.Documents, 0)
+
+	for _, query := range []string{"limit=1001", "sort=colour", "order=up", "min_size=-1", "cursor=nonsense"} {
+		require.Equal(t, http.StatusBadRequest, list(query).Code, query)
+	}
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents"
+	"net/http"
+	"strconv"
+)
+
+func searchDocuments(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		var limit int
+		if value := req.FormValue("limit"); value != "" {
+			var err error
+			limit, err = strconv.Atoi(value)
+			if err != nil {
+				respondError(resp, err)
+				return
+			}
+		}
+
+		docs, err := documents.Search(req.Context(), dbc, userID, req.FormValue("q"), limit)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		resp.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(resp)
+		err = encoder.Encode(docs)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+	}
+}
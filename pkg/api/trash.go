@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents"
+	"net/http"
+	"strconv"
+)
+
+func deleteDocument(dbc *sql.DB) authorisedHandler {
+	return documentAction(dbc, documents.Delete)
+}
+
+func restoreDeleted(dbc *sql.DB) authorisedHandler {
+	return documentAction(dbc, documents.RestoreDeleted)
+}
+
+func purgeDocument(dbc *sql.DB) authorisedHandler {
+	return documentAction(dbc, documents.Purge)
+}
+
+// documentAction handles requests that act on the document named by their document_id.
+func documentAction(dbc *sql.DB, action func(ctx context.Context, dbc *sql.DB, documentID int64, userID int64) error) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		err := req.ParseForm()
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		documentID, err := strconv.ParseInt(req.FormValue("document_id"), 10, 64)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		err = action(req.Context(), dbc, documentID, userID)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+		resp.WriteHeader(http.StatusOK)
+	}
+}
+
+func listTrash(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		docs, err := documents.ListTrash(req.Context(), dbc, userID)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		resp.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(resp)
+		err = encoder.Encode(docs)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents"
+	"github.com/EatonEmmerich/cloudStorage/pkg/uploads"
+	"github.com/EatonEmmerich/cloudStorage/pkg/uploads/models"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Resumable uploads follow version 1.0.0 of the tus protocol (https://tus.io/protocols/resumable-upload), with the
+// creation, termination and expiration extensions. Uploads are created by POSTing to tusPath, and live at tusPath
+// followed by their ID.
+const (
+	tusPath       = "/tus/"
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+)
+
+func tus(dbc *sql.DB) http.HandlerFunc {
+	handlers := map[string]authorisedHandler{
+		http.MethodPost:   createUpload(dbc),
+		http.MethodHead:   uploadOffset(dbc),
+		http.MethodPatch:  appendUpload(dbc),
+		http.MethodDelete: terminateUpload(dbc),
+	}
+
+	return func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Tus-Resumable", tusVersion)
+		if req.Method == http.MethodOptions {
+			resp.Header().Set("Tus-Version", tusVersion)
+			resp.Header().Set("Tus-Extension", tusExtensions)
+			if max := documents.MaxFileSize(); max > 0 {
+				resp.Header().Set("Tus-Max-Size", strconv.FormatInt(max, 10))
+			}
+			resp.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		handler, ok := handlers[req.Method]
+		if !ok {
+			resp.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if req.Header.Get("Tus-Resumable") != tusVersion {
+			resp.Header().Set("Tus-Version", tusVersion)
+			resp.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		basicAuth(dbc, handler)(resp, req)
+	}
+}
+
+func createUpload(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != tusPath {
+			resp.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		length, err := strconv.ParseInt(req.Header.Get("Upload-Length"), 10, 64)
+		if err != nil {
+			respondError(resp, fmt.Errorf("invalid Upload-Length: %w", err))
+			return
+		}
+
+		metadata, err := uploadMetadata(req.Header.Get("Upload-Metadata"))
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		var documentID int64
+		if id, ok := metadata["document_id"]; ok {
+			documentID, err = strconv.ParseInt(id, 10, 64)
+			if err != nil {
+				respondError(resp, fmt.Errorf("invalid document_id: %w", err))
+				return
+			}
+		}
+
+		upload, err := uploads.Create(req.Context(), dbc, userID, length, documentID, metadata["filetype"], metadata["filename"])
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		resp.Header().Set("Location", tusPath+upload.ID)
+		setUploadHeaders(resp, upload)
+		resp.WriteHeader(http.StatusCreated)
+	}
+}
+
+func uploadOffset(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		upload, err := uploads.Get(req.Context(), dbc, strings.TrimPrefix(req.URL.Path, tusPath), userID)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		resp.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		resp.Header().Set("Cache-Control", "no-store")
+		setUploadHeaders(resp, upload)
+		resp.WriteHeader(http.StatusOK)
+	}
+}
+
+func appendUpload(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Content-Type") != "application/offset+octet-stream" {
+			resp.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		offset, err := strconv.ParseInt(req.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			respondError(resp, fmt.Errorf("invalid Upload-Offset: %w", err))
+			return
+		}
+
+		upload, err := uploads.Append(req.Context(), dbc, strings.TrimPrefix(req.URL.Path, tusPath), userID, offset, req.Body)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+
+		setUploadHeaders(resp, upload)
+		resp.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func terminateUpload(dbc *sql.DB) authorisedHandler {
+	return func(userID int64, resp http.ResponseWriter, req *http.Request) {
+		err := uploads.Terminate(req.Context(), dbc, strings.TrimPrefix(req.URL.Path, tusPath), userID)
+		if err != nil {
+			respondError(resp, err)
+			return
+		}
+		resp.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// setUploadHeaders sets the headers describing an upload's progress. Once an upload completes, Document-ID holds the
+// document it was stored as.
+func setUploadHeaders(resp http.ResponseWriter, upload models.Upload) {
+	resp.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if upload.Result != 0 {
+		resp.Header().Set("Document-ID", strconv.FormatInt(upload.Result, 10))
+		return
+	}
+	resp.Header().Set("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+}
+
+// uploadMetadata decodes an Upload-Metadata header, a comma separated list of keys each followed by an optional
+// base64 encoded value.
+func uploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			metadata[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid Upload-Metadata value for %s: %w", fields[0], err)
+			}
+			metadata[fields[0]] = string(value)
+		default:
+			return nil, errors.New("invalid Upload-Metadata: " + pair)
+		}
+	}
+	return metadata, nil
+}
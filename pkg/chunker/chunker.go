@@ -0,0 +1,144 @@
+package chunker
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// Options bound the size of chunks. AvgSize must be a power of two.
+type Options struct {
+	MinSize int
+	AvgSize int
+	MaxSize int
+}
+
+var DefaultOptions = Options{
+	MinSize: 16 << 10,
+	AvgSize: 64 << 10,
+	MaxSize: 256 << 10,
+}
+
+func (o Options) validate() error {
+	if o.MinSize <= 0 || o.MinSize > o.AvgSize || o.AvgSize > o.MaxSize {
+		return errors.New("chunk sizes must satisfy 0 < min <= avg <= max")
+	}
+	if o.AvgSize&(o.AvgSize-1) != 0 {
+		return errors.New("average chunk size must be a power of two")
+	}
+	return nil
+}
+
+// gear maps each byte to a pseudo random value. It is derived from SHA-256 so that boundaries, and therefore
+// deduplication, stay stable across releases.
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	for i := range table {
+		sum := sha256.Sum256([]byte{byte(i)})
+		table[i] = binary.BigEndian.Uint64(sum[:8])
+	}
+	return table
+}()
+
+// Chunker splits a stream into content-defined chunks using FastCDC with normalised chunking: boundaries depend on
+// the bytes around them rather than their offset, so an edit only changes the chunks near it.
+type Chunker struct {
+	reader io.Reader
+	opts   Options
+	// maskS is harder to match than maskL, making chunks shorter than AvgSize less likely than longer ones.
+	maskS uint64
+	maskL uint64
+
+	buf   []byte
+	start int
+	end   int
+	eof   bool
+}
+
+func New(reader io.Reader, opts Options) (*Chunker, error) {
+	err := opts.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	avgBits := bits.TrailingZeros(uint(opts.AvgSize))
+	return &Chunker{
+		reader: reader,
+		opts:   opts,
+		maskS:  topBits(avgBits + 2),
+		maskL:  topBits(avgBits - 2),
+		buf:    make([]byte, 2*opts.MaxSize),
+	}, nil
+}
+
+func topBits(n int) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	return ^uint64(0) << (64 - n)
+}
+
+// Next returns the next chunk, or io.EOF once the stream is exhausted. The chunk is only valid until the next call.
+func (c *Chunker) Next() ([]byte, error) {
+	if c.end-c.start < c.opts.MaxSize && !c.eof {
+		err := c.fill()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+func (c *Chunker) fill() error {
+	copy(c.buf, c.buf[c.start:c.end])
+	c.end -= c.start
+	c.start = 0
+
+	n, err := io.ReadFull(c.reader, c.buf[c.end:])
+	c.end += n
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		c.eof = true
+		return nil
+	}
+	return err
+}
+
+// cut returns the length of the chunk at the start of data.
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.opts.MinSize {
+		return n
+	}
+	if n > c.opts.MaxSize {
+		n = c.opts.MaxSize
+	}
+	normal := c.opts.AvgSize
+	if n < normal {
+		normal = n
+	}
+
+	var fp uint64
+	i := c.opts.MinSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&c.maskS == 0 {
+			return i
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&c.maskL == 0 {
+			return i
+		}
+	}
+	return n
+}
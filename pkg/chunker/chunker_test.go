@@ -0,0 +1,85 @@
+package chunker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+var testOptions = Options{MinSize: 256, AvgSize: 1024, MaxSize: 4096}
+
+func chunks(t *testing.T, data []byte, opts Options) [][]byte {
+	c, err := New(bytes.NewReader(data), opts)
+	require.NoError(t, err)
+
+	var resp [][]byte
+	for {
+		chunk, err := c.Next()
+		if errors.Is(err, io.EOF) {
+			return resp
+		}
+		require.NoError(t, err)
+		resp = append(resp, append([]byte(nil), chunk...))
+	}
+}
+
+func randomData(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestChunker_Reassembles(t *testing.T) {
+	data := randomData(1, 100000)
+
+	got := chunks(t, data, testOptions)
+	require.True(t, len(got) > 1)
+	require.True(t, bytes.Equal(data, bytes.Join(got, nil)))
+
+	for i, chunk := range got {
+		require.True(t, len(chunk) <= testOptions.MaxSize)
+		if i < len(got)-1 {
+			require.True(t, len(chunk) >= testOptions.MinSize)
+		}
+	}
+}
+
+func TestChunker_Empty(t *testing.T) {
+	require.Empty(t, chunks(t, nil, testOptions))
+}
+
+// Inserting bytes near the start of the stream should leave most chunk boundaries, and therefore most chunks, intact.
+func TestChunker_ShiftResistant(t *testing.T) {
+	data := randomData(2, 200000)
+	edited := append(append(append([]byte(nil), data[:5000]...), []byte("inserted")...), data[5000:]...)
+
+	digests := make(map[[32]byte]bool)
+	for _, chunk := range chunks(t, data, testOptions) {
+		digests[sha256.Sum256(chunk)] = true
+	}
+
+	editedChunks := chunks(t, edited, testOptions)
+	var shared int
+	for _, chunk := range editedChunks {
+		if digests[sha256.Sum256(chunk)] {
+			shared++
+		}
+	}
+	require.True(t, shared >= len(editedChunks)-3, "only %d of %d chunks shared", shared, len(editedChunks))
+}
+
+func TestNew_InvalidOptions(t *testing.T) {
+	for _, opts := range []Options{
+		{MinSize: 0, AvgSize: 1024, MaxSize: 4096},
+		{MinSize: 256, AvgSize: 1000, MaxSize: 4096},
+		{MinSize: 2048, AvgSize: 1024, MaxSize: 4096},
+		{MinSize: 256, AvgSize: 1024, MaxSize: 512},
+	} {
+		_, err := New(bytes.NewReader(nil), opts)
+		require.Error(t, err)
+	}
+}
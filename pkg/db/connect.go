@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	_ "embed"
 	_ "github.com/go-sql-driver/mysql"
@@ -11,12 +12,27 @@ import (
 )
 
 func ConnectForTesting(t *testing.T) *sql.DB {
+	dbc := connectToEmptyDatabase(t)
+	err := Migrate(context.Background(), dbc)
+	require.NoError(t, err)
+
+	return dbc
+}
+
+// connectToEmptyDatabase creates a database that is dropped when the test ends, without creating the schema in it.
+func connectToEmptyDatabase(t *testing.T) *sql.DB {
 	databaseName := generateDBName()
-	dbc, err := sql.Open("mysql", ("mysql")+":password@/"+"?multiStatements=true")
+	dbc, err := sql.Open("mysql", ("mysql")+":password@/"+"?multiStatements=true&parseTime=true")
 	require.NoError(t, err)
 
 	_, err = dbc.Exec("CREATE SCHEMA " + databaseName)
 	require.NoError(t, err)
+	err = dbc.Close()
+	require.NoError(t, err)
+
+	// Name the database in the DSN, so every connection in the pool uses it and not only the first.
+	dbc, err = sql.Open("mysql", ("mysql")+":password@/"+databaseName+"?multiStatements=true&parseTime=true")
+	require.NoError(t, err)
 	t.Cleanup(
 		func() {
 			_, err := dbc.Exec("DROP SCHEMA " + databaseName)
@@ -24,12 +40,6 @@ func ConnectForTesting(t *testing.T) *sql.DB {
 			err = dbc.Close()
 			require.NoError(t, err)
 		})
-	_, err = dbc.Exec("USE " + databaseName)
-	require.NoError(t, err)
-
-	_, err = dbc.Exec(schema)
-	require.NoError(t, err)
-
 	return dbc
 }
 
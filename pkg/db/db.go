@@ -13,7 +13,7 @@ var user = flag.String("user", "root", "The name of the db user for the app")
 var password = flag.String("password", "cloudStorage", "The password of the db user specified in 'user' parameter")
 
 func New() (*sql.DB, error) {
-	dbc, err := sql.Open("mysql", (*user)+":"+(*password)+"@/"+(*database))
+	dbc, err := sql.Open("mysql", (*user)+":"+(*password)+"@/"+(*database)+"?parseTime=true")
 	if err != nil {
 		return nil, err
 	}
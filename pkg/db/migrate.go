@@ -4,19 +4,112 @@ import (
 	"context"
 	"database/sql"
 	_ "embed"
+	"errors"
 )
 
 //go:embed schema.sql
 var schema string
 
+// migration brings a table created by an earlier version of the schema up to date, as creating the schema leaves
+// tables that exist already as they are. It is needed while the table's column is missing, or has another type than
+// dataType if that is set.
+type migration struct {
+	table      string
+	column     string
+	dataType   string
+	statements []string
+}
+
+// migrations are in the order the schema changed.
+var migrations = []migration{
+	{table: "documents", column: "stored_size", statements: []string{
+		"alter table `documents` add column `stored_size` bigint default 0 not null",
+	}},
+	{table: "documents", column: "size", dataType: "bigint", statements: []string{
+		"alter table `documents` modify column `size` bigint default 0 not null",
+	}},
+	{table: "documents", column: "sha256", statements: []string{
+		"alter table `documents` add column `sha256` char(64) default '' not null",
+	}},
+	{table: "documents", column: "md5", statements: []string{
+		"alter table `documents` add column `md5` char(32) default '' not null",
+	}},
+	{table: "users", column: "admin", statements: []string{
+		"alter table `users` add column `admin` bool default false not null",
+	}},
+	{table: "users", column: "quota", statements: []string{
+		"alter table `users` add column `quota` bigint",
+	}},
+	{table: "users", column: "allowed_media_types", statements: []string{
+		"alter table `users` add column `allowed_media_types` varchar(1024) default '' not null",
+	}},
+	{table: "users", column: "denied_media_types", statements: []string{
+		"alter table `users` add column `denied_media_types` varchar(1024) default '' not null",
+	}},
+	{table: "documents", column: "detected_media_type", statements: []string{
+		"alter table `documents` add column `detected_media_type` varchar(255) default '' not null",
+	}},
+	{table: "documents", column: "folder", statements: []string{
+		"alter table `documents` add column `folder` int, add foreign key (`folder`) references `folders`(`id`), " +
+			"add index (`owner`, `folder`, `file_name`)",
+	}},
+	{table: "documents", column: "deleted_at", statements: []string{
+		"alter table `documents` add column `deleted_at` datetime, add index (`deleted_at`)",
+	}},
+	{table: "documents", column: "modified_at", statements: []string{
+		"alter table `documents` add column `modified_at` datetime default current_timestamp not null, add index (`owner`, `modified_at`)",
+		// Documents were last modified when their current version was stored, if that was recorded.
+		"update `documents` d join `document_versions` v on v.`document` = d.`id` and v.`version` = d.`version` " +
+			"set d.`modified_at` = v.`created_at`",
+	}},
+}
+
 func SetupSchema(ctx context.Context) error {
-	dbc,err := sql.Open("mysql", (*user) + ":" + (*password) + "@/" + (*database)+"?multiStatements=true")
+	dbc, err := sql.Open("mysql", (*user)+":"+(*password)+"@/"+(*database)+"?multiStatements=true")
 	if err != nil {
 		return err
 	}
-	_, err = dbc.ExecContext(ctx, schema)
+	defer dbc.Close()
+
+	return Migrate(ctx, dbc)
+}
+
+// Migrate creates the tables of the schema that don't exist yet, and applies the migrations the existing ones need. The
+// connection has to allow multiple statements.
+func Migrate(ctx context.Context, dbc *sql.DB) error {
+	_, err := dbc.ExecContext(ctx, schema)
 	if err != nil {
 		return err
 	}
+
+	for _, m := range migrations {
+		needed, err := m.needed(ctx, dbc)
+		if err != nil {
+			return err
+		}
+		if !needed {
+			continue
+		}
+
+		for _, statement := range m.statements {
+			_, err = dbc.ExecContext(ctx, statement)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+func (m migration) needed(ctx context.Context, dbc *sql.DB) (bool, error) {
+	row := dbc.QueryRowContext(ctx, "select `data_type` from `information_schema`.`columns` "+
+		"where `table_schema` = database() and `table_name` = ? and `column_name` = ?", m.table, m.column)
+	var dataType string
+	err := row.Scan(&dataType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return m.dataType != "" && dataType != m.dataType, nil
+}
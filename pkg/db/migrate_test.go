@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	dbc := connectToEmptyDatabase(t)
+
+	// A database created with the first version of the schema.
+	firstSchema, err := os.ReadFile("testdata/first_schema.sql")
+	require.NoError(t, err)
+	_, err = dbc.Exec(string(firstSchema))
+	require.NoError(t, err)
+	_, err = dbc.Exec("insert into `users` (`id`, `username`) values (1, 'john')")
+	require.NoError(t, err)
+	_, err = dbc.Exec("insert into `documents` (`owner`, `path`, `version`, `size`, `file_name`) values (1, '1_v1', 1, 12, 'a.txt')")
+	require.NoError(t, err)
+
+	require.NoError(t, Migrate(ctx, dbc))
+	for _, m := range migrations {
+		needed, err := m.needed(ctx, dbc)
+		require.NoError(t, err)
+		require.False(t, needed, "%s.%s", m.table, m.column)
+	}
+
+	// The user and the document can be read and written as new ones would be.
+	var admin bool
+	require.NoError(t, dbc.QueryRow("select `admin` from `users` where `id` = 1").Scan(&admin))
+	require.False(t, admin)
+	_, err = dbc.Exec("update `documents` set `size` = ? where `owner` = 1", int64(5)<<30)
+	require.NoError(t, err)
+	var size, storedSize int64
+	var deleted *string
+	require.NoError(t, dbc.QueryRow("select `size`, `stored_size`, `deleted_at` from `documents` where `owner` = 1 and `modified_at` is not null").
+		Scan(&size, &storedSize, &deleted))
+	require.Equal(t, int64(5)<<30, size)
+	require.Equal(t, int64(0), storedSize)
+	require.Nil(t, deleted)
+
+	// Migrating again changes nothing.
+	require.NoError(t, Migrate(ctx, dbc))
+}
@@ -6,22 +6,50 @@ create table if not exists `users` (
     `cell` varchar(64),
     `hash` varchar(255) default '' not null,
     `salt` varchar(255) default '' not null,
+    `admin` bool default false not null,
+    `quota` bigint,
+    `allowed_media_types` varchar(1024) default '' not null,
+    `denied_media_types` varchar(1024) default '' not null,
 
     primary key (`id`),
     index (`username`)
 );
 
+create table if not exists `folders` (
+    `id` int not null auto_increment,
+    `owner` int not null,
+    `parent` int,
+    `name` varchar(255) not null,
+    `created_at` datetime not null,
+
+    primary key (`id`),
+    foreign key (`owner`) references `users`(`id`),
+    foreign key (`parent`) references `folders`(`id`),
+    unique index (`owner`, `parent`, `name`)
+);
+
 create table if not exists `documents` (
     `id` int not null auto_increment,
     `owner` int not null,
     `path` varchar(255) default '' not null,
     `version` int default 0 not null,
-    `size` int default 0 not null,
+    `size` bigint default 0 not null,
+    `stored_size` bigint default 0 not null,
+    `sha256` char(64) default '' not null,
+    `md5` char(32) default '' not null,
     `media_type` varchar(255) default '' not null,
+    `detected_media_type` varchar(255) default '' not null,
     `file_name` varchar(255) default '' not null,
+    `folder` int,
+    `modified_at` datetime default current_timestamp not null,
+    `deleted_at` datetime,
 
     primary key (`id`),
-    foreign key (`owner`) references `users`(`id`)
+    foreign key (`owner`) references `users`(`id`),
+    foreign key (`folder`) references `folders`(`id`),
+    index (`owner`, `folder`, `file_name`),
+    index (`owner`, `modified_at`),
+    index (`deleted_at`)
 );
 
 create table if not exists `audit_log` (
@@ -45,4 +73,155 @@ create table if not exists `permissions` (
     foreign key (`user`) references `users`(`id`),
     index `id_user_document` (`user`, `document`),
     index (`user`)
-);
\ No newline at end of file
+);
+create table if not exists `document_versions` (
+    `document` int not null,
+    `version` int not null,
+    `path` varchar(255) not null,
+    `size` bigint default 0 not null,
+    `stored_size` bigint default 0 not null,
+    `sha256` char(64) default '' not null,
+    `md5` char(32) default '' not null,
+    `media_type` varchar(255) default '' not null,
+    `detected_media_type` varchar(255) default '' not null,
+    `file_name` varchar(255) default '' not null,
+    `uploader` int,
+    `created_at` datetime not null,
+    `scan_state` varchar(16) default '' not null,
+    `scan_signature` varchar(255) default '' not null,
+
+    primary key (`document`, `version`),
+    foreign key (`document`) references `documents`(`id`),
+    foreign key (`uploader`) references `users`(`id`) ON DELETE SET NULL
+);
+
+create table if not exists `document_locks` (
+    `document` int not null,
+    `user` int not null,
+    `reason` varchar(255) default '' not null,
+    `created_at` datetime not null,
+    `expires_at` datetime not null,
+
+    primary key (`document`),
+    foreign key (`document`) references `documents`(`id`) ON DELETE CASCADE,
+    foreign key (`user`) references `users`(`id`) ON DELETE CASCADE
+);
+
+create table if not exists `document_tags` (
+    `document` int not null,
+    `tag` varchar(64) not null,
+
+    primary key (`document`, `tag`),
+    index (`tag`),
+    foreign key (`document`) references `documents`(`id`) ON DELETE CASCADE
+);
+
+create table if not exists `document_metadata` (
+    `document` int not null,
+    `key` varchar(64) not null,
+    `value` varchar(255) default '' not null,
+
+    primary key (`document`, `key`),
+    index (`key`, `value`),
+    foreign key (`document`) references `documents`(`id`) ON DELETE CASCADE
+);
+
+create table if not exists `search_terms` (
+    `term` varchar(64) not null,
+    `document` int not null,
+    `field` varchar(8) not null,
+    `weight` int not null,
+
+    primary key (`term`, `document`, `field`),
+    index (`document`, `field`),
+    foreign key (`document`) references `documents`(`id`) ON DELETE CASCADE
+);
+
+create table if not exists `retention_policies` (
+    `user` int,
+    `document` int,
+    `keep_forever` bool default false not null,
+    `keep_last` int default 0 not null,
+    `keep_days` int default 0 not null,
+    `daily_snapshots` bool default false not null,
+
+    unique (`user`),
+    unique (`document`),
+    foreign key (`user`) references `users`(`id`) ON DELETE CASCADE,
+    foreign key (`document`) references `documents`(`id`) ON DELETE CASCADE
+);
+
+create table if not exists `blobs` (
+    `digest` char(64) not null,
+    `size` bigint default 0 not null,
+    `stored_size` bigint default 0 not null,
+    `compression` varchar(16) default '' not null,
+    `wrapped_key` varbinary(255),
+    `key_id` varchar(64) default '' not null,
+    `ref_count` int default 0 not null,
+
+    primary key (`digest`),
+    index (`key_id`)
+);
+
+create table if not exists `document_chunks` (
+    `document` int not null,
+    `version` int not null,
+    `seq` int not null,
+    `digest` char(64) not null,
+    `size` bigint not null,
+
+    primary key (`document`, `version`, `seq`),
+    foreign key (`document`) references `documents`(`id`),
+    foreign key (`digest`) references `blobs`(`digest`)
+);
+
+create table if not exists `uploads` (
+    `id` char(32) not null,
+    `user` int not null,
+    `document` int,
+    `length` bigint not null,
+    `offset` bigint default 0 not null,
+    `media_type` varchar(255) default '' not null,
+    `detected_media_type` varchar(255) default '' not null,
+    `file_name` varchar(255) default '' not null,
+    `result` int,
+    `created_at` datetime not null,
+    `expires_at` datetime not null,
+
+    primary key (`id`),
+    index (`expires_at`),
+    foreign key (`user`) references `users`(`id`) ON DELETE CASCADE,
+    foreign key (`document`) references `documents`(`id`) ON DELETE CASCADE,
+    foreign key (`result`) references `documents`(`id`) ON DELETE SET NULL
+);
+
+create table if not exists `multipart_uploads` (
+    `id` char(32) not null,
+    `user` int not null,
+    `document` int,
+    `media_type` varchar(255) default '' not null,
+    `detected_media_type` varchar(255) default '' not null,
+    `file_name` varchar(255) default '' not null,
+    `result` int,
+    `created_at` datetime not null,
+    `expires_at` datetime not null,
+
+    primary key (`id`),
+    index (`expires_at`),
+    foreign key (`user`) references `users`(`id`) ON DELETE CASCADE,
+    foreign key (`document`) references `documents`(`id`) ON DELETE CASCADE,
+    foreign key (`result`) references `documents`(`id`) ON DELETE SET NULL
+);
+
+create table if not exists `multipart_parts` (
+    `upload` char(32) not null,
+    `number` int not null,
+    `size` bigint not null,
+    `md5` char(32) not null,
+    `sha256` char(64) not null,
+    `created_at` datetime not null,
+
+    primary key (`upload`, `number`),
+    foreign key (`upload`) references `multipart_uploads`(`id`) ON DELETE CASCADE
+);
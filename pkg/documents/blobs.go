@@ -0,0 +1,180 @@
+package documents
+
+import (
+	"context"
+	"database/sql"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/internal/db"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Contents are stored once per distinct SHA-256 digest, under blobKeyPrefix followed by the hex digest.
+const blobKeyPrefix = "sha256/"
+
+func blobKey(digest string) string {
+	return blobKeyPrefix + digest
+}
+
+// MigrateLegacyPaths rewrites the paths of contents written before they were kept in a blob store, which are absolute
+// paths under CLOUD_STORAGE_WORKPATH, into keys in the file system store rooted at it. It returns the number of documents
+// and versions rewritten, and can be run any number of times.
+func MigrateLegacyPaths(ctx context.Context, dbc *sql.DB) (int64, error) {
+	return db.TrimPathPrefix(ctx, dbc, filepath.Clean(workPath)+string(filepath.Separator))
+}
+
+// storeContents references blobs holding the staged contents of a document version, storing only those that do not
+// exist yet. It returns the key the version's contents are read from, the number of bytes they take up in the store
+// and the keys of the blobs it stored, even if it fails, which should be passed to deleteBlobs unless the transaction
+// commits.
+func storeContents(ctx context.Context, tx db.DBTransaction, temp tempFile, documentID int64, version int64, mediaType string) (string, int64, []string, error) {
+	file, err := os.Open(temp.path)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	defer file.Close()
+
+	if chunkOptions != nil {
+		return storeChunks(ctx, tx, file, documentID, version, mediaType)
+	}
+	key, storedSize, created, err := acquireBlob(ctx, tx, temp.sha256, temp.size, mediaType, file)
+	if created {
+		return key, storedSize, []string{key}, err
+	}
+	return key, storedSize, nil, err
+}
+
+// acquireBlob references the blob with a digest, storing the contents read from reader if it does not exist yet. It
+// reports whether it stored them, even if it fails afterwards.
+func acquireBlob(ctx context.Context, tx db.DBTransaction, digest string, size int64, mediaType string, reader io.Reader) (string, int64, bool, error) {
+	key := blobKey(digest)
+	compression := compressionFor(mediaType)
+	created, err := db.AcquireBlob(ctx, tx, digest, size, compression)
+	if err != nil {
+		return "", 0, false, err
+	}
+
+	if !created {
+		blob, err := db.GetBlob(ctx, tx, digest)
+		if err != nil {
+			return "", 0, false, err
+		}
+		return key, blob.StoredSize, false, nil
+	}
+
+	compressed := compress(compression, reader)
+	defer compressed.Close()
+	encrypted, wrappedKey, keyID, err := encrypt(ctx, compressed)
+	if err != nil {
+		return "", 0, false, err
+	}
+
+	storedSize, err := store.Put(ctx, key, encrypted)
+	if err != nil {
+		return "", 0, false, err
+	}
+
+	err = db.SetBlobStored(ctx, tx, digest, storedSize, wrappedKey, keyID)
+	if err != nil {
+		return "", 0, true, err
+	}
+	return key, storedSize, true, nil
+}
+
+// referenceContents adds references to the contents of an existing version for a new version of a document, which
+// may be another document, without storing them again. It returns the key the new version's contents are read from.
+func referenceContents(ctx context.Context, tx db.DBTransaction, v db.Version, documentID int64, version int64) (string, error) {
+	if strings.HasPrefix(v.Path, manifestKeyPrefix) {
+		return referenceChunks(ctx, tx, v.Document, v.Version, documentID, version)
+	}
+	if !strings.HasPrefix(v.Path, blobKeyPrefix) {
+		return v.Path, nil
+	}
+	return v.Path, db.AddBlobReference(ctx, tx, strings.TrimPrefix(v.Path, blobKeyPrefix))
+}
+
+// releaseContents drops the references a document version holds. It returns the keys of blobs that are no longer
+// referenced, which should be passed to deleteBlobs once the transaction has committed. Keys written before contents
+// were deduplicated are not reference counted and are left alone.
+func releaseContents(ctx context.Context, tx db.DBTransaction, documentID int64, version int64, key string) ([]string, error) {
+	if strings.HasPrefix(key, manifestKeyPrefix) {
+		return releaseChunks(ctx, tx, documentID, version)
+	}
+	if !strings.HasPrefix(key, blobKeyPrefix) {
+		return nil, nil
+	}
+
+	freed, err := db.ReleaseBlob(ctx, tx, strings.TrimPrefix(key, blobKeyPrefix))
+	if err != nil || !freed {
+		return nil, err
+	}
+	return []string{key}, nil
+}
+
+func openContents(ctx context.Context, dbc db.DBTransaction, documentID int64, version int64, key string) (io.ReadCloser, error) {
+	if strings.HasPrefix(key, manifestKeyPrefix) {
+		return openChunks(ctx, dbc, documentID, version)
+	}
+	if !strings.HasPrefix(key, blobKeyPrefix) {
+		return store.Get(ctx, key)
+	}
+
+	blob, err := db.GetBlob(ctx, dbc, strings.TrimPrefix(key, blobKeyPrefix))
+	if err != nil {
+		return nil, err
+	}
+	return openBlob(ctx, blob)
+}
+
+func openBlob(ctx context.Context, blob db.Blob) (io.ReadCloser, error) {
+	reader, err := store.Get(ctx, blobKey(blob.Digest))
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted, err := decrypt(ctx, blob, reader)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(blob.Compression, decrypted)
+}
+
+// deleteBlobs deletes the contents of blobs released by a committed transaction. The same contents may have been stored
+// again since, under the same key, so each blob is deleted while its row is locked and only if it is still unreferenced.
+func deleteBlobs(ctx context.Context, dbc *sql.DB, keys []string) error {
+	for _, key := range keys {
+		err := deleteBlob(ctx, dbc, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteBlob(ctx context.Context, dbc *sql.DB, key string) error {
+	if !strings.HasPrefix(key, blobKeyPrefix) {
+		return store.Delete(ctx, key)
+	}
+
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Holding the lock keeps an upload of the same contents from creating the blob again until it has been deleted.
+	refCount, err := db.LockBlob(ctx, tx, strings.TrimPrefix(key, blobKeyPrefix))
+	if err != nil {
+		return err
+	}
+	if refCount > 0 {
+		return nil
+	}
+
+	err = store.Delete(ctx, key)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
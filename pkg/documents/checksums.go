@@ -0,0 +1,72 @@
+package documents
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Checksums are raw digests of a document's contents. Empty digests are not checked.
+type Checksums struct {
+	SHA256 []byte
+	MD5    []byte
+}
+
+type verifyingReader struct {
+	reader   io.ReadCloser
+	expected Checksums
+	sha256   hash.Hash
+	md5      hash.Hash
+}
+
+// NewVerifyingReader returns a reader that fails with ErrChecksumMismatch at the end of reader if its contents don't
+// match the expected checksums.
+func NewVerifyingReader(reader io.ReadCloser, expected Checksums) io.ReadCloser {
+	return &verifyingReader{
+		reader:   reader,
+		expected: expected,
+		sha256:   sha256.New(),
+		md5:      md5.New(),
+	}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.reader.Read(p)
+	v.sha256.Write(p[:n])
+	v.md5.Write(p[:n])
+	if !errors.Is(err, io.EOF) {
+		return n, err
+	}
+
+	if len(v.expected.SHA256) > 0 && !bytes.Equal(v.expected.SHA256, v.sha256.Sum(nil)) {
+		return n, fmt.Errorf("%w: expected sha256 %x, got %x", ErrChecksumMismatch, v.expected.SHA256, v.sha256.Sum(nil))
+	}
+	if len(v.expected.MD5) > 0 && !bytes.Equal(v.expected.MD5, v.md5.Sum(nil)) {
+		return n, fmt.Errorf("%w: expected md5 %x, got %x", ErrChecksumMismatch, v.expected.MD5, v.md5.Sum(nil))
+	}
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	return v.reader.Close()
+}
+
+// storedChecksums decodes the hex digests recorded for a document version.
+func storedChecksums(sha256Hex string, md5Hex string) (Checksums, error) {
+	sha256Sum, err := hex.DecodeString(sha256Hex)
+	if err != nil {
+		return Checksums{}, err
+	}
+	md5Sum, err := hex.DecodeString(md5Hex)
+	if err != nil {
+		return Checksums{}, err
+	}
+	return Checksums{SHA256: sha256Sum, MD5: md5Sum}, nil
+}
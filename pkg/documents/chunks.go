@@ -0,0 +1,155 @@
+package documents
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/EatonEmmerich/cloudStorage/pkg/chunker"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/internal/db"
+	"io"
+	"strconv"
+)
+
+// Chunked versions have no blob of their own. Their key points at the version's manifest in document_chunks, whose
+// chunks are stored as ordinary deduplicated blobs.
+const manifestKeyPrefix = "manifest/"
+
+// chunkOptions enables chunked storage of new versions when set.
+var chunkOptions *chunker.Options
+
+func manifestKey(documentID int64, version int64) string {
+	return manifestKeyPrefix + strconv.FormatInt(documentID, 10) + "_v" + strconv.FormatInt(version, 10)
+}
+
+func storeChunks(ctx context.Context, tx db.DBTransaction, reader io.Reader, documentID int64, version int64, mediaType string) (string, int64, []string, error) {
+	c, err := chunker.New(reader, *chunkOptions)
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	var storedSize int64
+	var createdKeys []string
+	for seq := int64(0); ; seq++ {
+		chunk, err := c.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return "", 0, createdKeys, err
+		}
+
+		sum := sha256.Sum256(chunk)
+		digest := hex.EncodeToString(sum[:])
+		key, chunkStoredSize, created, err := acquireBlob(ctx, tx, digest, int64(len(chunk)), mediaType, bytes.NewReader(chunk))
+		if created {
+			createdKeys = append(createdKeys, key)
+		}
+		if err != nil {
+			return "", 0, createdKeys, err
+		}
+		storedSize += chunkStoredSize
+
+		err = db.InsertChunk(ctx, tx, documentID, version, db.Chunk{Seq: seq, Size: int64(len(chunk)), Blob: db.Blob{Digest: digest}})
+		if err != nil {
+			return "", 0, createdKeys, err
+		}
+	}
+	return manifestKey(documentID, version), storedSize, createdKeys, nil
+}
+
+// referenceChunks gives a new version of a document the same chunks as an existing version of a document.
+func referenceChunks(ctx context.Context, tx db.DBTransaction, fromDocumentID int64, fromVersion int64, documentID int64, version int64) (string, error) {
+	chunks, err := db.ListChunks(ctx, tx, fromDocumentID, fromVersion)
+	if err != nil {
+		return "", err
+	}
+
+	for _, chunk := range chunks {
+		err = db.AddBlobReference(ctx, tx, chunk.Blob.Digest)
+		if err != nil {
+			return "", err
+		}
+		err = db.InsertChunk(ctx, tx, documentID, version, chunk)
+		if err != nil {
+			return "", err
+		}
+	}
+	return manifestKey(documentID, version), nil
+}
+
+func releaseChunks(ctx context.Context, tx db.DBTransaction, documentID int64, version int64) ([]string, error) {
+	chunks, err := db.ListChunks(ctx, tx, documentID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.DeleteChunks(ctx, tx, documentID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	var freedKeys []string
+	for _, chunk := range chunks {
+		freed, err := db.ReleaseBlob(ctx, tx, chunk.Blob.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if freed {
+			freedKeys = append(freedKeys, blobKey(chunk.Blob.Digest))
+		}
+	}
+	return freedKeys, nil
+}
+
+func openChunks(ctx context.Context, dbc db.DBTransaction, documentID int64, version int64) (io.ReadCloser, error) {
+	chunks, err := db.ListChunks(ctx, dbc, documentID, version)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkReader{ctx: ctx, chunks: chunks}, nil
+}
+
+// chunkReader reassembles a chunked version, fetching one chunk at a time from the store.
+type chunkReader struct {
+	ctx     context.Context
+	chunks  []db.Chunk
+	current io.ReadCloser
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if c.current == nil {
+			if len(c.chunks) == 0 {
+				return 0, io.EOF
+			}
+			reader, err := openBlob(c.ctx, c.chunks[0].Blob)
+			if err != nil {
+				return 0, err
+			}
+			c.current = reader
+			c.chunks = c.chunks[1:]
+		}
+
+		n, err := c.current.Read(p)
+		if errors.Is(err, io.EOF) {
+			err = c.current.Close()
+			c.current = nil
+			if err != nil {
+				return n, err
+			}
+			if n == 0 {
+				continue
+			}
+			return n, nil
+		}
+		return n, err
+	}
+}
+
+func (c *chunkReader) Close() error {
+	if c.current == nil {
+		return nil
+	}
+	return c.current.Close()
+}
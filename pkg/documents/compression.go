@@ -0,0 +1,141 @@
+package documents
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"mime"
+	"strings"
+)
+
+const (
+	compressionNone = ""
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+)
+
+// compressionAlgorithm is applied to new blobs whose media type is worth compressing.
+var compressionAlgorithm = compressionNone
+
+// Media types whose contents are already compressed, so compressing them again only costs CPU.
+var compressedMediaTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/vnd.rar",
+	"application/vnd.openxmlformats-officedocument.",
+	"application/vnd.oasis.opendocument.",
+	"application/epub+zip",
+	"application/java-archive",
+}
+
+// Compressible image formats, which the image/ prefix above would otherwise exclude.
+var uncompressedImageTypes = []string{
+	"image/svg+xml",
+	"image/bmp",
+	"image/x-ms-bmp",
+	"image/tiff",
+}
+
+func compressionFor(mediaType string) string {
+	if compressionAlgorithm == compressionNone {
+		return compressionNone
+	}
+
+	mediaType, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return compressionAlgorithm
+	}
+	for _, t := range uncompressedImageTypes {
+		if mediaType == t {
+			return compressionAlgorithm
+		}
+	}
+	for _, prefix := range compressedMediaTypes {
+		if strings.HasPrefix(mediaType, prefix) {
+			return compressionNone
+		}
+	}
+	return compressionAlgorithm
+}
+
+// compress returns a reader of the compressed contents of reader, compressing as it is read. It must be closed so the
+// compressing goroutine stops if the result is not read to the end.
+func compress(algorithm string, reader io.Reader) io.ReadCloser {
+	if algorithm == compressionNone {
+		return io.NopCloser(reader)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		var encoder io.WriteCloser
+		var err error
+		switch algorithm {
+		case compressionGzip:
+			encoder = gzip.NewWriter(pw)
+		case compressionZstd:
+			encoder, err = zstd.NewWriter(pw)
+		default:
+			err = fmt.Errorf("unknown compression: %q", algorithm)
+		}
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		_, err = io.Copy(encoder, reader)
+		if err != nil {
+			encoder.Close()
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(encoder.Close())
+	}()
+	return pr
+}
+
+type decompressReader struct {
+	io.Reader
+	close func() error
+}
+
+func (d decompressReader) Close() error {
+	return d.close()
+}
+
+// decompress wraps reader with a decoder for algorithm. Closing the result closes reader.
+func decompress(algorithm string, reader io.ReadCloser) (io.ReadCloser, error) {
+	switch algorithm {
+	case compressionNone:
+		return reader, nil
+	case compressionGzip:
+		decoder, err := gzip.NewReader(reader)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		return decompressReader{Reader: decoder, close: reader.Close}, nil
+	case compressionZstd:
+		decoder, err := zstd.NewReader(reader)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		return decompressReader{Reader: decoder, close: func() error {
+			decoder.Close()
+			return reader.Close()
+		}}, nil
+	}
+	reader.Close()
+	return nil, fmt.Errorf("unknown compression: %q", algorithm)
+}
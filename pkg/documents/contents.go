@@ -0,0 +1,94 @@
+package documents
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// contentsReader reads a version's contents, which are stored compressed, encrypted or in chunks and so can't be
+// seeked in directly. Seeking forward skips over contents, and seeking backward opens the contents again.
+type contentsReader struct {
+	open func() (io.ReadCloser, error)
+	size int64
+	// pos is the position reads continue from, and readerPos the position of reader.
+	pos       int64
+	reader    io.ReadCloser
+	readerPos int64
+}
+
+func newContentsReader(size int64, open func() (io.ReadCloser, error)) (*contentsReader, error) {
+	reader, err := open()
+	if err != nil {
+		return nil, err
+	}
+	return &contentsReader{open: open, size: size, reader: reader}, nil
+}
+
+func (c *contentsReader) Read(p []byte) (int, error) {
+	if c.reader != nil && c.readerPos > c.pos {
+		err := c.reader.Close()
+		c.reader = nil
+		if err != nil {
+			return 0, err
+		}
+	}
+	if c.reader == nil {
+		reader, err := c.open()
+		if err != nil {
+			return 0, err
+		}
+		c.reader, c.readerPos = reader, 0
+	}
+	if c.readerPos < c.pos {
+		n, err := io.CopyN(io.Discard, c.reader, c.pos-c.readerPos)
+		c.readerPos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := c.reader.Read(p)
+	c.readerPos += int64(n)
+	c.pos = c.readerPos
+	if err != nil || c.pos < c.size {
+		return n, err
+	}
+
+	// Readers that stop at the expected size never see the end of the contents, where they are verified.
+	var extra [1]byte
+	m, err := c.reader.Read(extra[:])
+	if m > 0 {
+		return n, fmt.Errorf("%w: contents are longer than %d bytes", ErrChecksumMismatch, c.size)
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return n, err
+	}
+	return n, nil
+}
+
+func (c *contentsReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += c.pos
+	case io.SeekEnd:
+		offset += c.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	c.pos = offset
+	return offset, nil
+}
+
+func (c *contentsReader) Close() error {
+	if c.reader == nil {
+		return nil
+	}
+	err := c.reader.Close()
+	c.reader = nil
+	return err
+}
@@ -0,0 +1,53 @@
+package documents
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+)
+
+func TestContentsReader(t *testing.T) {
+	data := []byte("0123456789")
+	sum := sha256.Sum256(data)
+	var opened int
+	open := func(contents []byte) func() (io.ReadCloser, error) {
+		return func() (io.ReadCloser, error) {
+			opened++
+			return NewVerifyingReader(io.NopCloser(bytes.NewReader(contents)), Checksums{SHA256: sum[:]}), nil
+		}
+	}
+
+	reader, err := newContentsReader(int64(len(data)), open(data))
+	require.NoError(t, err)
+
+	_, err = reader.Seek(6, io.SeekStart)
+	require.NoError(t, err)
+	b := make([]byte, 2)
+	_, err = io.ReadFull(reader, b)
+	require.NoError(t, err)
+	require.Equal(t, "67", string(b))
+	require.Equal(t, 1, opened)
+
+	_, err = reader.Seek(-7, io.SeekEnd)
+	require.NoError(t, err)
+	_, err = io.ReadFull(reader, b)
+	require.NoError(t, err)
+	require.Equal(t, "34", string(b))
+	require.Equal(t, 2, opened)
+
+	_, err = reader.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	all, err := io.ReadAll(io.LimitReader(reader, int64(len(data))))
+	require.NoError(t, err)
+	require.Equal(t, data, all)
+	require.NoError(t, reader.Close())
+
+	// Corrupt contents are detected even when the reader is stopped at the expected size.
+	reader, err = newContentsReader(int64(len(data)), open([]byte("0123456780")))
+	require.NoError(t, err)
+	_, err = io.ReadAll(io.LimitReader(reader, int64(len(data))))
+	require.True(t, errors.Is(err, ErrChecksumMismatch))
+}
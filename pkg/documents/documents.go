@@ -2,19 +2,38 @@ package documents
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
 	models2 "github.com/EatonEmmerich/cloudStorage/pkg/access_control/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/chunker"
 	"github.com/EatonEmmerich/cloudStorage/pkg/documents/internal/db"
 	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/encryption"
+	"github.com/EatonEmmerich/cloudStorage/pkg/scanner"
+	"github.com/EatonEmmerich/cloudStorage/pkg/storage"
 	"io"
+	"log"
 	"os"
-	"path"
 	"strconv"
+	"strings"
+	"time"
 )
 
+var ErrNotFound = errors.New("document not found")
+
+var ErrVersionNotFound = errors.New("version not found")
+
+var ErrVersionConflict = errors.New("document has been changed")
+
 var workPath string
 
+var store storage.BlobStore
+
 func init() {
 	var is bool
 	workPath, is = os.LookupEnv("CLOUD_STORAGE_WORKPATH")
@@ -25,16 +44,138 @@ func init() {
 			panic(err)
 		}
 	}
+
+	switch os.Getenv("CLOUD_STORAGE_BACKEND") {
+	case "s3":
+		s3, err := storage.NewS3(storage.S3ConfigFromEnv())
+		if err != nil {
+			panic(err)
+		}
+		store = s3
+	default:
+		store = storage.NewFileSystem(workPath)
+	}
+
+	if os.Getenv("CLOUD_STORAGE_CHUNKING") == "true" {
+		chunkOptions = &chunker.DefaultOptions
+	}
+
+	masterKeyFile := os.Getenv("CLOUD_STORAGE_MASTER_KEY_FILE")
+	if masterKeyFile != "" {
+		var previousKeyFiles []string
+		if previous := os.Getenv("CLOUD_STORAGE_PREVIOUS_MASTER_KEY_FILES"); previous != "" {
+			previousKeyFiles = strings.Split(previous, ",")
+		}
+		provider, err := encryption.NewFileKeyProvider(masterKeyFile, previousKeyFiles...)
+		if err != nil {
+			panic(err)
+		}
+		keyProvider = provider
+	}
+
+	compressionAlgorithm = os.Getenv("CLOUD_STORAGE_COMPRESSION")
+	if compressionAlgorithm != compressionNone && compressionAlgorithm != compressionGzip && compressionAlgorithm != compressionZstd {
+		panic("unknown CLOUD_STORAGE_COMPRESSION: " + compressionAlgorithm)
+	}
+
+	clamdConfig, err := scanner.ClamdConfigFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	if clamdConfig.Address != "" {
+		malwareScanner = scanner.NewClamd(clamdConfig)
+	}
+
+	if retention := os.Getenv("CLOUD_STORAGE_RETENTION"); retention != "" {
+		policy, err := ParseRetentionPolicy(retention)
+		if err != nil {
+			panic(err)
+		}
+		retentionPolicy = &policy
+	}
+
+	if size := os.Getenv("CLOUD_STORAGE_MAX_FILE_SIZE"); size != "" {
+		var err error
+		maxFileSize, err = strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if quota := os.Getenv("CLOUD_STORAGE_DEFAULT_QUOTA"); quota != "" {
+		var err error
+		defaultQuota, err = strconv.ParseInt(quota, 10, 64)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	mediaTypePolicy = models.MediaTypePolicy{
+		Allowed: ParseMediaTypes(os.Getenv("CLOUD_STORAGE_ALLOWED_MEDIA_TYPES")),
+		Denied:  ParseMediaTypes(os.Getenv("CLOUD_STORAGE_DENIED_MEDIA_TYPES")),
+	}
+
+	if days := os.Getenv("CLOUD_STORAGE_TRASH_DAYS"); days != "" {
+		var err error
+		trashDays, err = strconv.Atoi(days)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if interval := os.Getenv("CLOUD_STORAGE_PRUNE_INTERVAL"); interval != "" {
+		var err error
+		pruneInterval, err = time.ParseDuration(interval)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// SetBlobStore swaps the store that document contents are written to and read from.
+func SetBlobStore(blobStore storage.BlobStore) {
+	store = blobStore
 }
 
 func Upload(ctx context.Context, dbc *sql.DB, userID int64, reader io.ReadCloser, mediaType string, filename string) (int, error) {
+	documentID, err := upload(ctx, dbc, userID, reader, mediaType, filename, nil)
+	return int(documentID), err
+}
+
+// Recorder records what contents were stored for, in the transaction that stores them as a version of a document, so
+// that either both are committed or neither is.
+type Recorder func(ctx context.Context, tx *sql.Tx, documentID int64) error
+
+// Store stores contents as a new document of the user's if documentID is 0, and as the next version of the document
+// otherwise, calling record in the transaction that stores them. It returns the document they were stored as.
+func Store(ctx context.Context, dbc *sql.DB, documentID int64, userID int64, reader io.ReadCloser, mediaType string, filename string, record Recorder) (int64, error) {
+	if documentID == 0 {
+		return upload(ctx, dbc, userID, reader, mediaType, filename, record)
+	}
+	_, err := update(ctx, dbc, documentID, userID, reader, mediaType, filename, record)
+	return documentID, err
+}
+
+func upload(ctx context.Context, dbc *sql.DB, userID int64, reader io.ReadCloser, mediaType string, filename string, record Recorder) (int64, error) {
+	limited, err := limitUpload(ctx, dbc, userID, reader)
+	if err != nil {
+		return 0, err
+	}
+
 	documentID, err := new(ctx, dbc, userID)
 	if err != nil {
 		return 0, err
 	}
 
-	tempPath, writtenBytes, err := createTempFile(documentID, reader)
+	temp, err := createTempFile(documentID, limited)
+	if err == nil {
+		err = checkMediaType(ctx, dbc, userID, mediaType, temp)
+	}
 	if err != nil {
+		deleteErr := db.DeleteIncompleteDocument(ctx, dbc, documentID)
+		if deleteErr != nil {
+			return 0, deleteErr
+		}
 		return 0, err
 	}
 
@@ -43,46 +184,78 @@ func Upload(ctx context.Context, dbc *sql.DB, userID int64, reader io.ReadCloser
 		return 0, err
 	}
 
-	err = replace(ctx, dbc, tempPath, writtenBytes, documentID, mediaType, filename)
+	_, err = replace(ctx, dbc, temp, documentID, userID, mediaType, filename, record)
 	if err != nil {
+		// The document is left without contents unless they were stored before the error.
+		_ = db.DeleteIncompleteDocument(ctx, dbc, documentID)
 		return 0, err
 	}
 
-	return int(documentID), nil
+	return documentID, nil
 }
 
 func new(ctx context.Context, dbc *sql.DB, userID int64) (int64, error) {
-	res, err := dbc.ExecContext(ctx, "insert into `documents` (`owner`) values (?)", userID)
-	if err != nil {
-		return 0, err
-	}
+	return db.InsertDocument(ctx, dbc, userID, sql.NullInt64{}, time.Now())
+}
 
-	return res.LastInsertId()
+// Update stores new contents for a document as its next version and returns the number of that version. If
+// expectedVersions are given, the update only goes ahead if the document's current version is one of them, and fails
+// with ErrVersionConflict otherwise, so that clients don't overwrite changes they haven't seen.
+func Update(ctx context.Context, dbc *sql.DB, documentID int64, userID int64, reader io.ReadCloser, mediaType string, filename string, expectedVersions ...int64) (int64, error) {
+	return update(ctx, dbc, documentID, userID, reader, mediaType, filename, nil, expectedVersions...)
 }
 
-func Update(ctx context.Context, dbc *sql.DB, documentID int64, userID int64, reader io.ReadCloser, mediaType string, filename string) error {
-	// TODO: Ensure only new files created simultaneously
+func update(ctx context.Context, dbc *sql.DB, documentID int64, userID int64, reader io.ReadCloser, mediaType string, filename string,
+	record Recorder, expectedVersions ...int64) (int64, error) {
 	doc, err := Get(ctx, dbc, documentID)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	err = access_control.AuthoriseOrError(ctx, dbc, userID, doc, models2.WRITE)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	if doc.Version == 0 {
-		_, err = Upload(ctx, dbc, userID, reader, mediaType, filename)
-		return err
+	err = checkVersion(doc.Version, expectedVersions)
+	if err != nil {
+		return 0, err
 	}
 
-	tempPath, writtenBytes, err := createTempFile(documentID, reader)
+	err = checkLock(ctx, dbc, documentID, userID)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	// Versions count towards the quota of the document's owner, whoever uploads them.
+	limited, err := limitUpload(ctx, dbc, doc.Owner, reader)
+	if err != nil {
+		return 0, err
+	}
+
+	temp, err := createTempFile(documentID, limited)
+	if err != nil {
+		return 0, err
+	}
+
+	err = checkMediaType(ctx, dbc, userID, mediaType, temp)
+	if err != nil {
+		return 0, err
 	}
 
-	return replace(ctx, dbc, tempPath, writtenBytes, documentID, mediaType, filename)
+	return replace(ctx, dbc, temp, documentID, userID, mediaType, filename, record, expectedVersions...)
+}
+
+func checkVersion(version int64, expectedVersions []int64) error {
+	if len(expectedVersions) == 0 {
+		return nil
+	}
+	for _, expected := range expectedVersions {
+		if version == expected {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: current version is %d", ErrVersionConflict, version)
 }
 
 var tempDir = mustMakeNewTemp()
@@ -95,86 +268,223 @@ func mustMakeNewTemp() string {
 	return dir
 }
 
-func createTempFile(documentID int64, reader io.Reader) (string, int64, error) {
-	tempPath := path.Join(tempDir, strconv.FormatInt(documentID, 10))
-	file, err := os.Create(tempPath)
+type tempFile struct {
+	path   string
+	size   int64
+	sha256 string
+	md5    string
+	// mediaType is detected from the first bytes of the contents.
+	mediaType string
+}
+
+// createTempFile stages the contents of reader on local disk, hashing them and detecting their media type on the way.
+func createTempFile(documentID int64, reader io.Reader) (tempFile, error) {
+	file, err := os.CreateTemp(tempDir, strconv.FormatInt(documentID, 10)+"-*")
 	if err != nil {
-		return "", 0, err
+		return tempFile{}, err
 	}
 
-	writtenBytes, err := io.Copy(file, reader)
+	sha256Hash := sha256.New()
+	md5Hash := md5.New()
+	sniffer := &mediaTypeSniffer{}
+	writtenBytes, err := io.Copy(io.MultiWriter(file, sha256Hash, md5Hash, sniffer), reader)
 	if err != nil {
-		return "", 0, err
+		file.Close()
+		os.Remove(file.Name())
+		return tempFile{}, err
 	}
-	return tempPath, writtenBytes, file.Close()
+	return tempFile{
+		path:      file.Name(),
+		size:      writtenBytes,
+		sha256:    hex.EncodeToString(sha256Hash.Sum(nil)),
+		md5:       hex.EncodeToString(md5Hash.Sum(nil)),
+		mediaType: sniffer.mediaType(),
+	}, file.Close()
 }
 
-// Replace the existing file with the updated file in a thread safe manner.
-func replace(ctx context.Context, dbc *sql.DB, oldPath string, size int64, documentID int64, mediaType string, filename string) error {
-	tx, err := dbc.Begin()
+// Replace the existing file with the updated file in a thread safe manner. The previous version is kept in the
+// document's history, along with the blobs it references. The document's row stays locked until the new version is
+// committed, so concurrent updates are applied one after the other and each sees the version the other stored.
+func replace(ctx context.Context, dbc *sql.DB, temp tempFile, documentID int64, userID int64, mediaType string, filename string,
+	record Recorder, expectedVersions ...int64) (int64, error) {
+	defer os.Remove(temp.path)
+
+	tx, err := dbc.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	doc, err := db.GetForUpdate(ctx, tx, documentID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = checkVersion(doc.Version, expectedVersions)
+	if err != nil {
+		return 0, err
+	}
+
+	err = checkLock(ctx, tx, documentID, userID)
+	if err != nil {
+		return 0, err
 	}
+
+	if filename != doc.FileName {
+		err = checkDocumentNameFree(ctx, tx, doc.Owner, doc.Folder, filename)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = recordUnversioned(ctx, tx, doc)
+	if err != nil {
+		return 0, err
+	}
+
+	key, storedSize, createdKeys, err := storeContents(ctx, tx, temp, documentID, doc.Version+1, mediaType)
+	// Blobs stored for the version are deleted again if the transaction that records them rolls back.
+	committed := false
 	defer func() {
-		err = tx.Commit()
+		if !committed && len(createdKeys) > 0 {
+			_ = tx.Rollback()
+			_ = deleteBlobs(ctx, dbc, createdKeys)
+		}
 	}()
+	if err != nil {
+		return 0, err
+	}
 
-	doc, err := db.Get(ctx, tx, documentID)
+	updated := db.Document{
+		ID:                documentID,
+		Path:              key,
+		Version:           doc.Version + 1,
+		Size:              temp.size,
+		StoredSize:        storedSize,
+		SHA256:            temp.sha256,
+		MD5:               temp.md5,
+		MediaType:         mediaType,
+		DetectedMediaType: temp.mediaType,
+		FileName:          filename,
+		Modified:          time.Now(),
+	}
+	err = db.UpdateDocument(ctx, tx, updated)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	newPath := path.Join(workPath, strconv.FormatInt(documentID, 10)+"_v"+strconv.FormatInt(doc.Version+1, 10))
-	println(os.Getwd())
-	err = os.Rename(oldPath, newPath)
+	v := versionOf(updated, sql.NullInt64{Int64: userID, Valid: true}, updated.Modified)
+	v.ScanState = string(initialScanState())
+	err = db.InsertVersion(ctx, tx, v)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	if record != nil {
+		err = record(ctx, tx, documentID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
 	}
+	committed = true
 
-	err = db.UpdateDocument(ctx, tx, newPath, size, documentID, doc.Version+1, mediaType, filename)
+	// The version is scanned once it is stored, so a version whose scan is interrupted stays pending. The version is
+	// stored either way, so failing to record the scan does not fail the upload.
+	state, err := scanVersion(ctx, dbc, temp, documentID, updated.Version, userID)
 	if err != nil {
+		log.Default().Printf("recording the scan of version %d of document %d: %v\n", updated.Version, documentID, err)
+	}
+
+	indexName(ctx, dbc, documentID, filename)
+	indexContents(ctx, dbc, documentID, mediaType, temp.mediaType, state, func() (io.ReadCloser, error) {
+		return os.Open(temp.path)
+	})
+	return updated.Version, nil
+}
+
+// recordUnversioned adds the current version of a document stored before versions were recorded to its history, so
+// it stays reachable once the document is updated. Who uploaded it and when is not known.
+func recordUnversioned(ctx context.Context, tx *sql.Tx, doc db.Document) error {
+	if doc.Version == 0 {
+		return nil
+	}
+
+	_, err := db.GetVersion(ctx, tx, doc.ID, doc.Version)
+	if !errors.Is(err, sql.ErrNoRows) {
 		return err
 	}
+	return db.InsertVersion(ctx, tx, versionOf(doc, sql.NullInt64{}, time.Now()))
+}
 
-	return nil
+func versionOf(doc db.Document, uploader sql.NullInt64, created time.Time) db.Version {
+	return db.Version{
+		Document:          doc.ID,
+		Version:           doc.Version,
+		Path:              doc.Path,
+		Size:              doc.Size,
+		StoredSize:        doc.StoredSize,
+		SHA256:            doc.SHA256,
+		MD5:               doc.MD5,
+		MediaType:         doc.MediaType,
+		DetectedMediaType: doc.DetectedMediaType,
+		FileName:          doc.FileName,
+		Uploader:          uploader,
+		Created:           created,
+	}
 }
 
-func ListDocuments(ctx context.Context, dbc *sql.DB, userID int64) ([]models.Document, error) {
+// ListDocuments returns a page of the user's documents that match a filter, with their labels.
+func ListDocuments(ctx context.Context, dbc *sql.DB, userID int64, filter models.Filter, page models.Page) (models.DocumentPage, error) {
 	var documents []models.Document
-	dbDocuments, err := db.ListDocuments(ctx, dbc, userID)
+	dbDocuments, err := db.ListDocuments(ctx, dbc, userID, filter, page)
 	if err != nil {
-		return nil, err
+		return models.DocumentPage{}, err
 	}
 
 	for _, document := range dbDocuments {
 		documents = append(documents, models.Document{
-			ID:        document.ID,
-			Size:      document.Size,
-			MediaType: document.MediaType,
-			FileName:  document.FileName,
+			ID:                document.ID,
+			Version:           document.Version,
+			Size:              document.Size,
+			StoredSize:        document.StoredSize,
+			SHA256:            document.SHA256,
+			MD5:               document.MD5,
+			MediaType:         document.MediaType,
+			DetectedMediaType: document.DetectedMediaType,
+			FileName:          document.FileName,
+			Folder:            document.Folder.Int64,
+			Modified:          document.Modified,
 		})
 	}
-	return documents, nil
+	return listingPage(ctx, dbc, documents, page)
 }
 
-func ListSharedDocuments(ctx context.Context, dbc *sql.DB, userID int64) ([]models.Document, error) {
+// ListSharedDocuments returns a page of the documents shared with the user that match a filter, with their labels.
+func ListSharedDocuments(ctx context.Context, dbc *sql.DB, userID int64, filter models.Filter, page models.Page) (models.DocumentPage, error) {
 	var documents []models.Document
-	sharedDocumentIDs, err := access_control.SharedDocuments(ctx, dbc, userID)
+	dbDocuments, err := db.ListSharedDocuments(ctx, dbc, userID, filter, page)
 	if err != nil {
-		return nil, err
+		return models.DocumentPage{}, err
 	}
 
-	for _, id := range sharedDocumentIDs {
-		document, err := Get(ctx, dbc, id)
-		if err != nil {
-			return nil, err
-		}
-		documents = append(documents, document)
+	for _, document := range dbDocuments {
+		documents = append(documents, documentModel(document))
 	}
-	return documents, nil
+	return listingPage(ctx, dbc, documents, page)
+}
+
+func OpenDocument(ctx context.Context, dbc *sql.DB, documentID int64, userID int64) (models.Document, io.ReadSeekCloser, error) {
+	return OpenDocumentVersion(ctx, dbc, documentID, 0, userID)
 }
 
-func OpenDocument(ctx context.Context, dbc *sql.DB, documentID int64, userID int64) (models.Document, io.ReadCloser, error) {
+// OpenDocumentVersion opens the contents of a version of a document, or of its current version if version is 0. The
+// contents can be seeked in, but seeking backwards reads them from the start again.
+func OpenDocumentVersion(ctx context.Context, dbc *sql.DB, documentID int64, version int64, userID int64) (models.Document, io.ReadSeekCloser, error) {
 	doc, err := Get(ctx, dbc, documentID)
 	if err != nil {
 		return models.Document{}, nil, err
@@ -185,27 +495,408 @@ func OpenDocument(ctx context.Context, dbc *sql.DB, documentID int64, userID int
 		return models.Document{}, nil, err
 	}
 
-	file, err := os.Open(doc.Path)
+	v, err := getVersion(ctx, dbc, doc, version)
+	if err != nil {
+		return models.Document{}, nil, err
+	}
+
+	err = checkScanState(doc, models.ScanState(v.ScanState), userID)
+	if err != nil {
+		return models.Document{}, nil, err
+	}
+
+	expected, err := storedChecksums(v.SHA256, v.MD5)
+	if err != nil {
+		return models.Document{}, nil, err
+	}
+
+	contents, err := newContentsReader(v.Size, func() (io.ReadCloser, error) {
+		file, err := openContents(ctx, dbc, v.Document, v.Version, v.Path)
+		if err != nil {
+			return nil, err
+		}
+		return NewVerifyingReader(file, expected), nil
+	})
+	if err != nil {
+		return models.Document{}, nil, err
+	}
+
 	return models.Document{
-		ID:        doc.ID,
-		Size:      doc.Size,
-		MediaType: doc.MediaType,
-		FileName:  doc.FileName,
-	}, file, err
+		ID:                doc.ID,
+		Version:           v.Version,
+		Size:              v.Size,
+		SHA256:            v.SHA256,
+		MD5:               v.MD5,
+		MediaType:         v.MediaType,
+		DetectedMediaType: v.DetectedMediaType,
+		FileName:          v.FileName,
+		Modified:          v.Created,
+		ScanState:         models.ScanState(v.ScanState),
+	}, contents, nil
+}
+
+// getVersion returns a version of a document, or its current version if version is 0.
+func getVersion(ctx context.Context, dbc db.DBTransaction, doc models.Document, version int64) (db.Version, error) {
+	if version == 0 {
+		version = doc.Version
+	}
+	v, err := db.GetVersion(ctx, dbc, doc.ID, version)
+	if errors.Is(err, sql.ErrNoRows) && version == doc.Version {
+		// The current version of a document stored before versions were recorded.
+		return db.Version{Document: doc.ID, Version: doc.Version, Path: doc.Path, Size: doc.Size, SHA256: doc.SHA256,
+			MD5: doc.MD5, MediaType: doc.MediaType, DetectedMediaType: doc.DetectedMediaType, FileName: doc.FileName}, nil
+	} else if errors.Is(err, sql.ErrNoRows) {
+		return db.Version{}, fmt.Errorf("%w: version %d of document %d", ErrVersionNotFound, version, doc.ID)
+	}
+	return v, err
+}
+
+// ListVersions returns the history of a document, newest version first.
+func ListVersions(ctx context.Context, dbc *sql.DB, documentID int64, userID int64) ([]models.Version, error) {
+	doc, err := Get(ctx, dbc, documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = access_control.AuthoriseOrError(ctx, dbc, userID, doc, models2.READ)
+	if err != nil {
+		return nil, err
+	}
+
+	dbVersions, err := db.ListVersions(ctx, dbc, documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []models.Version
+	for _, v := range dbVersions {
+		versions = append(versions, models.Version{
+			Document:          v.Document,
+			Version:           v.Version,
+			Size:              v.Size,
+			SHA256:            v.SHA256,
+			MD5:               v.MD5,
+			MediaType:         v.MediaType,
+			DetectedMediaType: v.DetectedMediaType,
+			FileName:          v.FileName,
+			Uploader:          v.Uploader.Int64,
+			Created:           v.Created,
+			ScanState:         models.ScanState(v.ScanState),
+			ScanSignature:     v.ScanSignature,
+		})
+	}
+
+	// Documents stored before versions were recorded have no history yet, apart from their current version.
+	if len(versions) == 0 && doc.Version > 0 {
+		versions = append(versions, models.Version{
+			Document:          doc.ID,
+			Version:           doc.Version,
+			Size:              doc.Size,
+			SHA256:            doc.SHA256,
+			MD5:               doc.MD5,
+			MediaType:         doc.MediaType,
+			DetectedMediaType: doc.DetectedMediaType,
+			FileName:          doc.FileName,
+		})
+	}
+	return versions, nil
+}
+
+// Restore makes the contents of a previous version of a document its current version again. The restored contents
+// are stored as a new version that references the old version's contents, so the history in between is kept. It
+// returns the number of the new version.
+func Restore(ctx context.Context, dbc *sql.DB, documentID int64, version int64, userID int64) (int64, error) {
+	doc, err := Get(ctx, dbc, documentID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = access_control.AuthoriseOrError(ctx, dbc, userID, doc, models2.WRITE)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	current, err := db.GetForUpdate(ctx, tx, documentID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = checkLock(ctx, tx, documentID, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = recordUnversioned(ctx, tx, current)
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := db.GetVersion(ctx, tx, documentID, version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%w: version %d of document %d", ErrVersionNotFound, version, documentID)
+	} else if err != nil {
+		return 0, err
+	}
+
+	key, err := referenceContents(ctx, tx, v, documentID, current.Version+1)
+	if err != nil {
+		return 0, err
+	}
+
+	restored := db.Document{
+		ID:                documentID,
+		Path:              key,
+		Version:           current.Version + 1,
+		Size:              v.Size,
+		StoredSize:        v.StoredSize,
+		SHA256:            v.SHA256,
+		MD5:               v.MD5,
+		MediaType:         v.MediaType,
+		DetectedMediaType: v.DetectedMediaType,
+		FileName:          v.FileName,
+		Modified:          time.Now(),
+	}
+	err = db.UpdateDocument(ctx, tx, restored)
+	if err != nil {
+		return 0, err
+	}
+
+	// The restored contents have the same scan state as the version they came from.
+	restoredVersion := versionOf(restored, sql.NullInt64{Int64: userID, Valid: true}, restored.Modified)
+	restoredVersion.ScanState, restoredVersion.ScanSignature = v.ScanState, v.ScanSignature
+	err = db.InsertVersion(ctx, tx, restoredVersion)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	indexName(ctx, dbc, documentID, restored.FileName)
+	indexContents(ctx, dbc, documentID, restored.MediaType, restored.DetectedMediaType, models.ScanState(v.ScanState), func() (io.ReadCloser, error) {
+		return openContents(ctx, dbc, documentID, restored.Version, key)
+	})
+
+	err = access_control.Log(ctx, dbc, userID, documentID, fmt.Sprintf("Restore - Version:%d as Version:%d", version, restored.Version))
+	if err != nil {
+		return 0, err
+	}
+	return restored.Version, nil
+}
+
+// Rename changes the file name of a document. The current version takes the new name, previous versions keep theirs.
+func Rename(ctx context.Context, dbc *sql.DB, documentID int64, userID int64, name string) error {
+	err := checkName(name)
+	if err != nil {
+		return err
+	}
+
+	doc, err := Get(ctx, dbc, documentID)
+	if err != nil {
+		return err
+	}
+
+	err = access_control.AuthoriseOrError(ctx, dbc, userID, doc, models2.WRITE)
+	if err != nil {
+		return err
+	}
+
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := db.GetForUpdate(ctx, tx, documentID)
+	if err != nil {
+		return err
+	}
+	if current.FileName == name {
+		return nil
+	}
+
+	err = checkLock(ctx, tx, documentID, userID)
+	if err != nil {
+		return err
+	}
+
+	err = checkDocumentNameFree(ctx, tx, current.Owner, current.Folder, name)
+	if err != nil {
+		return err
+	}
+
+	err = recordUnversioned(ctx, tx, current)
+	if err != nil {
+		return err
+	}
+
+	err = db.RenameDocument(ctx, tx, documentID, current.Version, name)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	indexName(ctx, dbc, documentID, name)
+	return access_control.Log(ctx, dbc, userID, documentID, fmt.Sprintf("Rename - From:%s - To:%s", current.FileName, name))
+}
+
+// Copy makes a new document of the user's, in a folder of theirs or their root folder if folderID is 0, with the
+// contents of the current version of a document they can read. The copy references the same stored contents rather
+// than storing them again, and is named name, or after the document if name is empty. It returns the ID of the copy.
+func Copy(ctx context.Context, dbc *sql.DB, documentID int64, userID int64, folderID int64, name string) (int64, error) {
+	doc, err := Get(ctx, dbc, documentID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = access_control.AuthoriseOrError(ctx, dbc, userID, doc, models2.READ)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Locking the document keeps its versions from being pruned or purged while it is copied.
+	source, err := db.GetForUpdate(ctx, tx, documentID)
+	if err != nil {
+		return 0, err
+	}
+	v, err := getVersion(ctx, tx, doc, source.Version)
+	if err != nil {
+		return 0, err
+	}
+
+	// Anything the user could not download or upload, they can't copy either.
+	err = checkScanState(doc, models.ScanState(v.ScanState), userID)
+	if err != nil {
+		return 0, err
+	}
+	err = checkMediaTypes(ctx, dbc, userID, v.MediaType, v.DetectedMediaType)
+	if err != nil {
+		return 0, err
+	}
+	err = CheckSize(ctx, dbc, userID, v.Size)
+	if err != nil {
+		return 0, err
+	}
+
+	if name == "" {
+		name = v.FileName
+	}
+	err = checkName(name)
+	if err != nil {
+		return 0, err
+	}
+	if folderID != 0 {
+		_, err = ownFolder(ctx, tx, folderID, userID, db.GetFolderForUpdate)
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = checkDocumentNameFree(ctx, tx, userID, folderRef(folderID), name)
+	if err != nil {
+		return 0, err
+	}
+
+	copyID, err := db.InsertDocument(ctx, tx, userID, folderRef(folderID), time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	key, err := referenceContents(ctx, tx, v, copyID, 1)
+	if err != nil {
+		return 0, err
+	}
+
+	copied := db.Document{
+		ID:                copyID,
+		Path:              key,
+		Version:           1,
+		Size:              v.Size,
+		StoredSize:        v.StoredSize,
+		SHA256:            v.SHA256,
+		MD5:               v.MD5,
+		MediaType:         v.MediaType,
+		DetectedMediaType: v.DetectedMediaType,
+		FileName:          name,
+		Modified:          time.Now(),
+	}
+	err = db.UpdateDocument(ctx, tx, copied)
+	if err != nil {
+		return 0, err
+	}
+
+	copiedVersion := versionOf(copied, sql.NullInt64{Int64: userID, Valid: true}, copied.Modified)
+	copiedVersion.ScanState, copiedVersion.ScanSignature = v.ScanState, v.ScanSignature
+	err = db.InsertVersion(ctx, tx, copiedVersion)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	indexName(ctx, dbc, copyID, name)
+	indexCopiedContents(ctx, dbc, documentID, copyID)
+
+	err = access_control.Log(ctx, dbc, userID, documentID, fmt.Sprintf("Copy - Version:%d as Document:%d", v.Version, copyID))
+	if err != nil {
+		return 0, err
+	}
+	return copyID, nil
 }
 
+// Get returns a document, as if it does not exist if it is in the trash.
 func Get(ctx context.Context, dbc *sql.DB, documentID int64) (models.Document, error) {
 	doc, err := db.Get(ctx, dbc, documentID)
-	if err != nil {
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Document{}, fmt.Errorf("%w: %d", ErrNotFound, documentID)
+	} else if err != nil {
 		return models.Document{}, err
 	}
+	if doc.Deleted.Valid {
+		return models.Document{}, fmt.Errorf("%w: %d", ErrNotFound, documentID)
+	}
+	return documentModel(doc), nil
+}
+
+func documentModel(doc db.Document) models.Document {
+	var deleted *time.Time
+	if doc.Deleted.Valid {
+		deleted = &doc.Deleted.Time
+	}
 	return models.Document{
-		ID:        doc.ID,
-		Owner:     doc.Owner,
-		Path:      doc.Path,
-		Version:   doc.Version,
-		Size:      doc.Size,
-		MediaType: doc.MediaType,
-		FileName:  doc.FileName,
-	}, nil
+		ID:                doc.ID,
+		Owner:             doc.Owner,
+		Path:              doc.Path,
+		Version:           doc.Version,
+		Size:              doc.Size,
+		StoredSize:        doc.StoredSize,
+		SHA256:            doc.SHA256,
+		MD5:               doc.MD5,
+		MediaType:         doc.MediaType,
+		DetectedMediaType: doc.DetectedMediaType,
+		FileName:          doc.FileName,
+		Folder:            doc.Folder.Int64,
+		Modified:          doc.Modified,
+		Deleted:           deleted,
+	}
 }
@@ -3,15 +3,30 @@ package documents
 import (
 	"bytes"
 	"context"
+	"database/sql"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
+	accessModels "github.com/EatonEmmerich/cloudStorage/pkg/access_control/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/chunker"
 	"github.com/EatonEmmerich/cloudStorage/pkg/db"
 	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/encryption"
+	"github.com/EatonEmmerich/cloudStorage/pkg/storage"
 	"github.com/EatonEmmerich/cloudStorage/pkg/users"
 	fuzz "github.com/google/gofuzz"
 	"github.com/stretchr/testify/require"
 	"io"
 	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestUpload_Empty(t *testing.T) {
@@ -106,13 +121,14 @@ func TestOpenDocument(t *testing.T) {
 				documentID: int64(documentID),
 			},
 			expectedDocument: models.Document{
-				int64(documentID),
-				0,
-				"",
-				0,
-				int64(len(readerData)),
-				mediatype,
-				filename,
+				ID:                int64(documentID),
+				Version:           1,
+				Size:              int64(len(readerData)),
+				SHA256:            fmt.Sprintf("%x", sha256.Sum256(readerData)),
+				MD5:               fmt.Sprintf("%x", md5.Sum(readerData)),
+				MediaType:         mediatype,
+				DetectedMediaType: http.DetectContentType(readerData),
+				FileName:          filename,
 			},
 			expectedData: ioutil.NopCloser(bytes.NewReader(readerData)),
 		},
@@ -124,6 +140,8 @@ func TestOpenDocument(t *testing.T) {
 				t.Errorf("OpenDocument() error = %v, wantErr %v", err, tt.expectedErr)
 				return
 			}
+			require.False(t, document.Modified.IsZero())
+			document.Modified = time.Time{}
 			if !reflect.DeepEqual(document, tt.expectedDocument) {
 				t.Errorf("OpenDocument() got = %v, want %v", document, tt.expectedDocument)
 			}
@@ -139,3 +157,695 @@ func TestOpenDocument(t *testing.T) {
 		})
 	}
 }
+
+func TestOpenDocument_MemoryStore(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	memory := storage.NewMemory()
+	SetBlobStore(memory)
+	t.Cleanup(func() { SetBlobStore(storage.NewFileSystem(workPath)) })
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	documentID, err := Upload(ctx, dbc, userID, io.NopCloser(bytes.NewReader([]byte("contents"))), "text/plain", "file.txt")
+	require.NoError(t, err)
+
+	blobs, err := memory.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, blobs, 1)
+
+	_, data, err := OpenDocument(ctx, dbc, int64(documentID), userID)
+	require.NoError(t, err)
+	respData, err := ioutil.ReadAll(data)
+	require.NoError(t, err)
+	require.Equal(t, "contents", string(respData))
+}
+
+func TestMigrateLegacyPaths(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	documentID, err := Upload(ctx, dbc, userID, io.NopCloser(bytes.NewReader([]byte("legacy"))), "text/plain", "legacy.txt")
+	require.NoError(t, err)
+
+	// Contents were written straight to the work path, and their absolute path stored, before the blob store.
+	legacyPath := filepath.Join(workPath, fmt.Sprintf("%d_v1", documentID))
+	require.NoError(t, ioutil.WriteFile(legacyPath, []byte("legacy"), 0o644))
+	t.Cleanup(func() { os.Remove(legacyPath) })
+	for _, table := range []string{"documents", "document_versions"} {
+		_, err = dbc.Exec("update `"+table+"` set `path` = ?", legacyPath)
+		require.NoError(t, err)
+	}
+	_, _, err = OpenDocument(ctx, dbc, int64(documentID), userID)
+	require.Error(t, err)
+
+	n, err := MigrateLegacyPaths(ctx, dbc)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), n)
+
+	_, data, err := OpenDocument(ctx, dbc, int64(documentID), userID)
+	require.NoError(t, err)
+	respData, err := ioutil.ReadAll(data)
+	require.NoError(t, err)
+	require.NoError(t, data.Close())
+	require.Equal(t, "legacy", string(respData))
+
+	n, err = MigrateLegacyPaths(ctx, dbc)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), n)
+}
+
+func TestUpload_Deduplicates(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	memory := storage.NewMemory()
+	SetBlobStore(memory)
+	t.Cleanup(func() { SetBlobStore(storage.NewFileSystem(workPath)) })
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+
+	first, err := Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("same"))), "text/plain", "a.txt")
+	require.NoError(t, err)
+	second, err := Upload(ctx, dbc, jane, io.NopCloser(bytes.NewReader([]byte("same"))), "text/plain", "b.txt")
+	require.NoError(t, err)
+
+	blobs, err := memory.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, blobs, 1)
+
+	var refCount int
+	require.NoError(t, dbc.QueryRow("select `ref_count` from `blobs`").Scan(&refCount))
+	require.Equal(t, 2, refCount)
+
+	_, err = Update(ctx, dbc, int64(first), john, io.NopCloser(bytes.NewReader([]byte("changed"))), "text/plain", "a.txt")
+	require.NoError(t, err)
+	blobs, err = memory.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, blobs, 2)
+
+	_, err = Update(ctx, dbc, int64(second), jane, io.NopCloser(bytes.NewReader([]byte("changed"))), "text/plain", "b.txt")
+	require.NoError(t, err)
+	blobs, err = memory.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, blobs, 2)
+
+	// The first versions are kept in both documents' history.
+	rows, err := dbc.Query("select `ref_count` from `blobs`")
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		require.NoError(t, rows.Scan(&refCount))
+		require.Equal(t, 2, refCount)
+	}
+	require.NoError(t, rows.Err())
+}
+
+func TestDeleteBlobs_StoredAgain(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	memory := storage.NewMemory()
+	SetBlobStore(memory)
+	t.Cleanup(func() { SetBlobStore(storage.NewFileSystem(workPath)) })
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	first, err := Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("same"))), "text/plain", "a.txt")
+	require.NoError(t, err)
+	doc, err := Get(ctx, dbc, int64(first))
+	require.NoError(t, err)
+
+	// Release the only reference to the blob, as purging or pruning does.
+	tx, err := dbc.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	freedKeys, err := releaseContents(ctx, tx, doc.ID, doc.Version, doc.Path)
+	require.NoError(t, err)
+	require.Len(t, freedKeys, 1)
+	require.NoError(t, tx.Commit())
+
+	// The same contents are stored again before the released blob is deleted.
+	second, err := Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("same"))), "text/plain", "b.txt")
+	require.NoError(t, err)
+	require.NoError(t, deleteBlobs(ctx, dbc, freedKeys))
+
+	_, data, err := OpenDocument(ctx, dbc, int64(second), john)
+	require.NoError(t, err)
+	respData, err := ioutil.ReadAll(data)
+	require.NoError(t, err)
+	require.Equal(t, "same", string(respData))
+
+	// Blobs that are still unreferenced are deleted.
+	tx, err = dbc.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	freedKeys, err = releaseContents(ctx, tx, int64(second), 1, freedKeys[0])
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	require.NoError(t, deleteBlobs(ctx, dbc, freedKeys))
+	blobs, err := memory.List(ctx, "")
+	require.NoError(t, err)
+	require.Empty(t, blobs)
+}
+
+func TestStore_RolledBack(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	memory := storage.NewMemory()
+	SetBlobStore(memory)
+	t.Cleanup(func() { SetBlobStore(storage.NewFileSystem(workPath)) })
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	kept, err := Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("kept"))), "text/plain", "a.txt")
+	require.NoError(t, err)
+
+	// Contents stored in a transaction that rolls back are deleted again, but contents stored before are left alone.
+	failed := errors.New("failed")
+	for _, documentID := range []int64{0, int64(kept)} {
+		for _, contents := range []string{"new", "kept"} {
+			_, err = Store(ctx, dbc, documentID, john, io.NopCloser(bytes.NewReader([]byte(contents))), "text/plain", "a.txt",
+				func(context.Context, *sql.Tx, int64) error { return failed })
+			require.ErrorIs(t, err, failed)
+		}
+	}
+
+	blobs, err := memory.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, blobs, 1)
+	_, data, err := OpenDocument(ctx, dbc, int64(kept), john)
+	require.NoError(t, err)
+	respData, err := ioutil.ReadAll(data)
+	require.NoError(t, err)
+	require.Equal(t, "kept", string(respData))
+}
+
+func TestUpdate_Chunked(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	memory := storage.NewMemory()
+	SetBlobStore(memory)
+	chunkOptions = &chunker.Options{MinSize: 256, AvgSize: 1024, MaxSize: 4096}
+	t.Cleanup(func() {
+		SetBlobStore(storage.NewFileSystem(workPath))
+		chunkOptions = nil
+	})
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	data := make([]byte, 64<<10)
+	rand.New(rand.NewSource(1)).Read(data)
+	documentID, err := Upload(ctx, dbc, userID, io.NopCloser(bytes.NewReader(data)), "application/octet-stream", "disk.img")
+	require.NoError(t, err)
+
+	blobs, err := memory.List(ctx, "")
+	require.NoError(t, err)
+	require.True(t, len(blobs) > 1)
+	firstVersionChunks := make(map[string]bool)
+	for _, blob := range blobs {
+		firstVersionChunks[blob.Key] = true
+	}
+
+	edited := append([]byte(nil), data...)
+	copy(edited[30000:], "edited")
+	_, err = Update(ctx, dbc, int64(documentID), userID, io.NopCloser(bytes.NewReader(edited)), "application/octet-stream", "disk.img")
+	require.NoError(t, err)
+
+	blobs, err = memory.List(ctx, "")
+	require.NoError(t, err)
+	var newChunks int
+	for _, blob := range blobs {
+		if !firstVersionChunks[blob.Key] {
+			newChunks++
+		}
+	}
+	require.True(t, newChunks <= 2, "edit stored %d new chunks", newChunks)
+
+	_, reader, err := OpenDocument(ctx, dbc, int64(documentID), userID)
+	require.NoError(t, err)
+	got, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.True(t, bytes.Equal(edited, got))
+
+	// Copies reference the same chunks.
+	copyID, err := Copy(ctx, dbc, int64(documentID), userID, 0, "copy.img")
+	require.NoError(t, err)
+	_, reader, err = OpenDocument(ctx, dbc, copyID, userID)
+	require.NoError(t, err)
+	got, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.True(t, bytes.Equal(edited, got))
+}
+
+func TestUpload_Compressed(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	SetBlobStore(storage.NewMemory())
+	t.Cleanup(func() {
+		SetBlobStore(storage.NewFileSystem(workPath))
+		compressionAlgorithm = compressionNone
+	})
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	text := bytes.Repeat([]byte("id,name,amount\n1,acme,100\n"), 1000)
+	tests := []struct {
+		algorithm      string
+		mediaType      string
+		data           []byte
+		wantCompressed bool
+	}{
+		{algorithm: compressionZstd, mediaType: "text/csv", data: text, wantCompressed: true},
+		{algorithm: compressionGzip, mediaType: "application/json; charset=utf-8", data: append(text, '1'), wantCompressed: true},
+		{algorithm: compressionZstd, mediaType: "image/png", data: append(text, '2'), wantCompressed: false},
+		{algorithm: compressionNone, mediaType: "text/csv", data: append(text, '3'), wantCompressed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm+" "+tt.mediaType, func(t *testing.T) {
+			compressionAlgorithm = tt.algorithm
+
+			documentID, err := Upload(ctx, dbc, userID, io.NopCloser(bytes.NewReader(tt.data)), tt.mediaType, "data")
+			require.NoError(t, err)
+
+			doc, err := Get(ctx, dbc, int64(documentID))
+			require.NoError(t, err)
+			require.Equal(t, int64(len(tt.data)), doc.Size)
+			require.Equal(t, tt.wantCompressed, doc.StoredSize < doc.Size, "stored %d of %d bytes", doc.StoredSize, doc.Size)
+
+			_, reader, err := OpenDocument(ctx, dbc, int64(documentID), userID)
+			require.NoError(t, err)
+			got, err := ioutil.ReadAll(reader)
+			require.NoError(t, err)
+			require.NoError(t, reader.Close())
+			require.True(t, bytes.Equal(tt.data, got))
+		})
+	}
+}
+
+func TestUpload_EncryptedRotation(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	memory := storage.NewMemory()
+	SetBlobStore(memory)
+	compressionAlgorithm = compressionZstd
+	t.Cleanup(func() {
+		SetBlobStore(storage.NewFileSystem(workPath))
+		SetKeyProvider(nil)
+		compressionAlgorithm = compressionNone
+	})
+
+	oldKeyFile := filepath.Join(t.TempDir(), "old.key")
+	newKeyFile := filepath.Join(t.TempDir(), "new.key")
+	require.NoError(t, encryption.GenerateMasterKeyFile(oldKeyFile))
+	require.NoError(t, encryption.GenerateMasterKeyFile(newKeyFile))
+	oldProvider, err := encryption.NewFileKeyProvider(oldKeyFile)
+	require.NoError(t, err)
+	SetKeyProvider(oldProvider)
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	data := bytes.Repeat([]byte("top secret "), 1000)
+	documentID, err := Upload(ctx, dbc, userID, io.NopCloser(bytes.NewReader(data)), "text/plain", "secret.txt")
+	require.NoError(t, err)
+
+	blobs, err := memory.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, blobs, 1)
+	stored, err := memory.Get(ctx, blobs[0].Key)
+	require.NoError(t, err)
+	storedData, err := ioutil.ReadAll(stored)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(storedData, []byte("secret")))
+
+	rotatingProvider, err := encryption.NewFileKeyProvider(newKeyFile, oldKeyFile)
+	require.NoError(t, err)
+	n, err := RotateDataKeys(ctx, dbc, rotatingProvider)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	newProvider, err := encryption.NewFileKeyProvider(newKeyFile)
+	require.NoError(t, err)
+	SetKeyProvider(newProvider)
+
+	_, reader, err := OpenDocument(ctx, dbc, int64(documentID), userID)
+	require.NoError(t, err)
+	got, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.True(t, bytes.Equal(data, got))
+
+	rotatedBlobs, err := memory.List(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, blobs[0].ModTime, rotatedBlobs[0].ModTime)
+}
+
+func TestOpenDocument_Corrupted(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	memory := storage.NewMemory()
+	SetBlobStore(memory)
+	t.Cleanup(func() { SetBlobStore(storage.NewFileSystem(workPath)) })
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	documentID, err := Upload(ctx, dbc, userID, io.NopCloser(bytes.NewReader([]byte("contents"))), "text/plain", "file.txt")
+	require.NoError(t, err)
+
+	doc, err := Get(ctx, dbc, int64(documentID))
+	require.NoError(t, err)
+	_, err = memory.Put(ctx, doc.Path, bytes.NewReader([]byte("contentz")))
+	require.NoError(t, err)
+
+	_, reader, err := OpenDocument(ctx, dbc, int64(documentID), userID)
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(reader)
+	require.True(t, errors.Is(err, ErrChecksumMismatch))
+}
+
+func TestUpload_ChecksumMismatch(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	md5Sum := md5.Sum([]byte("contents"))
+	reader := NewVerifyingReader(io.NopCloser(bytes.NewReader([]byte("truncated"))), Checksums{MD5: md5Sum[:]})
+	_, err = Upload(ctx, dbc, userID, reader, "text/plain", "file.txt")
+	require.True(t, errors.Is(err, ErrChecksumMismatch))
+
+	sha256Sum := sha256.Sum256([]byte("contents"))
+	reader = NewVerifyingReader(io.NopCloser(bytes.NewReader([]byte("contents"))), Checksums{SHA256: sha256Sum[:], MD5: md5Sum[:]})
+	_, err = Upload(ctx, dbc, userID, reader, "text/plain", "file.txt")
+	require.NoError(t, err)
+}
+
+func TestListVersions(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+	bob, err := users.Register(ctx, dbc, "bob", "password")
+	require.NoError(t, err)
+
+	documentID, err := Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("first"))), "text/plain", "a.txt")
+	require.NoError(t, err)
+	doc, err := Get(ctx, dbc, int64(documentID))
+	require.NoError(t, err)
+	require.NoError(t, access_control.ShareDocument(ctx, dbc, doc, john, jane, accessModels.READ|accessModels.WRITE))
+
+	_, err = Update(ctx, dbc, int64(documentID), jane, io.NopCloser(bytes.NewReader([]byte("second"))), "text/markdown", "a.md")
+	require.NoError(t, err)
+	_, err = Update(ctx, dbc, int64(documentID), john, io.NopCloser(bytes.NewReader([]byte("third"))), "text/plain", "a.txt")
+	require.NoError(t, err)
+
+	versions, err := ListVersions(ctx, dbc, int64(documentID), jane)
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	require.Equal(t, []int64{3, 2, 1}, []int64{versions[0].Version, versions[1].Version, versions[2].Version})
+	require.Equal(t, jane, versions[1].Uploader)
+	require.Equal(t, "a.md", versions[1].FileName)
+	require.Equal(t, int64(len("second")), versions[1].Size)
+	require.False(t, versions[1].Created.IsZero())
+
+	for version, expected := range map[int64]string{0: "third", 1: "first", 2: "second", 3: "third"} {
+		doc, reader, err := OpenDocumentVersion(ctx, dbc, int64(documentID), version, jane)
+		require.NoError(t, err)
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		require.Equal(t, expected, string(data))
+		if version != 0 {
+			require.Equal(t, version, doc.Version)
+		}
+	}
+
+	_, _, err = OpenDocumentVersion(ctx, dbc, int64(documentID), 4, jane)
+	require.ErrorIs(t, err, ErrVersionNotFound)
+
+	_, err = ListVersions(ctx, dbc, int64(documentID), bob)
+	require.ErrorIs(t, err, access_control.ErrAccessDenied)
+	_, _, err = OpenDocumentVersion(ctx, dbc, int64(documentID), 1, bob)
+	require.ErrorIs(t, err, access_control.ErrAccessDenied)
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	memory := storage.NewMemory()
+	SetBlobStore(memory)
+	t.Cleanup(func() { SetBlobStore(storage.NewFileSystem(workPath)) })
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+
+	documentID, err := Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("good"))), "text/plain", "a.txt")
+	require.NoError(t, err)
+	_, err = Update(ctx, dbc, int64(documentID), john, io.NopCloser(bytes.NewReader([]byte("bad"))), "text/plain", "a.txt")
+	require.NoError(t, err)
+
+	doc, err := Get(ctx, dbc, int64(documentID))
+	require.NoError(t, err)
+	require.NoError(t, access_control.ShareDocument(ctx, dbc, doc, john, jane, accessModels.READ))
+	_, err = Restore(ctx, dbc, int64(documentID), 1, jane)
+	require.ErrorIs(t, err, access_control.ErrAccessDenied)
+	_, err = Restore(ctx, dbc, int64(documentID), 5, john)
+	require.ErrorIs(t, err, ErrVersionNotFound)
+
+	version, err := Restore(ctx, dbc, int64(documentID), 1, john)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), version)
+
+	_, reader, err := OpenDocument(ctx, dbc, int64(documentID), john)
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, "good", string(data))
+
+	// The restored version references the original contents, and nothing in the history is lost.
+	versions, err := ListVersions(ctx, dbc, int64(documentID), john)
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	require.Equal(t, versions[2].SHA256, versions[0].SHA256)
+	require.Equal(t, john, versions[0].Uploader)
+	blobs, err := memory.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, blobs, 2)
+	var refCount int
+	require.NoError(t, dbc.QueryRow("select `ref_count` from `blobs` where `digest` = ?", versions[0].SHA256).Scan(&refCount))
+	require.Equal(t, 2, refCount)
+
+	var action string
+	require.NoError(t, dbc.QueryRow("select `action` from `audit_log` where `action` like 'Restore%'").Scan(&action))
+	require.Equal(t, "Restore - Version:1 as Version:3", action)
+
+	issues, err := Fsck(ctx, dbc, false, true)
+	require.NoError(t, err)
+	require.Empty(t, issues)
+}
+
+func TestRestore_Chunked(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	SetBlobStore(storage.NewMemory())
+	chunkOptions = &chunker.Options{MinSize: 256, AvgSize: 1024, MaxSize: 4096}
+	t.Cleanup(func() {
+		SetBlobStore(storage.NewFileSystem(workPath))
+		chunkOptions = nil
+	})
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	data := make([]byte, 16<<10)
+	rand.New(rand.NewSource(2)).Read(data)
+	documentID, err := Upload(ctx, dbc, userID, io.NopCloser(bytes.NewReader(data)), "application/octet-stream", "disk.img")
+	require.NoError(t, err)
+	_, err = Update(ctx, dbc, int64(documentID), userID, io.NopCloser(bytes.NewReader([]byte("overwritten"))), "application/octet-stream", "disk.img")
+	require.NoError(t, err)
+
+	_, err = Restore(ctx, dbc, int64(documentID), 1, userID)
+	require.NoError(t, err)
+
+	_, reader, err := OpenDocument(ctx, dbc, int64(documentID), userID)
+	require.NoError(t, err)
+	got, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.True(t, bytes.Equal(data, got))
+
+	issues, err := Fsck(ctx, dbc, false, true)
+	require.NoError(t, err)
+	require.Empty(t, issues)
+}
+
+func TestUpdate_ExpectedVersion(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	documentID, err := Upload(ctx, dbc, userID, io.NopCloser(bytes.NewReader([]byte("first"))), "text/plain", "a.txt")
+	require.NoError(t, err)
+
+	version, err := Update(ctx, dbc, int64(documentID), userID, io.NopCloser(bytes.NewReader([]byte("second"))), "text/plain", "a.txt", 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), version)
+
+	_, err = Update(ctx, dbc, int64(documentID), userID, io.NopCloser(bytes.NewReader([]byte("stale"))), "text/plain", "a.txt", 1)
+	require.ErrorIs(t, err, ErrVersionConflict)
+
+	version, err = Update(ctx, dbc, int64(documentID), userID, io.NopCloser(bytes.NewReader([]byte("third"))), "text/plain", "a.txt")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), version)
+
+	_, reader, err := OpenDocument(ctx, dbc, int64(documentID), userID)
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, "third", string(data))
+}
+
+func TestRename(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+
+	documentID, err := Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("first"))), "text/plain", "draft.txt")
+	require.NoError(t, err)
+	_, err = Update(ctx, dbc, int64(documentID), john, io.NopCloser(bytes.NewReader([]byte("second"))), "text/plain", "draft.txt")
+	require.NoError(t, err)
+
+	require.True(t, errors.Is(Rename(ctx, dbc, int64(documentID), jane, "final.txt"), access_control.ErrAccessDenied))
+	require.True(t, errors.Is(Rename(ctx, dbc, int64(documentID), john, ""), ErrInvalidName))
+	require.NoError(t, Rename(ctx, dbc, int64(documentID), john, "final.txt"))
+
+	doc, reader, err := OpenDocument(ctx, dbc, int64(documentID), john)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, "final.txt", doc.FileName)
+	require.Equal(t, int64(2), doc.Version)
+
+	versions, err := ListVersions(ctx, dbc, int64(documentID), john)
+	require.NoError(t, err)
+	require.Equal(t, "final.txt", versions[0].FileName)
+	require.Equal(t, "draft.txt", versions[1].FileName)
+
+	// Documents in the root folder may share names, but not with documents in other folders.
+	otherID, err := Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("other"))), "text/plain", "other.txt")
+	require.NoError(t, err)
+	require.NoError(t, Rename(ctx, dbc, int64(otherID), john, "final.txt"))
+	folder, err := CreateFolder(ctx, dbc, john, 0, "drafts")
+	require.NoError(t, err)
+	require.NoError(t, MoveDocument(ctx, dbc, int64(otherID), folder.ID, john))
+	require.NoError(t, Rename(ctx, dbc, int64(otherID), john, "other.txt"))
+	require.NoError(t, MoveDocument(ctx, dbc, int64(documentID), folder.ID, john))
+	require.True(t, errors.Is(Rename(ctx, dbc, int64(documentID), john, "other.txt"), ErrNameTaken))
+}
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	memory := storage.NewMemory()
+	SetBlobStore(memory)
+	t.Cleanup(func() { SetBlobStore(storage.NewFileSystem(workPath)) })
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+
+	documentID, err := Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("contents"))), "text/plain", "report.txt")
+	require.NoError(t, err)
+
+	_, err = Copy(ctx, dbc, int64(documentID), jane, 0, "")
+	require.True(t, errors.Is(err, access_control.ErrAccessDenied))
+
+	doc, err := Get(ctx, dbc, int64(documentID))
+	require.NoError(t, err)
+	require.NoError(t, access_control.ShareDocument(ctx, dbc, doc, john, jane, accessModels.READ))
+
+	_, err = Copy(ctx, dbc, int64(documentID), jane, 0, "a/b")
+	require.True(t, errors.Is(err, ErrInvalidName))
+	// Copies into the root folder may share names, as uploads there can.
+	for i := 0; i < 2; i++ {
+		rootCopyID, err := Copy(ctx, dbc, int64(documentID), jane, 0, "")
+		require.NoError(t, err)
+		require.NoError(t, Delete(ctx, dbc, rootCopyID, jane))
+		require.NoError(t, Purge(ctx, dbc, rootCopyID, jane))
+	}
+
+	folder, err := CreateFolder(ctx, dbc, jane, 0, "reports")
+	require.NoError(t, err)
+	copyID, err := Copy(ctx, dbc, int64(documentID), jane, folder.ID, "")
+	require.NoError(t, err)
+	_, err = Copy(ctx, dbc, int64(documentID), jane, folder.ID, "")
+	require.True(t, errors.Is(err, ErrNameTaken))
+
+	copied, reader, err := OpenDocument(ctx, dbc, copyID, jane)
+	require.NoError(t, err)
+	got, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, "contents", string(got))
+	require.Equal(t, "report.txt", copied.FileName)
+	require.Equal(t, int64(1), copied.Version)
+
+	copiedDoc, err := Get(ctx, dbc, copyID)
+	require.NoError(t, err)
+	require.Equal(t, jane, copiedDoc.Owner)
+	require.Equal(t, folder.ID, copiedDoc.Folder)
+
+	// The copy is independent of the original, but shares its stored contents.
+	_, err = Update(ctx, dbc, int64(documentID), john, io.NopCloser(bytes.NewReader([]byte("changed"))), "text/plain", "report.txt")
+	require.NoError(t, err)
+	_, reader, err = OpenDocument(ctx, dbc, copyID, jane)
+	require.NoError(t, err)
+	got, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, "contents", string(got))
+
+	blobs, err := memory.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, blobs, 2)
+	var refCount int
+	require.NoError(t, dbc.QueryRow("select `ref_count` from `blobs` where `digest` = ?", doc.SHA256).Scan(&refCount))
+	require.Equal(t, 2, refCount)
+
+	usage, err := Usage(ctx, dbc, jane)
+	require.NoError(t, err)
+	require.Equal(t, int64(len("contents")), usage.Used)
+}
@@ -0,0 +1,98 @@
+package documents
+
+import (
+	"context"
+	"database/sql"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/internal/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/encryption"
+	"io"
+)
+
+// keyProvider enables encryption of new blobs when set. Every blob gets its own data key, so versions only share a
+// key when they share identical, deduplicated contents.
+var keyProvider encryption.KeyProvider
+
+// SetKeyProvider sets the provider that wraps the data keys of new blobs and unwraps those of existing ones.
+func SetKeyProvider(provider encryption.KeyProvider) {
+	keyProvider = provider
+}
+
+// encrypt returns a reader of reader's contents encrypted under a new data key, along with that key wrapped by the key
+// provider. Without a key provider contents are returned as is.
+func encrypt(ctx context.Context, reader io.Reader) (io.Reader, []byte, string, error) {
+	if keyProvider == nil {
+		return reader, nil, "", nil
+	}
+
+	dataKey, err := encryption.NewDataKey()
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	wrappedKey, keyID, err := keyProvider.Wrap(ctx, dataKey)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	encrypted, err := encryption.NewEncryptingReader(dataKey, reader)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return encrypted, wrappedKey, keyID, nil
+}
+
+type decryptReader struct {
+	io.Reader
+	io.Closer
+}
+
+func decrypt(ctx context.Context, blob db.Blob, reader io.ReadCloser) (io.ReadCloser, error) {
+	if blob.KeyID == "" {
+		return reader, nil
+	}
+	if keyProvider == nil {
+		reader.Close()
+		return nil, encryption.ErrUnknownKey
+	}
+
+	dataKey, err := keyProvider.Unwrap(ctx, blob.WrappedKey, blob.KeyID)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	decrypted, err := encryption.NewDecryptingReader(dataKey, reader)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return decryptReader{Reader: decrypted, Closer: reader}, nil
+}
+
+// RotateDataKeys re-wraps the data keys of all encrypted blobs under the provider's current master key, without
+// touching their contents. The provider must still be able to unwrap keys under the previous master keys. It returns
+// the number of keys re-wrapped, and can be run again to finish an interrupted rotation.
+func RotateDataKeys(ctx context.Context, dbc *sql.DB, provider encryption.KeyProvider) (int, error) {
+	blobs, err := db.ListWrappedKeys(ctx, dbc, provider.CurrentKeyID())
+	if err != nil {
+		return 0, err
+	}
+
+	for i, blob := range blobs {
+		dataKey, err := provider.Unwrap(ctx, blob.WrappedKey, blob.KeyID)
+		if err != nil {
+			return i, err
+		}
+
+		wrappedKey, keyID, err := provider.Wrap(ctx, dataKey)
+		if err != nil {
+			return i, err
+		}
+
+		err = db.SetWrappedKey(ctx, dbc, blob.Digest, blob.KeyID, wrappedKey, keyID)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(blobs), nil
+}
@@ -0,0 +1,336 @@
+package documents
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/internal/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"strings"
+	"time"
+)
+
+var ErrFolderNotFound = errors.New("folder not found")
+
+var ErrPathNotFound = errors.New("path not found")
+
+var ErrNameTaken = errors.New("name is already taken")
+
+var ErrInvalidName = errors.New("invalid name")
+
+var ErrFolderNotEmpty = errors.New("folder is not empty")
+
+// CreateFolder creates a folder of the user's in a parent folder, or in the user's root folder if parentID is 0.
+func CreateFolder(ctx context.Context, dbc *sql.DB, userID int64, parentID int64, name string) (models.Folder, error) {
+	err := checkName(name)
+	if err != nil {
+		return models.Folder{}, err
+	}
+
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Folder{}, err
+	}
+	defer tx.Rollback()
+
+	if parentID != 0 {
+		// Locking the parent keeps it from being deleted while the folder is created in it.
+		_, err = ownFolder(ctx, tx, parentID, userID, db.GetFolderForUpdate)
+		if err != nil {
+			return models.Folder{}, err
+		}
+	}
+
+	parent := folderRef(parentID)
+	err = checkNameFree(ctx, tx, userID, parent, name)
+	if err != nil {
+		return models.Folder{}, err
+	}
+
+	f := db.Folder{Owner: userID, Parent: parent, Name: name, Created: time.Now()}
+	f.ID, err = db.InsertFolder(ctx, tx, f)
+	if err != nil {
+		return models.Folder{}, err
+	}
+	return folderModel(f), tx.Commit()
+}
+
+// ListFolder returns the folders and documents in a folder of the user's, or in the user's root folder if folderID is
+// 0.
+func ListFolder(ctx context.Context, dbc *sql.DB, folderID int64, userID int64) (models.FolderContents, error) {
+	folder := models.Folder{Owner: userID}
+	if folderID != 0 {
+		f, err := ownFolder(ctx, dbc, folderID, userID, db.GetFolder)
+		if err != nil {
+			return models.FolderContents{}, err
+		}
+		folder = folderModel(f)
+	}
+	return listFolder(ctx, dbc, folder)
+}
+
+func listFolder(ctx context.Context, dbc *sql.DB, folder models.Folder) (models.FolderContents, error) {
+	dbFolders, err := db.ListFolders(ctx, dbc, folder.Owner, folderRef(folder.ID))
+	if err != nil {
+		return models.FolderContents{}, err
+	}
+
+	dbDocuments, err := db.ListFolderDocuments(ctx, dbc, folder.Owner, folderRef(folder.ID))
+	if err != nil {
+		return models.FolderContents{}, err
+	}
+
+	contents := models.FolderContents{Folder: folder, Folders: []models.Folder{}, Documents: []models.Document{}}
+	for _, f := range dbFolders {
+		contents.Folders = append(contents.Folders, folderModel(f))
+	}
+	for _, doc := range dbDocuments {
+		contents.Documents = append(contents.Documents, models.Document{
+			ID:                doc.ID,
+			Version:           doc.Version,
+			Size:              doc.Size,
+			StoredSize:        doc.StoredSize,
+			SHA256:            doc.SHA256,
+			MD5:               doc.MD5,
+			MediaType:         doc.MediaType,
+			DetectedMediaType: doc.DetectedMediaType,
+			FileName:          doc.FileName,
+			Folder:            doc.Folder.Int64,
+			Modified:          doc.Modified,
+		})
+	}
+	return contents, attachLocks(ctx, dbc, contents.Documents)
+}
+
+func RenameFolder(ctx context.Context, dbc *sql.DB, folderID int64, userID int64, name string) error {
+	err := checkName(name)
+	if err != nil {
+		return err
+	}
+
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	f, err := ownFolder(ctx, tx, folderID, userID, db.GetFolderForUpdate)
+	if err != nil {
+		return err
+	}
+	if f.Name == name {
+		return nil
+	}
+
+	err = checkNameFree(ctx, tx, userID, f.Parent, name)
+	if err != nil {
+		return err
+	}
+
+	err = db.RenameFolder(ctx, tx, folderID, name)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteFolder deletes an empty folder of the user's.
+func DeleteFolder(ctx context.Context, dbc *sql.DB, folderID int64, userID int64) error {
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Documents are locked before folders everywhere, and those in the trash are moved out of the folder below.
+	err = db.LockFolderDocuments(ctx, tx, folderID)
+	if err != nil {
+		return err
+	}
+	_, err = ownFolder(ctx, tx, folderID, userID, db.GetFolderForUpdate)
+	if err != nil {
+		return err
+	}
+
+	empty, err := db.FolderIsEmpty(ctx, tx, folderID)
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return fmt.Errorf("%w: %d", ErrFolderNotEmpty, folderID)
+	}
+
+	// Documents in the trash that were in the folder are restored to the root folder instead.
+	err = db.DetachTrash(ctx, tx, folderID)
+	if err != nil {
+		return err
+	}
+
+	err = db.DeleteFolder(ctx, tx, folderID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MoveDocument moves a document of the user's into a folder of theirs, or into their root folder if folderID is 0.
+func MoveDocument(ctx context.Context, dbc *sql.DB, documentID int64, folderID int64, userID int64) error {
+	doc, err := Get(ctx, dbc, documentID)
+	if err != nil {
+		return err
+	}
+	if doc.Owner != userID {
+		return fmt.Errorf("%w: only the owner can move a document", access_control.ErrAccessDenied)
+	}
+
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The document is locked before the folder, as everywhere else, so a concurrent rename can't deadlock with the move.
+	current, err := db.GetForUpdate(ctx, tx, documentID)
+	if err != nil {
+		return err
+	}
+
+	if folderID != 0 {
+		_, err = ownFolder(ctx, tx, folderID, userID, db.GetFolderForUpdate)
+		if err != nil {
+			return err
+		}
+	}
+	if current.Folder.Int64 == folderID {
+		return nil
+	}
+
+	err = checkDocumentNameFree(ctx, tx, userID, folderRef(folderID), current.FileName)
+	if err != nil {
+		return err
+	}
+
+	err = db.SetDocumentFolder(ctx, tx, documentID, folderRef(folderID))
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	return access_control.Log(ctx, dbc, userID, documentID, fmt.Sprintf("Move - Folder:%d", folderID))
+}
+
+// ResolvePath looks up a slash separated path in the user's folders. It returns the folder the path names, or the ID of
+// the document it names. Where a folder and documents share a name the folder is found, and where several documents in
+// the root folder share a name, which uploads before folders existed may, the newest is found.
+func ResolvePath(ctx context.Context, dbc *sql.DB, userID int64, path string) (models.Folder, int64, error) {
+	var names []string
+	for _, name := range strings.Split(path, "/") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	folder := db.Folder{Owner: userID}
+	for i, name := range names {
+		f, err := db.FindFolder(ctx, dbc, userID, folderRef(folder.ID), name)
+		if err == nil {
+			folder = f
+			continue
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return models.Folder{}, 0, err
+		}
+
+		if i == len(names)-1 {
+			docs, err := db.FindDocuments(ctx, dbc, userID, folderRef(folder.ID), name)
+			if err != nil {
+				return models.Folder{}, 0, err
+			}
+			if len(docs) > 0 {
+				return models.Folder{}, docs[0].ID, nil
+			}
+		}
+		return models.Folder{}, 0, fmt.Errorf("%w: %s", ErrPathNotFound, path)
+	}
+	return folderModel(folder), 0, nil
+}
+
+// ownFolder reads a folder, as if it does not exist unless the user owns it.
+func ownFolder(ctx context.Context, dbc db.DBTransaction, folderID int64, userID int64,
+	getter func(context.Context, db.DBTransaction, int64) (db.Folder, error)) (db.Folder, error) {
+	f, err := getter(ctx, dbc, folderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return db.Folder{}, fmt.Errorf("%w: %d", ErrFolderNotFound, folderID)
+	} else if err != nil {
+		return db.Folder{}, err
+	}
+	if f.Owner != userID {
+		return db.Folder{}, fmt.Errorf("%w: %d", ErrFolderNotFound, folderID)
+	}
+	return f, nil
+}
+
+// checkNameFree checks that no folder or document of the owner's in a folder has a name, so paths stay unambiguous. It
+// locks the folder's names until the transaction ends, so concurrent checks in the same folder happen one at a time.
+// Documents in the root folder don't take up names, see checkDocumentNameFree, so there only folders are checked.
+func checkNameFree(ctx context.Context, tx *sql.Tx, ownerID int64, folder sql.NullInt64, name string) error {
+	err := db.LockFolderNames(ctx, tx, ownerID, folder)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.FindFolder(ctx, tx, ownerID, folder, name)
+	if err == nil {
+		return fmt.Errorf("%w: %s", ErrNameTaken, name)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if !folder.Valid {
+		return nil
+	}
+
+	docs, err := db.FindDocuments(ctx, tx, ownerID, folder, name)
+	if err != nil {
+		return err
+	}
+	if len(docs) > 0 {
+		return fmt.Errorf("%w: %s", ErrNameTaken, name)
+	}
+	return nil
+}
+
+// checkDocumentNameFree checks that a document of the owner's can take a name in a folder. Documents in the root folder
+// are exempt, as uploads, which can't be put in a folder, have never needed unique names there; paths to a name that a
+// folder and documents share find the folder, and the newest of documents sharing it otherwise.
+func checkDocumentNameFree(ctx context.Context, tx *sql.Tx, ownerID int64, folder sql.NullInt64, name string) error {
+	if !folder.Valid {
+		return nil
+	}
+	return checkNameFree(ctx, tx, ownerID, folder, name)
+}
+
+func checkName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") || len(name) > 255 {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	return nil
+}
+
+// folderRef refers to a folder in the database, where the root folder is null.
+func folderRef(folderID int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: folderID, Valid: folderID != 0}
+}
+
+func folderModel(f db.Folder) models.Folder {
+	return models.Folder{
+		ID:      f.ID,
+		Owner:   f.Owner,
+		Parent:  f.Parent.Int64,
+		Name:    f.Name,
+		Created: f.Created,
+	}
+}
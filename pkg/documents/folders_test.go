@@ -0,0 +1,107 @@
+package documents
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
+	"github.com/EatonEmmerich/cloudStorage/pkg/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/users"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+)
+
+func TestFolders(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+
+	projects, err := CreateFolder(ctx, dbc, john, 0, "projects")
+	require.NoError(t, err)
+	year, err := CreateFolder(ctx, dbc, john, projects.ID, "2024")
+	require.NoError(t, err)
+	require.Equal(t, projects.ID, year.Parent)
+
+	_, err = CreateFolder(ctx, dbc, john, 0, "projects")
+	require.True(t, errors.Is(err, ErrNameTaken))
+	_, err = CreateFolder(ctx, dbc, john, 0, "a/b")
+	require.True(t, errors.Is(err, ErrInvalidName))
+	_, err = CreateFolder(ctx, dbc, jane, projects.ID, "mine")
+	require.True(t, errors.Is(err, ErrFolderNotFound))
+	// Names only have to be unique per user and folder.
+	_, err = CreateFolder(ctx, dbc, jane, 0, "projects")
+	require.NoError(t, err)
+	_, err = CreateFolder(ctx, dbc, john, year.ID, "projects")
+	require.NoError(t, err)
+
+	documentID, err := Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("report"))), "text/plain", "report.pdf")
+	require.NoError(t, err)
+	otherID, err := Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("other"))), "text/plain", "report.pdf")
+	require.NoError(t, err)
+
+	require.True(t, errors.Is(MoveDocument(ctx, dbc, int64(documentID), year.ID, jane), access_control.ErrAccessDenied))
+	require.NoError(t, MoveDocument(ctx, dbc, int64(documentID), year.ID, john))
+	require.True(t, errors.Is(MoveDocument(ctx, dbc, int64(otherID), year.ID, john), ErrNameTaken))
+
+	// Updates can't rename a document in a folder to a name that is taken.
+	_, err = CreateFolder(ctx, dbc, john, year.ID, "draft.pdf")
+	require.NoError(t, err)
+	_, err = Update(ctx, dbc, int64(documentID), john, io.NopCloser(bytes.NewReader([]byte("v2"))), "text/plain", "draft.pdf")
+	require.True(t, errors.Is(err, ErrNameTaken))
+
+	contents, err := ListFolder(ctx, dbc, year.ID, john)
+	require.NoError(t, err)
+	require.Equal(t, "2024", contents.Folder.Name)
+	require.Len(t, contents.Folders, 2)
+	require.Len(t, contents.Documents, 1)
+	require.Equal(t, int64(documentID), contents.Documents[0].ID)
+	require.Equal(t, year.ID, contents.Documents[0].Folder)
+
+	root, err := ListFolder(ctx, dbc, 0, john)
+	require.NoError(t, err)
+	require.Len(t, root.Folders, 1)
+	require.Len(t, root.Documents, 1)
+	require.Equal(t, int64(otherID), root.Documents[0].ID)
+
+	folder, id, err := ResolvePath(ctx, dbc, john, "/projects/2024/report.pdf")
+	require.NoError(t, err)
+	require.Equal(t, int64(documentID), id)
+	folder, id, err = ResolvePath(ctx, dbc, john, "projects/2024/")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), id)
+	require.Equal(t, year.ID, folder.ID)
+	folder, _, err = ResolvePath(ctx, dbc, john, "")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), folder.ID)
+	_, _, err = ResolvePath(ctx, dbc, john, "projects/2025/report.pdf")
+	require.True(t, errors.Is(err, ErrPathNotFound))
+	_, _, err = ResolvePath(ctx, dbc, jane, "projects/2024/report.pdf")
+	require.True(t, errors.Is(err, ErrPathNotFound))
+
+	require.NoError(t, RenameFolder(ctx, dbc, year.ID, john, "2025"))
+	_, id, err = ResolvePath(ctx, dbc, john, "projects/2025/report.pdf")
+	require.NoError(t, err)
+	require.Equal(t, int64(documentID), id)
+
+	require.True(t, errors.Is(DeleteFolder(ctx, dbc, year.ID, john), ErrFolderNotEmpty))
+	// Documents in the root folder may share names, as uploads there always could, and folders there may share names
+	// with them, which paths resolve to the folder.
+	require.NoError(t, MoveDocument(ctx, dbc, int64(documentID), 0, john))
+	_, err = CreateFolder(ctx, dbc, john, 0, "report.pdf")
+	require.NoError(t, err)
+	require.NoError(t, MoveDocument(ctx, dbc, int64(documentID), projects.ID, john))
+	for _, f := range contents.Folders {
+		require.NoError(t, DeleteFolder(ctx, dbc, f.ID, john))
+	}
+	require.True(t, errors.Is(DeleteFolder(ctx, dbc, year.ID, jane), ErrFolderNotFound))
+	require.NoError(t, DeleteFolder(ctx, dbc, year.ID, john))
+
+	doc, err := Get(ctx, dbc, int64(documentID))
+	require.NoError(t, err)
+	require.Equal(t, projects.ID, doc.Folder)
+}
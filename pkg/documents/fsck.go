@@ -0,0 +1,335 @@
+package documents
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/internal/db"
+	fsckModels "github.com/EatonEmmerich/cloudStorage/pkg/fsck/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/storage"
+	"io"
+	"strings"
+	"time"
+)
+
+// Blobs that fail a check are moved under quarantineKeyPrefix rather than deleted, so they can still be inspected.
+const quarantineKeyPrefix = "quarantine/"
+
+// Fsck reconciles the documents and blobs tables with the contents of the blob store. With verifyChecksums set every
+// blob is read back and hashed, which is slow for large stores. With repair set, issues that can be fixed without
+// losing data that is still referenced are fixed.
+//
+// Uploads in progress look like incomplete documents and orphaned blobs, so it should not be run while the server
+// is accepting uploads.
+func Fsck(ctx context.Context, dbc *sql.DB, repair bool, verifyChecksums bool) ([]fsckModels.Issue, error) {
+	var issues []fsckModels.Issue
+	report := func(issue fsckModels.Issue, fix func() error) error {
+		if repair && fix != nil {
+			err := fix()
+			if err != nil {
+				return err
+			}
+			issue.Repaired = true
+		}
+		issues = append(issues, issue)
+		return nil
+	}
+
+	incomplete, err := db.ListIncompleteDocuments(ctx, dbc)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range incomplete {
+		id := id
+		err = report(fsckModels.Issue{
+			Kind:     fsckModels.IncompleteUpload,
+			Document: id,
+			Detail:   "document has no stored version",
+			Repair:   "delete document",
+		}, func() error {
+			return db.DeleteIncompleteDocument(ctx, dbc, id)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	objects := make(map[string]storage.BlobInfo)
+	list, err := store.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range list {
+		if !strings.HasPrefix(info.Key, quarantineKeyPrefix) {
+			objects[info.Key] = info
+		}
+	}
+
+	documents, err := db.ListStoredDocuments(ctx, dbc)
+	if err != nil {
+		return nil, err
+	}
+	recorded, err := db.ListAllVersions(ctx, dbc)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := db.ListChunkReferences(ctx, dbc)
+	if err != nil {
+		return nil, err
+	}
+	blobList, err := db.ListBlobs(ctx, dbc)
+	if err != nil {
+		return nil, err
+	}
+	blobs := make(map[string]db.Blob)
+	for _, blob := range blobList {
+		blobs[blob.Digest] = blob
+	}
+
+	// Every version in a document's history holds references to its contents. The current version of a document
+	// stored before versions were recorded is only referenced by the document itself.
+	versions := recorded
+	type versionID struct{ document, version int64 }
+	isRecorded := make(map[versionID]bool)
+	for _, v := range recorded {
+		isRecorded[versionID{v.Document, v.Version}] = true
+	}
+	for _, doc := range documents {
+		if !isRecorded[versionID{doc.ID, doc.Version}] {
+			versions = append(versions, versionOf(doc, sql.NullInt64{}, time.Time{}))
+		}
+	}
+
+	// Count the references to each blob, and check that every version's contents can be found.
+	refCounts := make(map[string]int64)
+	referenced := make(map[string]bool)
+	chunkCounts := make(map[string]int)
+	for _, chunk := range chunks {
+		chunkCounts[manifestKey(chunk.Document, chunk.Version)]++
+		refCounts[chunk.Digest]++
+		_, ok := blobs[chunk.Digest]
+		if !ok {
+			issues = append(issues, fsckModels.Issue{
+				Kind:     fsckModels.MissingBlob,
+				Document: chunk.Document,
+				Key:      blobKey(chunk.Digest),
+				Detail:   fmt.Sprintf("chunk of version %d has no blob record", chunk.Version),
+			})
+		}
+	}
+
+	for _, v := range versions {
+		switch {
+		case strings.HasPrefix(v.Path, manifestKeyPrefix):
+			if chunkCounts[v.Path] == 0 && v.Size > 0 {
+				issues = append(issues, fsckModels.Issue{
+					Kind:     fsckModels.MissingBlob,
+					Document: v.Document,
+					Key:      v.Path,
+					Detail:   fmt.Sprintf("chunked version %d has no chunks", v.Version),
+				})
+			}
+		case strings.HasPrefix(v.Path, blobKeyPrefix):
+			digest := strings.TrimPrefix(v.Path, blobKeyPrefix)
+			refCounts[digest]++
+			_, ok := blobs[digest]
+			if !ok {
+				issues = append(issues, fsckModels.Issue{
+					Kind:     fsckModels.MissingBlob,
+					Document: v.Document,
+					Key:      v.Path,
+					Detail:   fmt.Sprintf("contents of version %d have no blob record", v.Version),
+				})
+			}
+		default:
+			// Contents stored before deduplication are kept as is, under a key of their own.
+			if referenced[v.Path] {
+				continue
+			}
+			referenced[v.Path] = true
+			err = checkLegacyContents(ctx, v, objects, verifyChecksums, report)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, blob := range blobList {
+		key := blobKey(blob.Digest)
+		referenced[key] = true
+		err = checkBlob(ctx, dbc, blob, refCounts[blob.Digest], objects, verifyChecksums, report)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, info := range list {
+		if referenced[info.Key] || strings.HasPrefix(info.Key, quarantineKeyPrefix) {
+			continue
+		}
+		key := info.Key
+		err = report(fsckModels.Issue{
+			Kind:   fsckModels.OrphanBlob,
+			Key:    key,
+			Detail: fmt.Sprintf("%d bytes not referenced by any document", info.Size),
+			Repair: "quarantine blob",
+		}, func() error {
+			return quarantine(ctx, key)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return issues, nil
+}
+
+type reportFunc func(issue fsckModels.Issue, fix func() error) error
+
+func checkLegacyContents(ctx context.Context, doc db.Version, objects map[string]storage.BlobInfo, verifyChecksums bool, report reportFunc) error {
+	info, ok := objects[doc.Path]
+	if !ok {
+		return report(fsckModels.Issue{
+			Kind:     fsckModels.MissingBlob,
+			Document: doc.Document,
+			Key:      doc.Path,
+			Detail:   "document contents are not in the store",
+		}, nil)
+	}
+
+	if info.Size != doc.Size {
+		return report(fsckModels.Issue{
+			Kind:     fsckModels.SizeMismatch,
+			Document: doc.Document,
+			Key:      doc.Path,
+			Detail:   fmt.Sprintf("expected %d bytes, store has %d", doc.Size, info.Size),
+		}, nil)
+	}
+
+	if !verifyChecksums || doc.SHA256 == "" {
+		return nil
+	}
+	digest, err := hashContents(store.Get(ctx, doc.Path))
+	if err != nil || digest != doc.SHA256 {
+		return report(fsckModels.Issue{
+			Kind:     fsckModels.ChecksumMismatch,
+			Document: doc.Document,
+			Key:      doc.Path,
+			Detail:   checksumDetail(doc.SHA256, digest, err),
+		}, nil)
+	}
+	return nil
+}
+
+// checkBlob checks a blob's record against its contents in the store and the number of document versions referencing
+// it. Blobs with bad contents are quarantined, leaving their record so the documents referencing them are reported as
+// missing contents from then on.
+func checkBlob(ctx context.Context, dbc *sql.DB, blob db.Blob, refCount int64, objects map[string]storage.BlobInfo, verifyChecksums bool, report reportFunc) error {
+	key := blobKey(blob.Digest)
+	if blob.RefCount != refCount {
+		issue := fsckModels.Issue{
+			Kind:   fsckModels.RefCountMismatch,
+			Key:    key,
+			Detail: fmt.Sprintf("recorded %d references, found %d", blob.RefCount, refCount),
+			Repair: "set reference count",
+		}
+		fix := func() error {
+			return db.SetRefCount(ctx, dbc, blob.Digest, refCount)
+		}
+		if refCount == 0 {
+			issue.Repair = "delete blob record and quarantine blob"
+			fix = func() error {
+				err := db.DeleteBlob(ctx, dbc, blob.Digest)
+				if err != nil {
+					return err
+				}
+				_, ok := objects[key]
+				if !ok {
+					return nil
+				}
+				return quarantine(ctx, key)
+			}
+		}
+
+		err := report(issue, fix)
+		if err != nil || refCount == 0 {
+			return err
+		}
+	}
+
+	info, ok := objects[key]
+	if !ok {
+		return report(fsckModels.Issue{
+			Kind:   fsckModels.MissingBlob,
+			Key:    key,
+			Detail: "blob is not in the store",
+		}, nil)
+	}
+
+	if info.Size != blob.StoredSize {
+		return report(fsckModels.Issue{
+			Kind:   fsckModels.SizeMismatch,
+			Key:    key,
+			Detail: fmt.Sprintf("expected %d bytes, store has %d", blob.StoredSize, info.Size),
+			Repair: "quarantine blob",
+		}, func() error {
+			return quarantine(ctx, key)
+		})
+	}
+
+	if !verifyChecksums {
+		return nil
+	}
+	digest, err := hashContents(openBlob(ctx, blob))
+	if err != nil || digest != blob.Digest {
+		return report(fsckModels.Issue{
+			Kind:   fsckModels.ChecksumMismatch,
+			Key:    key,
+			Detail: checksumDetail(blob.Digest, digest, err),
+			Repair: "quarantine blob",
+		}, func() error {
+			return quarantine(ctx, key)
+		})
+	}
+	return nil
+}
+
+func hashContents(reader io.ReadCloser, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, reader)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func checksumDetail(expected string, actual string, err error) string {
+	if err != nil {
+		return "contents can't be read: " + err.Error()
+	}
+	return fmt.Sprintf("expected SHA-256 %s, contents hash to %s", expected, actual)
+}
+
+// quarantine moves a blob under quarantineKeyPrefix.
+func quarantine(ctx context.Context, key string) error {
+	reader, err := store.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	_, err = store.Put(ctx, quarantineKeyPrefix+key, reader)
+	if err != nil {
+		return err
+	}
+	return store.Delete(ctx, key)
+}
@@ -0,0 +1,94 @@
+package documents
+
+import (
+	"bytes"
+	"context"
+	"github.com/EatonEmmerich/cloudStorage/pkg/db"
+	fsckModels "github.com/EatonEmmerich/cloudStorage/pkg/fsck/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/storage"
+	"github.com/EatonEmmerich/cloudStorage/pkg/users"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+)
+
+func kinds(issues []fsckModels.Issue) []fsckModels.Kind {
+	var k []fsckModels.Kind
+	for _, issue := range issues {
+		k = append(k, issue.Kind)
+	}
+	return k
+}
+
+func TestFsck(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	memory := storage.NewMemory()
+	SetBlobStore(memory)
+	t.Cleanup(func() { SetBlobStore(storage.NewFileSystem(workPath)) })
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	good, err := Upload(ctx, dbc, userID, io.NopCloser(bytes.NewReader([]byte("good"))), "text/plain", "good.txt")
+	require.NoError(t, err)
+	bad, err := Upload(ctx, dbc, userID, io.NopCloser(bytes.NewReader([]byte("bad"))), "text/plain", "bad.txt")
+	require.NoError(t, err)
+
+	issues, err := Fsck(ctx, dbc, false, true)
+	require.NoError(t, err)
+	require.Empty(t, issues)
+
+	incomplete, err := new(ctx, dbc, userID)
+	require.NoError(t, err)
+	_, err = memory.Put(ctx, "sha256/orphan", bytes.NewReader([]byte("orphan")))
+	require.NoError(t, err)
+	badDoc, err := Get(ctx, dbc, int64(bad))
+	require.NoError(t, err)
+	_, err = memory.Put(ctx, badDoc.Path, bytes.NewReader([]byte("BAD")))
+	require.NoError(t, err)
+	goodDoc, err := Get(ctx, dbc, int64(good))
+	require.NoError(t, err)
+	_, err = dbc.ExecContext(ctx, "update `blobs` set `ref_count` = 3 where `digest` = ?", goodDoc.SHA256)
+	require.NoError(t, err)
+
+	// A dry run reports the issues without changing anything.
+	for i := 0; i < 2; i++ {
+		issues, err = Fsck(ctx, dbc, false, true)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []fsckModels.Kind{fsckModels.IncompleteUpload, fsckModels.RefCountMismatch,
+			fsckModels.ChecksumMismatch, fsckModels.OrphanBlob}, kinds(issues))
+		for _, issue := range issues {
+			require.False(t, issue.Repaired)
+		}
+	}
+	require.Equal(t, incomplete, issues[0].Document)
+
+	issues, err = Fsck(ctx, dbc, true, true)
+	require.NoError(t, err)
+	require.Len(t, issues, 4)
+	for _, issue := range issues {
+		require.True(t, issue.Repaired)
+	}
+
+	_, err = Get(ctx, dbc, incomplete)
+	require.Error(t, err)
+	quarantined, err := memory.List(ctx, quarantineKeyPrefix)
+	require.NoError(t, err)
+	require.Len(t, quarantined, 2)
+
+	// The corrupted contents are gone, which can't be repaired.
+	issues, err = Fsck(ctx, dbc, true, true)
+	require.NoError(t, err)
+	require.Equal(t, []fsckModels.Kind{fsckModels.MissingBlob}, kinds(issues))
+	require.Equal(t, badDoc.Path, issues[0].Key)
+	require.False(t, issues[0].Repaired)
+
+	_, reader, err := OpenDocument(ctx, dbc, int64(good), userID)
+	require.NoError(t, err)
+	contents, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, "good", string(contents))
+}
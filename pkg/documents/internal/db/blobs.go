@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// AcquireBlob adds a reference to the blob with the given digest, creating it if needed. It reports whether the blob
+// is new, in which case its contents still need to be stored.
+func AcquireBlob(ctx context.Context, dbc DBTransaction, digest string, size int64, compression string) (bool, error) {
+	res, err := dbc.ExecContext(ctx, "insert into `blobs` (`digest`, `size`, `compression`, `ref_count`) values (?, ?, ?, 1) on duplicate key update `ref_count` = `ref_count` + 1",
+		digest, size, compression)
+	if err != nil {
+		return false, err
+	}
+
+	numberOfRows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	// MySQL reports 1 affected row for an insert and 2 for an update of an existing row.
+	return numberOfRows == 1, nil
+}
+
+// AddBlobReference adds a reference to a blob that is already stored.
+func AddBlobReference(ctx context.Context, dbc DBTransaction, digest string) error {
+	res, err := dbc.ExecContext(ctx, "update `blobs` set `ref_count` = `ref_count` + 1 where `digest` = ?", digest)
+	if err != nil {
+		return err
+	}
+
+	numberOfRows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numberOfRows != 1 {
+		return fmt.Errorf("blob %s does not exist", digest)
+	}
+	return nil
+}
+
+func GetBlob(ctx context.Context, dbc DBTransaction, digest string) (Blob, error) {
+	row := dbc.QueryRowContext(ctx, "select `digest`, `size`, `stored_size`, `compression`, `wrapped_key`, `key_id` from `blobs` where `digest` = ?", digest)
+	err := row.Err()
+	if err != nil {
+		return Blob{}, err
+	}
+
+	var blob Blob
+	err = row.Scan(&blob.Digest, &blob.Size, &blob.StoredSize, &blob.Compression, &blob.WrappedKey, &blob.KeyID)
+	if err != nil {
+		return Blob{}, err
+	}
+	return blob, nil
+}
+
+// SetBlobStored records how a new blob's contents were written to the store.
+func SetBlobStored(ctx context.Context, dbc DBTransaction, digest string, storedSize int64, wrappedKey []byte, keyID string) error {
+	_, err := dbc.ExecContext(ctx, "update `blobs` set `stored_size` = ?, `wrapped_key` = ?, `key_id` = ? where `digest` = ?",
+		storedSize, wrappedKey, keyID, digest)
+	return err
+}
+
+// ListWrappedKeys returns encrypted blobs whose data key is not wrapped under the master key with ID keyID.
+func ListWrappedKeys(ctx context.Context, dbc DBTransaction, keyID string) ([]Blob, error) {
+	rows, err := dbc.QueryContext(ctx, "select `digest`, `wrapped_key`, `key_id` from `blobs` where `key_id` != '' and `key_id` != ?", keyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blobs []Blob
+	for rows.Next() {
+		var blob Blob
+		err = rows.Scan(&blob.Digest, &blob.WrappedKey, &blob.KeyID)
+		if err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, blob)
+	}
+	return blobs, rows.Err()
+}
+
+// SetWrappedKey replaces a blob's wrapped data key, unless it has been changed since it was read.
+func SetWrappedKey(ctx context.Context, dbc DBTransaction, digest string, oldKeyID string, wrappedKey []byte, keyID string) error {
+	_, err := dbc.ExecContext(ctx, "update `blobs` set `wrapped_key` = ?, `key_id` = ? where `digest` = ? and `key_id` = ?",
+		wrappedKey, keyID, digest, oldKeyID)
+	return err
+}
+
+// LockBlob locks the row of the blob with the given digest until the transaction ends, or the gap it would be inserted
+// into if it does not exist, and returns its number of references, which is 0 if it does not exist.
+func LockBlob(ctx context.Context, dbc DBTransaction, digest string) (int64, error) {
+	row := dbc.QueryRowContext(ctx, "select `ref_count` from `blobs` where `digest` = ? for update", digest)
+	var refCount int64
+	err := row.Scan(&refCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return refCount, err
+}
+
+// ReleaseBlob removes a reference to the blob with the given digest. It reports whether that was the last reference, in
+// which case the blob's row has been deleted and its contents should be too.
+func ReleaseBlob(ctx context.Context, dbc DBTransaction, digest string) (bool, error) {
+	res, err := dbc.ExecContext(ctx, "update `blobs` set `ref_count` = `ref_count` - 1 where `digest` = ? and `ref_count` > 0", digest)
+	if err != nil {
+		return false, err
+	}
+
+	numberOfRows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if numberOfRows != 1 {
+		return false, fmt.Errorf("no references to release for blob %s", digest)
+	}
+
+	res, err = dbc.ExecContext(ctx, "delete from `blobs` where `digest` = ? and `ref_count` = 0", digest)
+	if err != nil {
+		return false, err
+	}
+
+	numberOfRows, err = res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return numberOfRows == 1, nil
+}
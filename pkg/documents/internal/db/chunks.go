@@ -0,0 +1,50 @@
+package db
+
+import (
+	"context"
+	"errors"
+)
+
+func InsertChunk(ctx context.Context, dbc DBTransaction, documentID int64, version int64, chunk Chunk) error {
+	res, err := dbc.ExecContext(ctx, "insert into `document_chunks` (`document`, `version`, `seq`, `digest`, `size`) values (?, ?, ?, ?, ?)",
+		documentID, version, chunk.Seq, chunk.Blob.Digest, chunk.Size)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return errors.New("unexpected number of rows updated")
+	}
+	return nil
+}
+
+// ListChunks returns the manifest of a chunked document version, in order.
+func ListChunks(ctx context.Context, dbc DBTransaction, documentID int64, version int64) ([]Chunk, error) {
+	rows, err := dbc.QueryContext(ctx, "select `c`.`seq`, `c`.`size`, `b`.`digest`, `b`.`size`, `b`.`stored_size`, `b`.`compression`, `b`.`wrapped_key`, `b`.`key_id` from `document_chunks` `c` "+
+		"join `blobs` `b` on `b`.`digest` = `c`.`digest` where `c`.`document` = ? and `c`.`version` = ? order by `c`.`seq`",
+		documentID, version)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []Chunk
+	for rows.Next() {
+		var chunk Chunk
+		err = rows.Scan(&chunk.Seq, &chunk.Size, &chunk.Blob.Digest, &chunk.Blob.Size, &chunk.Blob.StoredSize, &chunk.Blob.Compression, &chunk.Blob.WrappedKey, &chunk.Blob.KeyID)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, rows.Err()
+}
+
+func DeleteChunks(ctx context.Context, dbc DBTransaction, documentID int64, version int64) error {
+	_, err := dbc.ExecContext(ctx, "delete from `document_chunks` where `document` = ? and `version` = ?", documentID, version)
+	return err
+}
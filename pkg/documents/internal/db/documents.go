@@ -4,50 +4,85 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"time"
 )
 
 type DBTransaction interface {
 	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 	ExecContext(ctx context.Context, querty string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+const documentColumns = "d.`id`, d.`owner`, d.`path`, d.`version`, d.`size`, d.`stored_size`, d.`sha256`, d.`md5`, d.`media_type`, " +
+	"d.`detected_media_type`, d.`file_name`, d.`folder`, d.`modified_at`, d.`deleted_at`"
+
+func scanDocument(row scanner) (Document, error) {
+	var doc Document
+	err := row.Scan(&doc.ID, &doc.Owner, &doc.Path, &doc.Version, &doc.Size, &doc.StoredSize, &doc.SHA256, &doc.MD5, &doc.MediaType, &doc.DetectedMediaType, &doc.FileName, &doc.Folder, &doc.Modified, &doc.Deleted)
+	return doc, err
 }
 
 func Get(ctx context.Context, dbc DBTransaction, documentID int64) (Document, error) {
-	row := dbc.QueryRowContext(ctx, "select id, owner, path, version, size, media_type, file_name  from documents where `id` = ?", documentID)
+	row := dbc.QueryRowContext(ctx, "select "+documentColumns+" from `documents` d where `id` = ?", documentID)
 	err := row.Err()
 	if err != nil {
 		return Document{}, err
 	}
 
-	var doc Document
-	err = row.Scan(&doc.ID, &doc.Owner, &doc.Path, &doc.Version, &doc.Size, &doc.MediaType, &doc.FileName)
+	return scanDocument(row)
+}
+
+// GetForUpdate reads a document and locks its row until the transaction ends.
+func GetForUpdate(ctx context.Context, dbc DBTransaction, documentID int64) (Document, error) {
+	row := dbc.QueryRowContext(ctx, "select "+documentColumns+" from `documents` d where `id` = ? for update", documentID)
+	err := row.Err()
 	if err != nil {
 		return Document{}, err
 	}
 
-	return doc, err
+	return scanDocument(row)
+}
+
+// ListDocuments returns a page of the user's documents that match a filter, and the first document of the next page if
+// there is one.
+func ListDocuments(ctx context.Context, dbc *sql.DB, userID int64, filter models.Filter, page models.Page) ([]Document, error) {
+	clause, args := listingClause(filter, page)
+	return listDocuments(ctx, dbc, "select "+documentColumns+" from `documents` d where d.`owner` = ? and d.`deleted_at` is null"+clause,
+		append([]interface{}{userID}, args...)...)
+}
+
+// ListSharedDocuments returns a page of the documents shared with the user that match a filter, and the first document
+// of the next page if there is one.
+func ListSharedDocuments(ctx context.Context, dbc *sql.DB, userID int64, filter models.Filter, page models.Page) ([]Document, error) {
+	clause, args := listingClause(filter, page)
+	return listDocuments(ctx, dbc, "select "+documentColumns+" from `documents` d join `permissions` p on p.`document` = d.`id` "+
+		"where p.`user` = ? and d.`deleted_at` is null"+clause, append([]interface{}{userID}, args...)...)
 }
 
-func ListDocuments(ctx context.Context, dbc *sql.DB, userID int64) ([]Document, error) {
-	rows, err := dbc.QueryContext(ctx, "select id, owner, path, version, size, media_type, file_name  from documents where `owner` = ?", userID)
+// InsertDocument creates a document without contents in a folder, or in the owner's root folder if folder is null.
+func InsertDocument(ctx context.Context, dbc DBTransaction, ownerID int64, folder sql.NullInt64, created time.Time) (int64, error) {
+	res, err := dbc.ExecContext(ctx, "insert into `documents` (`owner`, `folder`, `modified_at`) values (?, ?, ?)", ownerID, folder, created)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
+	return res.LastInsertId()
+}
 
-	var documents []Document
-	for rows.Next() {
-		var doc Document
-		err = rows.Scan(&doc.ID, &doc.Owner, &doc.Path, &doc.Version, &doc.Size, &doc.MediaType, &doc.FileName)
-		if err != nil {
-			return nil, err
-		}
-		documents = append(documents, doc)
+// RenameDocument changes the file name of a document and of its current version.
+func RenameDocument(ctx context.Context, dbc DBTransaction, documentID int64, version int64, fileName string) error {
+	_, err := dbc.ExecContext(ctx, "update `documents` set `file_name` = ? where `id` = ?", fileName, documentID)
+	if err != nil {
+		return err
 	}
-	return documents, nil
+	_, err = dbc.ExecContext(ctx, "update `document_versions` set `file_name` = ? where `document` = ? and `version` = ?",
+		fileName, documentID, version)
+	return err
 }
 
-func UpdateDocument(ctx context.Context, dbc DBTransaction, newPath string, size int64, documentID int64, version int64, mediaType string, filename string) error {
-	res, err := dbc.ExecContext(ctx, "update `documents` set `path`=?, `version`=?, `size`=?, `media_type`=?, `file_name`=? where `id`=?",
-		newPath, version, size, mediaType, filename, documentID)
+func UpdateDocument(ctx context.Context, dbc DBTransaction, doc Document) error {
+	res, err := dbc.ExecContext(ctx, "update `documents` set `path`=?, `version`=?, `size`=?, `stored_size`=?, `sha256`=?, `md5`=?, `media_type`=?, `detected_media_type`=?, `file_name`=?, `modified_at`=? where `id`=?",
+		doc.Path, doc.Version, doc.Size, doc.StoredSize, doc.SHA256, doc.MD5, doc.MediaType, doc.DetectedMediaType, doc.FileName, doc.Modified, doc.ID)
 	if err != nil {
 		return err
 	}
@@ -61,3 +96,22 @@ func UpdateDocument(ctx context.Context, dbc DBTransaction, newPath string, size
 	}
 	return nil
 }
+
+// TrimPathPrefix removes a prefix from the paths of the documents and versions whose contents are read from a path
+// starting with it, returning the number of rows changed.
+func TrimPathPrefix(ctx context.Context, dbc DBTransaction, prefix string) (int64, error) {
+	var n int64
+	for _, table := range []string{"documents", "document_versions"} {
+		res, err := dbc.ExecContext(ctx, "update `"+table+"` set `path` = substring(`path`, ?) where left(`path`, ?) = ?",
+			len(prefix)+1, len(prefix), prefix)
+		if err != nil {
+			return 0, err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		n += rows
+	}
+	return n, nil
+}
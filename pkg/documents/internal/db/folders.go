@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const folderColumns = "`id`, `owner`, `parent`, `name`, `created_at`"
+
+func scanFolder(row scanner) (Folder, error) {
+	var f Folder
+	err := row.Scan(&f.ID, &f.Owner, &f.Parent, &f.Name, &f.Created)
+	return f, err
+}
+
+func InsertFolder(ctx context.Context, dbc DBTransaction, f Folder) (int64, error) {
+	res, err := dbc.ExecContext(ctx, "insert into `folders` (`owner`, `parent`, `name`, `created_at`) values (?, ?, ?, ?)",
+		f.Owner, f.Parent, f.Name, f.Created)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func GetFolder(ctx context.Context, dbc DBTransaction, folderID int64) (Folder, error) {
+	row := dbc.QueryRowContext(ctx, "select "+folderColumns+" from `folders` where `id` = ?", folderID)
+	err := row.Err()
+	if err != nil {
+		return Folder{}, err
+	}
+	return scanFolder(row)
+}
+
+// GetFolderForUpdate reads a folder and locks its row until the transaction ends.
+func GetFolderForUpdate(ctx context.Context, dbc DBTransaction, folderID int64) (Folder, error) {
+	row := dbc.QueryRowContext(ctx, "select "+folderColumns+" from `folders` where `id` = ? for update", folderID)
+	err := row.Err()
+	if err != nil {
+		return Folder{}, err
+	}
+	return scanFolder(row)
+}
+
+// LockFolderNames locks the row that checks of names in the owner's folder are serialised on until the transaction
+// ends: the folder's, or the owner's for their root folder, which unique keys don't cover as its parent is null.
+func LockFolderNames(ctx context.Context, dbc DBTransaction, ownerID int64, folder sql.NullInt64) error {
+	query, id := "select `id` from `users` where `id` = ? for update", ownerID
+	if folder.Valid {
+		query, id = "select `id` from `folders` where `id` = ? for update", folder.Int64
+	}
+	var locked int64
+	return dbc.QueryRowContext(ctx, query, id).Scan(&locked)
+}
+
+// LockFolderDocuments locks the rows of the documents in a folder, including those in the trash, until the transaction
+// ends.
+func LockFolderDocuments(ctx context.Context, dbc DBTransaction, folderID int64) error {
+	rows, err := dbc.QueryContext(ctx, "select `id` from `documents` where `folder` = ? for update", folderID)
+	if err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// FindFolder returns the owner's folder with a name in a parent folder, or in the owner's root folder if parent is null.
+func FindFolder(ctx context.Context, dbc DBTransaction, ownerID int64, parent sql.NullInt64, name string) (Folder, error) {
+	row := dbc.QueryRowContext(ctx, "select "+folderColumns+" from `folders` where `owner` = ? and `parent` <=> ? and `name` = ?",
+		ownerID, parent, name)
+	err := row.Err()
+	if err != nil {
+		return Folder{}, err
+	}
+	return scanFolder(row)
+}
+
+// ListFolders returns the owner's folders in a parent folder, or in the owner's root folder if parent is null, by name.
+func ListFolders(ctx context.Context, dbc DBTransaction, ownerID int64, parent sql.NullInt64) ([]Folder, error) {
+	rows, err := dbc.QueryContext(ctx, "select "+folderColumns+" from `folders` where `owner` = ? and `parent` <=> ? order by `name`",
+		ownerID, parent)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var folders []Folder
+	for rows.Next() {
+		f, err := scanFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, f)
+	}
+	return folders, rows.Err()
+}
+
+func RenameFolder(ctx context.Context, dbc DBTransaction, folderID int64, name string) error {
+	_, err := dbc.ExecContext(ctx, "update `folders` set `name` = ? where `id` = ?", name, folderID)
+	return err
+}
+
+func DeleteFolder(ctx context.Context, dbc DBTransaction, folderID int64) error {
+	res, err := dbc.ExecContext(ctx, "delete from `folders` where `id` = ?", folderID)
+	if err != nil {
+		return err
+	}
+
+	numberOfRows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numberOfRows != 1 {
+		return fmt.Errorf("incorrect number of rows deleted, expected: 1, got:%d", numberOfRows)
+	}
+	return nil
+}
+
+// FindDocuments returns the owner's documents with a file name in a folder, or in the owner's root folder if folder is
+// null, newest first.
+func FindDocuments(ctx context.Context, dbc DBTransaction, ownerID int64, folder sql.NullInt64, fileName string) ([]Document, error) {
+	return listDocuments(ctx, dbc, "select "+documentColumns+" from `documents` d where d.`owner` = ? and d.`folder` <=> ? and d.`file_name` = ? "+
+		"and d.`version` > 0 and d.`deleted_at` is null order by d.`id` desc", ownerID, folder, fileName)
+}
+
+// ListFolderDocuments returns the owner's documents in a folder, or in the owner's root folder if folder is null, by
+// file name.
+func ListFolderDocuments(ctx context.Context, dbc DBTransaction, ownerID int64, folder sql.NullInt64) ([]Document, error) {
+	return listDocuments(ctx, dbc, "select "+documentColumns+" from `documents` d where d.`owner` = ? and d.`folder` <=> ? "+
+		"and d.`version` > 0 and d.`deleted_at` is null order by d.`file_name`, d.`id`", ownerID, folder)
+}
+
+func listDocuments(ctx context.Context, dbc DBTransaction, query string, args ...interface{}) ([]Document, error) {
+	rows, err := dbc.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var documents []Document
+	for rows.Next() {
+		doc, err := scanDocument(rows)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, doc)
+	}
+	return documents, rows.Err()
+}
+
+// SetDocumentFolder moves a document into a folder, or into its owner's root folder if folder is null.
+func SetDocumentFolder(ctx context.Context, dbc DBTransaction, documentID int64, folder sql.NullInt64) error {
+	_, err := dbc.ExecContext(ctx, "update `documents` set `folder` = ? where `id` = ?", folder, documentID)
+	return err
+}
+
+// FolderIsEmpty reports whether a folder holds no folders or documents, including incomplete uploads but not documents
+// in the trash.
+func FolderIsEmpty(ctx context.Context, dbc DBTransaction, folderID int64) (bool, error) {
+	row := dbc.QueryRowContext(ctx, "select (select count(*) from `folders` where `parent` = ?) + "+
+		"(select count(*) from `documents` where `folder` = ? and `deleted_at` is null)",
+		folderID, folderID)
+	var n int64
+	err := row.Scan(&n)
+	return n == 0, err
+}
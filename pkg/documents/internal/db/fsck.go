@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// ListIncompleteDocuments returns the IDs of documents that were created but never had contents stored.
+func ListIncompleteDocuments(ctx context.Context, dbc DBTransaction) ([]int64, error) {
+	rows, err := dbc.QueryContext(ctx, "select `id` from `documents` where `version` = 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func ListStoredDocuments(ctx context.Context, dbc DBTransaction) ([]Document, error) {
+	rows, err := dbc.QueryContext(ctx, "select "+documentColumns+" from `documents` d where `version` > 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var documents []Document
+	for rows.Next() {
+		doc, err := scanDocument(rows)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, doc)
+	}
+	return documents, rows.Err()
+}
+
+func ListBlobs(ctx context.Context, dbc DBTransaction) ([]Blob, error) {
+	rows, err := dbc.QueryContext(ctx, "select `digest`, `size`, `stored_size`, `compression`, `wrapped_key`, `key_id`, `ref_count` from `blobs`")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blobs []Blob
+	for rows.Next() {
+		var blob Blob
+		err = rows.Scan(&blob.Digest, &blob.Size, &blob.StoredSize, &blob.Compression, &blob.WrappedKey, &blob.KeyID, &blob.RefCount)
+		if err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, blob)
+	}
+	return blobs, rows.Err()
+}
+
+func ListChunkReferences(ctx context.Context, dbc DBTransaction) ([]ChunkReference, error) {
+	rows, err := dbc.QueryContext(ctx, "select `document`, `version`, `digest` from `document_chunks` order by `document`, `version`, `seq`")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []ChunkReference
+	for rows.Next() {
+		var chunk ChunkReference
+		err = rows.Scan(&chunk.Document, &chunk.Version, &chunk.Digest)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, rows.Err()
+}
+
+// DeleteIncompleteDocument deletes a document that never had contents stored, along with any permissions on it.
+func DeleteIncompleteDocument(ctx context.Context, dbc *sql.DB, documentID int64) error {
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "delete from `permissions` where `document` = ?", documentID)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, "delete from `documents` where `id` = ? and `version` = 0", documentID)
+	if err != nil {
+		return err
+	}
+
+	numberOfRows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numberOfRows != 1 {
+		return fmt.Errorf("document %d is no longer incomplete", documentID)
+	}
+	return tx.Commit()
+}
+
+func SetRefCount(ctx context.Context, dbc DBTransaction, digest string, refCount int64) error {
+	_, err := dbc.ExecContext(ctx, "update `blobs` set `ref_count` = ? where `digest` = ?", refCount, digest)
+	return err
+}
+
+func DeleteBlob(ctx context.Context, dbc DBTransaction, digest string) error {
+	_, err := dbc.ExecContext(ctx, "delete from `blobs` where `digest` = ?", digest)
+	return err
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"strings"
+)
+
+// sortColumns are the columns of documents d that listings are sorted by.
+var sortColumns = map[models.Sort]string{
+	models.SortName:     "d.`file_name`",
+	models.SortSize:     "d.`size`",
+	models.SortVersion:  "d.`version`",
+	models.SortModified: "d.`modified_at`",
+}
+
+// listingClause narrows a query over documents d to a page of those that match a filter, in the page's order. It
+// follows the query's where clause, and selects one more document than the page holds, which is on the next page if
+// there is one.
+func listingClause(filter models.Filter, page models.Page) (string, []interface{}) {
+	var clause strings.Builder
+	var args []interface{}
+
+	for _, tag := range filter.Tags {
+		clause.WriteString(" and exists (select 1 from `document_tags` t where t.`document` = d.`id` and t.`tag` = ?)")
+		args = append(args, tag)
+	}
+	for key, value := range filter.Metadata {
+		clause.WriteString(" and exists (select 1 from `document_metadata` m where m.`document` = d.`id` and m.`key` = ? and m.`value` = ?)")
+		args = append(args, key, value)
+	}
+	if filter.MediaType != "" {
+		if strings.HasSuffix(filter.MediaType, "/*") {
+			clause.WriteString(" and d.`media_type` like ? escape '!'")
+			args = append(args, escapeLike(strings.TrimSuffix(filter.MediaType, "*"))+"%")
+		} else {
+			// Media types may have parameters, such as a charset.
+			clause.WriteString(" and (d.`media_type` = ? or d.`media_type` like ? escape '!')")
+			args = append(args, filter.MediaType, escapeLike(filter.MediaType)+";%")
+		}
+	}
+	if filter.MinSize != nil {
+		clause.WriteString(" and d.`size` >= ?")
+		args = append(args, *filter.MinSize)
+	}
+	if filter.MaxSize != nil {
+		clause.WriteString(" and d.`size` <= ?")
+		args = append(args, *filter.MaxSize)
+	}
+	if filter.NamePrefix != "" {
+		clause.WriteString(" and d.`file_name` like ? escape '!'")
+		args = append(args, escapeLike(filter.NamePrefix)+"%")
+	}
+
+	direction, after := "asc", ">"
+	if page.Descending {
+		direction, after = "desc", "<"
+	}
+	column, sorted := sortColumns[page.Sort]
+	if page.After != nil {
+		if sorted {
+			clause.WriteString(" and (" + column + " " + after + " ? or (" + column + " = ? and d.`id` " + after + " ?))")
+			value := sortValue(*page.After, page.Sort)
+			args = append(args, value, value, page.After.ID)
+		} else {
+			clause.WriteString(" and d.`id` " + after + " ?")
+			args = append(args, page.After.ID)
+		}
+	}
+
+	clause.WriteString(" order by ")
+	if sorted {
+		clause.WriteString(column + " " + direction + ", ")
+	}
+	clause.WriteString("d.`id` " + direction + " limit ?")
+	args = append(args, page.Limit+1)
+	return clause.String(), args
+}
+
+func sortValue(cursor models.Cursor, sort models.Sort) interface{} {
+	switch sort {
+	case models.SortName:
+		return cursor.Name
+	case models.SortSize:
+		return cursor.Size
+	case models.SortVersion:
+		return cursor.Version
+	case models.SortModified:
+		return cursor.Modified
+	}
+	return nil
+}
+
+// escapeLike escapes the wildcards in a like pattern, with an escape character that means the same whatever the SQL
+// mode.
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
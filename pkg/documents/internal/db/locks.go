@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// GetLock returns the lock on a document that has not expired by now, and reports whether there is one.
+func GetLock(ctx context.Context, dbc DBTransaction, documentID int64, now time.Time) (Lock, bool, error) {
+	row := dbc.QueryRowContext(ctx, "select `document`, `user`, `reason`, `created_at`, `expires_at` from `document_locks` where `document` = ? and `expires_at` > ?",
+		documentID, now)
+	err := row.Err()
+	if err != nil {
+		return Lock{}, false, err
+	}
+
+	var lock Lock
+	err = row.Scan(&lock.Document, &lock.User, &lock.Reason, &lock.Created, &lock.Expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Lock{}, false, nil
+	} else if err != nil {
+		return Lock{}, false, err
+	}
+	return lock, true, nil
+}
+
+// ListLocks returns the locks on the given documents that have not expired by now.
+func ListLocks(ctx context.Context, dbc DBTransaction, documentIDs []int64, now time.Time) ([]Lock, error) {
+	if len(documentIDs) == 0 {
+		return nil, nil
+	}
+
+	args := []interface{}{now}
+	for _, id := range documentIDs {
+		args = append(args, id)
+	}
+	rows, err := dbc.QueryContext(ctx, "select `document`, `user`, `reason`, `created_at`, `expires_at` from `document_locks` where `expires_at` > ? and `document` in (?"+
+		strings.Repeat(", ?", len(documentIDs)-1)+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var locks []Lock
+	for rows.Next() {
+		var lock Lock
+		err = rows.Scan(&lock.Document, &lock.User, &lock.Reason, &lock.Created, &lock.Expires)
+		if err != nil {
+			return nil, err
+		}
+		locks = append(locks, lock)
+	}
+	return locks, rows.Err()
+}
+
+// PutLock creates or replaces the lock on a document.
+func PutLock(ctx context.Context, dbc DBTransaction, lock Lock) error {
+	_, err := dbc.ExecContext(ctx, "insert into `document_locks` (`document`, `user`, `reason`, `created_at`, `expires_at`) values (?, ?, ?, ?, ?) "+
+		"on duplicate key update `user` = values(`user`), `reason` = values(`reason`), `created_at` = values(`created_at`), `expires_at` = values(`expires_at`)",
+		lock.Document, lock.User, lock.Reason, lock.Created, lock.Expires)
+	return err
+}
+
+func DeleteLock(ctx context.Context, dbc DBTransaction, documentID int64) error {
+	_, err := dbc.ExecContext(ctx, "delete from `document_locks` where `document` = ?", documentID)
+	return err
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"strings"
+)
+
+// ListTags returns the tags of the documents, ordered by document and tag.
+func ListTags(ctx context.Context, dbc DBTransaction, documentIDs []int64) ([]Tag, error) {
+	if len(documentIDs) == 0 {
+		return nil, nil
+	}
+
+	rows, err := dbc.QueryContext(ctx, "select `document`, `tag` from `document_tags` where `document` in (?"+
+		strings.Repeat(", ?", len(documentIDs)-1)+") order by `document`, `tag`", idArgs(documentIDs)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var tag Tag
+		err = rows.Scan(&tag.Document, &tag.Tag)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// SetTags replaces the tags of a document.
+func SetTags(ctx context.Context, dbc DBTransaction, documentID int64, tags []string) error {
+	_, err := dbc.ExecContext(ctx, "delete from `document_tags` where `document` = ?", documentID)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		_, err = dbc.ExecContext(ctx, "insert into `document_tags` (`document`, `tag`) values (?, ?)", documentID, tag)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListMetadata returns the metadata properties of the documents, ordered by document and key.
+func ListMetadata(ctx context.Context, dbc DBTransaction, documentIDs []int64) ([]Property, error) {
+	if len(documentIDs) == 0 {
+		return nil, nil
+	}
+
+	rows, err := dbc.QueryContext(ctx, "select `document`, `key`, `value` from `document_metadata` where `document` in (?"+
+		strings.Repeat(", ?", len(documentIDs)-1)+") order by `document`, `key`", idArgs(documentIDs)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var properties []Property
+	for rows.Next() {
+		var p Property
+		err = rows.Scan(&p.Document, &p.Key, &p.Value)
+		if err != nil {
+			return nil, err
+		}
+		properties = append(properties, p)
+	}
+	return properties, rows.Err()
+}
+
+// SetProperty creates or replaces a metadata property of a document.
+func SetProperty(ctx context.Context, dbc DBTransaction, documentID int64, key string, value string) error {
+	_, err := dbc.ExecContext(ctx, "insert into `document_metadata` (`document`, `key`, `value`) values (?, ?, ?) "+
+		"on duplicate key update `value` = values(`value`)", documentID, key, value)
+	return err
+}
+
+func DeleteProperty(ctx context.Context, dbc DBTransaction, documentID int64, key string) error {
+	_, err := dbc.ExecContext(ctx, "delete from `document_metadata` where `document` = ? and `key` = ?", documentID, key)
+	return err
+}
+
+func idArgs(ids []int64) []interface{} {
+	var args []interface{}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	return args
+}
@@ -1,5 +1,9 @@
 package db
 
+import (
+	"database/sql"
+	"time"
+)
 
 type Document struct {
 	ID    int64
@@ -7,6 +11,93 @@ type Document struct {
 	Path    string
 	Version int64
 	Size    int64
+	StoredSize int64
+	SHA256 string
+	MD5 string
 	MediaType string
+	DetectedMediaType string
 	FileName string
-}
\ No newline at end of file
+	// Folder is the folder the document is in, if it is not in its owner's root folder.
+	Folder sql.NullInt64
+	// Modified is when the current version was stored, or the document created if it has none.
+	Modified time.Time
+	// Deleted is when the document was moved to the trash, if it is in the trash.
+	Deleted sql.NullTime
+}
+
+type Folder struct {
+	ID      int64
+	Owner   int64
+	Parent  sql.NullInt64
+	Name    string
+	Created time.Time
+}
+
+// Version is a stored version of a document, kept after the document has been updated.
+type Version struct {
+	Document          int64
+	Version           int64
+	Path              string
+	Size              int64
+	StoredSize        int64
+	SHA256            string
+	MD5               string
+	MediaType         string
+	// DetectedMediaType is sniffed from the contents, where MediaType is what the uploader claimed.
+	DetectedMediaType string
+	FileName          string
+	Uploader          sql.NullInt64
+	Created           time.Time
+	ScanState         string
+	// ScanSignature names the malware found in infected contents.
+	ScanSignature string
+}
+
+type Lock struct {
+	Document int64
+	User     int64
+	Reason   string
+	Created  time.Time
+	Expires  time.Time
+}
+
+type RetentionPolicy struct {
+	KeepForever    bool
+	KeepLast       int64
+	KeepDays       int64
+	DailySnapshots bool
+}
+
+type Chunk struct {
+	Seq  int64
+	Size int64
+	Blob Blob
+}
+
+type Blob struct {
+	Digest      string
+	Size        int64
+	StoredSize  int64
+	Compression string
+	WrappedKey  []byte
+	KeyID       string
+	RefCount    int64
+}
+
+// ChunkReference is a chunk of a document version, identified by the digest of its blob.
+type ChunkReference struct {
+	Document int64
+	Version  int64
+	Digest   string
+}
+
+type Tag struct {
+	Document int64
+	Tag      string
+}
+
+type Property struct {
+	Document int64
+	Key      string
+	Value    string
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// GetRetentionPolicy returns the retention policy of a document, falling back to the policy of its owner. It reports
+// whether either has a policy.
+func GetRetentionPolicy(ctx context.Context, dbc DBTransaction, documentID int64, ownerID int64) (RetentionPolicy, bool, error) {
+	row := dbc.QueryRowContext(ctx, "select `keep_forever`, `keep_last`, `keep_days`, `daily_snapshots` from `retention_policies` "+
+		"where `document` = ? or `user` = ? order by `document` is null limit 1", documentID, ownerID)
+	err := row.Err()
+	if err != nil {
+		return RetentionPolicy{}, false, err
+	}
+
+	var p RetentionPolicy
+	err = row.Scan(&p.KeepForever, &p.KeepLast, &p.KeepDays, &p.DailySnapshots)
+	if errors.Is(err, sql.ErrNoRows) {
+		return RetentionPolicy{}, false, nil
+	} else if err != nil {
+		return RetentionPolicy{}, false, err
+	}
+	return p, true, nil
+}
+
+func SetDocumentRetentionPolicy(ctx context.Context, dbc DBTransaction, documentID int64, p RetentionPolicy) error {
+	return setRetentionPolicy(ctx, dbc, "document", documentID, p)
+}
+
+func SetUserRetentionPolicy(ctx context.Context, dbc DBTransaction, userID int64, p RetentionPolicy) error {
+	return setRetentionPolicy(ctx, dbc, "user", userID, p)
+}
+
+func setRetentionPolicy(ctx context.Context, dbc DBTransaction, column string, id int64, p RetentionPolicy) error {
+	_, err := dbc.ExecContext(ctx, "insert into `retention_policies` (`"+column+"`, `keep_forever`, `keep_last`, `keep_days`, `daily_snapshots`) values (?, ?, ?, ?, ?) "+
+		"on duplicate key update `keep_forever` = values(`keep_forever`), `keep_last` = values(`keep_last`), `keep_days` = values(`keep_days`), `daily_snapshots` = values(`daily_snapshots`)",
+		id, p.KeepForever, p.KeepLast, p.KeepDays, p.DailySnapshots)
+	return err
+}
+
+func DeleteDocumentRetentionPolicy(ctx context.Context, dbc DBTransaction, documentID int64) error {
+	_, err := dbc.ExecContext(ctx, "delete from `retention_policies` where `document` = ?", documentID)
+	return err
+}
+
+func DeleteUserRetentionPolicy(ctx context.Context, dbc DBTransaction, userID int64) error {
+	_, err := dbc.ExecContext(ctx, "delete from `retention_policies` where `user` = ?", userID)
+	return err
+}
+
+// ListDocumentsWithHistory returns documents that have versions other than their current one.
+func ListDocumentsWithHistory(ctx context.Context, dbc DBTransaction) ([]Document, error) {
+	rows, err := dbc.QueryContext(ctx, "select "+documentColumns+" from `documents` d "+
+		"where exists (select 1 from `document_versions` v where v.`document` = d.`id` and v.`version` != d.`version`)")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var documents []Document
+	for rows.Next() {
+		doc, err := scanDocument(rows)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, doc)
+	}
+	return documents, rows.Err()
+}
+
+func DeleteVersion(ctx context.Context, dbc DBTransaction, documentID int64, version int64) error {
+	res, err := dbc.ExecContext(ctx, "delete from `document_versions` where `document` = ? and `version` = ?", documentID, version)
+	if err != nil {
+		return err
+	}
+
+	numberOfRows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numberOfRows != 1 {
+		return fmt.Errorf("incorrect number of rows deleted, expected: 1, got:%d", numberOfRows)
+	}
+	return nil
+}
+
+// CountPathReferences returns the number of versions whose contents are read from path.
+func CountPathReferences(ctx context.Context, dbc DBTransaction, path string) (int64, error) {
+	row := dbc.QueryRowContext(ctx, "select count(*) from `document_versions` where `path` = ?", path)
+	var n int64
+	err := row.Scan(&n)
+	return n, err
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SetDeleted moves a document to the trash, or out of it if deleted is null.
+func SetDeleted(ctx context.Context, dbc DBTransaction, documentID int64, deleted sql.NullTime) error {
+	_, err := dbc.ExecContext(ctx, "update `documents` set `deleted_at` = ? where `id` = ?", deleted, documentID)
+	return err
+}
+
+// ListTrash returns the owner's documents in the trash, most recently deleted first.
+func ListTrash(ctx context.Context, dbc DBTransaction, ownerID int64) ([]Document, error) {
+	return listDocuments(ctx, dbc, "select "+documentColumns+" from `documents` d where d.`owner` = ? and d.`deleted_at` is not null "+
+		"order by d.`deleted_at` desc, d.`id` desc", ownerID)
+}
+
+// ListDeletedBefore returns the documents that were moved to the trash before a time.
+func ListDeletedBefore(ctx context.Context, dbc DBTransaction, before time.Time) ([]Document, error) {
+	return listDocuments(ctx, dbc, "select "+documentColumns+" from `documents` d where d.`deleted_at` < ?", before)
+}
+
+// DetachTrash moves the documents in the trash that were in a folder to their owner's root folder, so the folder can
+// be deleted.
+func DetachTrash(ctx context.Context, dbc DBTransaction, folderID int64) error {
+	_, err := dbc.ExecContext(ctx, "update `documents` set `folder` = null where `folder` = ? and `deleted_at` is not null", folderID)
+	return err
+}
+
+// DeleteDocument deletes a document and who it is shared with. Its versions and chunks must have been deleted already;
+// its locks, retention policy, tags, metadata, search terms and uploads are deleted, and its audit log entries
+// detached, by their foreign keys.
+func DeleteDocument(ctx context.Context, dbc DBTransaction, documentID int64) error {
+	_, err := dbc.ExecContext(ctx, "delete from `permissions` where `document` = ?", documentID)
+	if err != nil {
+		return err
+	}
+
+	res, err := dbc.ExecContext(ctx, "delete from `documents` where `id` = ?", documentID)
+	if err != nil {
+		return err
+	}
+
+	numberOfRows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numberOfRows != 1 {
+		return fmt.Errorf("incorrect number of rows deleted, expected: 1, got:%d", numberOfRows)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package db
+
+import "context"
+
+// GetUsage returns the bytes stored by every version of the documents a user owns, and the number of those documents.
+// Documents stored before versions were recorded only count their current version.
+func GetUsage(ctx context.Context, dbc DBTransaction, ownerID int64) (int64, int64, error) {
+	row := dbc.QueryRowContext(ctx, "select "+
+		"coalesce((select sum(v.`size`) from `document_versions` v join `documents` d on d.`id` = v.`document` where d.`owner` = ?), 0) + "+
+		"coalesce((select sum(d.`size`) from `documents` d where d.`owner` = ? and d.`version` > 0 and not exists "+
+		"(select 1 from `document_versions` v where v.`document` = d.`id`)), 0), "+
+		"(select count(*) from `documents` d where d.`owner` = ? and d.`version` > 0)",
+		ownerID, ownerID, ownerID)
+	err := row.Err()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var used, files int64
+	err = row.Scan(&used, &files)
+	if err != nil {
+		return 0, 0, err
+	}
+	return used, files, nil
+}
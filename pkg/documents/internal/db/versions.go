@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"fmt"
+)
+
+const versionColumns = "`document`, `version`, `path`, `size`, `stored_size`, `sha256`, `md5`, `media_type`, `detected_media_type`, `file_name`, `uploader`, `created_at`, `scan_state`, `scan_signature`"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanVersion(row scanner) (Version, error) {
+	var v Version
+	err := row.Scan(&v.Document, &v.Version, &v.Path, &v.Size, &v.StoredSize, &v.SHA256, &v.MD5, &v.MediaType, &v.DetectedMediaType, &v.FileName, &v.Uploader, &v.Created, &v.ScanState, &v.ScanSignature)
+	return v, err
+}
+
+func InsertVersion(ctx context.Context, dbc DBTransaction, v Version) error {
+	res, err := dbc.ExecContext(ctx, "insert into `document_versions` ("+versionColumns+") values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		v.Document, v.Version, v.Path, v.Size, v.StoredSize, v.SHA256, v.MD5, v.MediaType, v.DetectedMediaType, v.FileName, v.Uploader, v.Created,
+		v.ScanState, v.ScanSignature)
+	if err != nil {
+		return err
+	}
+
+	numberOfRows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numberOfRows != 1 {
+		return fmt.Errorf("incorrect number of rows inserted, expected: 1, got:%d", numberOfRows)
+	}
+	return nil
+}
+
+// SetScanResult records the outcome of scanning a version's contents for malware.
+func SetScanResult(ctx context.Context, dbc DBTransaction, documentID int64, version int64, state string, signature string) error {
+	_, err := dbc.ExecContext(ctx, "update `document_versions` set `scan_state` = ?, `scan_signature` = ? where `document` = ? and `version` = ?",
+		state, signature, documentID, version)
+	return err
+}
+
+func GetVersion(ctx context.Context, dbc DBTransaction, documentID int64, version int64) (Version, error) {
+	row := dbc.QueryRowContext(ctx, "select "+versionColumns+" from `document_versions` where `document` = ? and `version` = ?", documentID, version)
+	err := row.Err()
+	if err != nil {
+		return Version{}, err
+	}
+	return scanVersion(row)
+}
+
+// ListVersions returns the versions of a document, newest first.
+func ListVersions(ctx context.Context, dbc DBTransaction, documentID int64) ([]Version, error) {
+	rows, err := dbc.QueryContext(ctx, "select "+versionColumns+" from `document_versions` where `document` = ? order by `version` desc", documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []Version
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// ListAllVersions returns every stored version of every document.
+func ListAllVersions(ctx context.Context, dbc DBTransaction) ([]Version, error) {
+	rows, err := dbc.QueryContext(ctx, "select "+versionColumns+" from `document_versions`")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []Version
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
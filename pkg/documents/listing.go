@@ -0,0 +1,98 @@
+package documents
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+)
+
+var ErrInvalidPage = errors.New("invalid page")
+
+// DefaultPageSize is how many documents a page of a listing holds unless a limit is given, and MaxPageSize the most it
+// can hold.
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// cursor is what a next cursor encodes: the position of the last document of a page, along with the order of the
+// listing, so a cursor can't continue a listing in another order.
+type cursor struct {
+	Sort       models.Sort
+	Descending bool
+	After      models.Cursor
+}
+
+// ParsePage reads the page of a listing to return from a limit, which is DefaultPageSize if it is 0, a cursor, which
+// is the next cursor of the previous page or empty for the first page, and an order.
+func ParsePage(limit int, nextCursor string, sort models.Sort, descending bool) (models.Page, error) {
+	switch sort {
+	case models.SortCreated, models.SortName, models.SortSize, models.SortVersion, models.SortModified:
+	default:
+		return models.Page{}, fmt.Errorf("%w: can't sort by %q", ErrInvalidPage, sort)
+	}
+	if limit == 0 {
+		limit = DefaultPageSize
+	}
+	if limit < 0 || limit > MaxPageSize {
+		return models.Page{}, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidPage, MaxPageSize)
+	}
+
+	page := models.Page{Limit: limit, Sort: sort, Descending: descending}
+	if nextCursor == "" {
+		return page, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(nextCursor)
+	if err != nil {
+		return models.Page{}, fmt.Errorf("%w: malformed cursor", ErrInvalidPage)
+	}
+	var c cursor
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return models.Page{}, fmt.Errorf("%w: malformed cursor", ErrInvalidPage)
+	}
+	if c.Sort != sort || c.Descending != descending {
+		return models.Page{}, fmt.Errorf("%w: the cursor is for a listing in another order", ErrInvalidPage)
+	}
+	page.After = &c.After
+	return page, nil
+}
+
+// listingPage makes a page out of the documents listed for it, which include the first document of the next page if
+// there is one, and attaches their labels and locks.
+func listingPage(ctx context.Context, dbc *sql.DB, documents []models.Document, page models.Page) (models.DocumentPage, error) {
+	result := models.DocumentPage{Documents: documents}
+	if len(documents) > page.Limit {
+		result.Documents = documents[:page.Limit]
+		last := result.Documents[page.Limit-1]
+		b, err := json.Marshal(cursor{
+			Sort:       page.Sort,
+			Descending: page.Descending,
+			After: models.Cursor{
+				ID:       last.ID,
+				Name:     last.FileName,
+				Size:     last.Size,
+				Version:  last.Version,
+				Modified: last.Modified,
+			},
+		})
+		if err != nil {
+			return models.DocumentPage{}, err
+		}
+		result.NextCursor = base64.RawURLEncoding.EncodeToString(b)
+	}
+	if result.Documents == nil {
+		result.Documents = []models.Document{}
+	}
+
+	err := attachLabels(ctx, dbc, result.Documents)
+	if err != nil {
+		return models.DocumentPage{}, err
+	}
+	return result, attachLocks(ctx, dbc, result.Documents)
+}
@@ -0,0 +1,110 @@
+package documents
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
+	accessModels "github.com/EatonEmmerich/cloudStorage/pkg/access_control/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/users"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+var firstPage = models.Page{Limit: DefaultPageSize}
+
+func TestListDocuments_Pages(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+
+	files := []struct {
+		name      string
+		mediaType string
+		contents  string
+	}{
+		{"c.txt", "text/plain", "ccc"},
+		{"a.png", "image/png", "a"},
+		{"report_1.txt", "text/plain; charset=utf-8", "report"},
+		{"b.jpg", "image/jpeg", "bbbbbb"},
+		{"report%2.txt", "text/plain", "rep"},
+	}
+	ids := make(map[string]int64)
+	for _, f := range files {
+		id, err := Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte(f.contents))), f.mediaType, f.name)
+		require.NoError(t, err)
+		ids[f.name] = int64(id)
+
+		doc, err := Get(ctx, dbc, int64(id))
+		require.NoError(t, err)
+		require.NoError(t, access_control.ShareDocument(ctx, dbc, doc, john, jane, accessModels.READ))
+	}
+	_, err = Update(ctx, dbc, ids["a.png"], john, io.NopCloser(bytes.NewReader([]byte("aa"))), "image/png", "a.png")
+	require.NoError(t, err)
+
+	// list follows a listing page by page, checking each page is full until the last.
+	list := func(shared bool, filter models.Filter, limit int, sort models.Sort, descending bool) []string {
+		var names []string
+		var cursor string
+		for {
+			page, err := ParsePage(limit, cursor, sort, descending)
+			require.NoError(t, err)
+
+			var result models.DocumentPage
+			if shared {
+				result, err = ListSharedDocuments(ctx, dbc, jane, filter, page)
+			} else {
+				result, err = ListDocuments(ctx, dbc, john, filter, page)
+			}
+			require.NoError(t, err)
+			for _, doc := range result.Documents {
+				names = append(names, doc.FileName)
+			}
+			if result.NextCursor == "" {
+				return names
+			}
+			require.Len(t, result.Documents, limit)
+			cursor = result.NextCursor
+		}
+	}
+
+	require.Equal(t, []string{"c.txt", "a.png", "report_1.txt", "b.jpg", "report%2.txt"}, list(false, models.Filter{}, 2, models.SortCreated, false))
+	require.Equal(t, []string{"a.png", "b.jpg", "c.txt", "report%2.txt", "report_1.txt"}, list(false, models.Filter{}, 2, models.SortName, false))
+	require.Equal(t, []string{"report_1.txt", "report%2.txt", "c.txt", "b.jpg", "a.png"}, list(true, models.Filter{}, 3, models.SortName, true))
+	// Documents of the same size are in the reverse of the order they were created.
+	require.Equal(t, []string{"b.jpg", "report_1.txt", "report%2.txt", "c.txt", "a.png"}, list(false, models.Filter{}, 1, models.SortSize, true))
+	require.Equal(t, "a.png", list(true, models.Filter{}, 2, models.SortVersion, true)[0])
+	// Modified times are only stored to the second.
+	_, err = dbc.Exec("update `documents` set `modified_at` = ? where `id` = ?", time.Now().Add(-time.Hour), ids["b.jpg"])
+	require.NoError(t, err)
+	require.Equal(t, []string{"b.jpg", "c.txt", "a.png", "report_1.txt", "report%2.txt"}, list(false, models.Filter{}, 2, models.SortModified, false))
+
+	require.Equal(t, []string{"a.png", "b.jpg"}, list(false, models.Filter{MediaType: "image/*"}, 1, models.SortName, false))
+	require.Equal(t, []string{"c.txt", "report_1.txt", "report%2.txt"}, list(true, models.Filter{MediaType: "text/plain"}, 2, models.SortCreated, false))
+	minSize, maxSize := int64(3), int64(4)
+	require.Equal(t, []string{"c.txt", "report%2.txt"}, list(false, models.Filter{MinSize: &minSize, MaxSize: &maxSize}, 5, models.SortCreated, false))
+	require.Equal(t, []string{"report_1.txt", "report%2.txt"}, list(false, models.Filter{NamePrefix: "report"}, 5, models.SortCreated, false))
+	require.Equal(t, []string{"report%2.txt"}, list(true, models.Filter{NamePrefix: "report%"}, 5, models.SortCreated, false))
+	require.Equal(t, []string{"report_1.txt"}, list(false, models.Filter{NamePrefix: "report_"}, 5, models.SortCreated, false))
+
+	first, err := ListDocuments(ctx, dbc, john, models.Filter{}, models.Page{Limit: 2, Sort: models.SortName})
+	require.NoError(t, err)
+	_, err = ParsePage(2, first.NextCursor, models.SortSize, false)
+	require.True(t, errors.Is(err, ErrInvalidPage))
+	_, err = ParsePage(2, first.NextCursor, models.SortName, true)
+	require.True(t, errors.Is(err, ErrInvalidPage))
+	_, err = ParsePage(2, "not a cursor", models.SortName, false)
+	require.True(t, errors.Is(err, ErrInvalidPage))
+	_, err = ParsePage(MaxPageSize+1, "", models.SortName, false)
+	require.True(t, errors.Is(err, ErrInvalidPage))
+	_, err = ParsePage(0, "", "owner", false)
+	require.True(t, errors.Is(err, ErrInvalidPage))
+}
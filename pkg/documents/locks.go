@@ -0,0 +1,231 @@
+package documents
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
+	models2 "github.com/EatonEmmerich/cloudStorage/pkg/access_control/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/internal/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/users"
+	"time"
+)
+
+var ErrLocked = errors.New("document is locked")
+
+var ErrNotLocked = errors.New("document is not locked by user")
+
+// Locks last DefaultLockDuration unless asked otherwise, and never longer than MaxLockDuration without being refreshed.
+const (
+	DefaultLockDuration = 30 * time.Minute
+	MaxLockDuration     = 24 * time.Hour
+)
+
+func lockExpiry(now time.Time, duration time.Duration) (time.Time, error) {
+	if duration == 0 {
+		duration = DefaultLockDuration
+	}
+	if duration < 0 || duration > MaxLockDuration {
+		return time.Time{}, fmt.Errorf("lock duration must be between 0 and %s", MaxLockDuration)
+	}
+	return now.Add(duration), nil
+}
+
+// Lock checks a document out to a user, so that nobody else can update it until the lock is released or expires.
+// Locking a document the user already holds the lock on replaces its expiry and reason.
+func Lock(ctx context.Context, dbc *sql.DB, documentID int64, userID int64, duration time.Duration, reason string) (models.Lock, error) {
+	doc, err := Get(ctx, dbc, documentID)
+	if err != nil {
+		return models.Lock{}, err
+	}
+
+	err = access_control.AuthoriseOrError(ctx, dbc, userID, doc, models2.WRITE)
+	if err != nil {
+		return models.Lock{}, err
+	}
+
+	now := time.Now()
+	expires, err := lockExpiry(now, duration)
+	if err != nil {
+		return models.Lock{}, err
+	}
+
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Lock{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = db.GetForUpdate(ctx, tx, documentID)
+	if err != nil {
+		return models.Lock{}, err
+	}
+
+	err = checkLock(ctx, tx, documentID, userID)
+	if err != nil {
+		return models.Lock{}, err
+	}
+
+	lock := db.Lock{Document: documentID, User: userID, Reason: reason, Created: now, Expires: expires}
+	err = db.PutLock(ctx, tx, lock)
+	if err != nil {
+		return models.Lock{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return models.Lock{}, err
+	}
+
+	err = access_control.Log(ctx, dbc, userID, documentID, "Lock - Until:"+expires.UTC().Format(time.RFC3339))
+	if err != nil {
+		return models.Lock{}, err
+	}
+	return lockModel(lock), nil
+}
+
+// RefreshLock extends a lock the user holds on a document.
+func RefreshLock(ctx context.Context, dbc *sql.DB, documentID int64, userID int64, duration time.Duration) (models.Lock, error) {
+	now := time.Now()
+	expires, err := lockExpiry(now, duration)
+	if err != nil {
+		return models.Lock{}, err
+	}
+
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Lock{}, err
+	}
+	defer tx.Rollback()
+
+	// Locks on documents in the trash can't be extended, just as documents in the trash can't be locked.
+	doc, err := db.GetForUpdate(ctx, tx, documentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Lock{}, fmt.Errorf("%w: %d", ErrNotFound, documentID)
+	} else if err != nil {
+		return models.Lock{}, err
+	}
+	if doc.Deleted.Valid {
+		return models.Lock{}, fmt.Errorf("%w: %d", ErrNotFound, documentID)
+	}
+
+	lock, ok, err := db.GetLock(ctx, tx, documentID, now)
+	if err != nil {
+		return models.Lock{}, err
+	}
+	if !ok || lock.User != userID {
+		return models.Lock{}, fmt.Errorf("%w: document %d", ErrNotLocked, documentID)
+	}
+
+	lock.Expires = expires
+	err = db.PutLock(ctx, tx, lock)
+	if err != nil {
+		return models.Lock{}, err
+	}
+	return lockModel(lock), tx.Commit()
+}
+
+// Unlock releases the lock on a document. The lock holder can release it, and the document's owner or an
+// administrator can break a lock someone else holds. Unlocking a document that is not locked does nothing.
+func Unlock(ctx context.Context, dbc *sql.DB, documentID int64, userID int64) error {
+	doc, err := Get(ctx, dbc, documentID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = db.GetForUpdate(ctx, tx, documentID)
+	if err != nil {
+		return err
+	}
+
+	lock, ok, err := db.GetLock(ctx, tx, documentID, time.Now())
+	if err != nil || !ok {
+		return err
+	}
+
+	action := "Unlock"
+	if lock.User != userID {
+		admin, err := users.IsAdmin(ctx, dbc, userID)
+		if err != nil {
+			return err
+		}
+		if doc.Owner != userID && !admin {
+			// The audit log refers to the document, so its row must be unlocked before the entry can be added.
+			err = tx.Rollback()
+			if err != nil {
+				return err
+			}
+			err = access_control.Log(ctx, dbc, userID, documentID, "Unlock - Unauthorised")
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("%w", access_control.ErrAccessDenied)
+		}
+		action = fmt.Sprintf("Unlock - Broke lock of user %d", lock.User)
+	}
+
+	err = db.DeleteLock(ctx, tx, documentID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	return access_control.Log(ctx, dbc, userID, documentID, action)
+}
+
+// checkLock fails with ErrLocked if someone other than the user holds a lock on the document.
+func checkLock(ctx context.Context, dbc db.DBTransaction, documentID int64, userID int64) error {
+	lock, ok, err := db.GetLock(ctx, dbc, documentID, time.Now())
+	if err != nil {
+		return err
+	}
+	if ok && lock.User != userID {
+		return fmt.Errorf("%w by user %d until %s", ErrLocked, lock.User, lock.Expires.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// attachLocks sets the current lock of each of the documents that are locked.
+func attachLocks(ctx context.Context, dbc *sql.DB, documents []models.Document) error {
+	var ids []int64
+	for _, doc := range documents {
+		ids = append(ids, doc.ID)
+	}
+
+	locks, err := db.ListLocks(ctx, dbc, ids, time.Now())
+	if err != nil {
+		return err
+	}
+
+	byDocument := make(map[int64]db.Lock)
+	for _, lock := range locks {
+		byDocument[lock.Document] = lock
+	}
+	for i := range documents {
+		if lock, ok := byDocument[documents[i].ID]; ok {
+			m := lockModel(lock)
+			documents[i].Lock = &m
+		}
+	}
+	return nil
+}
+
+func lockModel(lock db.Lock) models.Lock {
+	return models.Lock{
+		User:    lock.User,
+		Reason:  lock.Reason,
+		Created: lock.Created,
+		Expires: lock.Expires,
+	}
+}
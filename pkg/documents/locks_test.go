@@ -0,0 +1,103 @@
+package documents
+
+import (
+	"bytes"
+	"context"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
+	accessModels "github.com/EatonEmmerich/cloudStorage/pkg/access_control/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/users"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+	carl, err := users.Register(ctx, dbc, "carl", "password")
+	require.NoError(t, err)
+	admin, err := users.Register(ctx, dbc, "admin", "password")
+	require.NoError(t, err)
+	_, err = dbc.ExecContext(ctx, "update `users` set `admin` = true where `id` = ?", admin)
+	require.NoError(t, err)
+
+	documentID, err := Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("v1"))), "application/vnd.ms-excel", "budget.xls")
+	require.NoError(t, err)
+	id := int64(documentID)
+	doc, err := Get(ctx, dbc, id)
+	require.NoError(t, err)
+	require.NoError(t, access_control.ShareDocument(ctx, dbc, doc, john, jane, accessModels.READ|accessModels.WRITE))
+	require.NoError(t, access_control.ShareDocument(ctx, dbc, doc, john, carl, accessModels.READ|accessModels.WRITE))
+
+	update := func(userID int64) error {
+		_, err := Update(ctx, dbc, id, userID, io.NopCloser(bytes.NewReader([]byte("edit"))), "application/vnd.ms-excel", "budget.xls")
+		return err
+	}
+
+	lock, err := Lock(ctx, dbc, id, jane, time.Hour, "quarterly numbers")
+	require.NoError(t, err)
+	require.Equal(t, jane, lock.User)
+	require.Equal(t, "quarterly numbers", lock.Reason)
+
+	require.NoError(t, update(jane))
+	require.ErrorIs(t, update(john), ErrLocked)
+	require.ErrorIs(t, update(carl), ErrLocked)
+	_, err = Restore(ctx, dbc, id, 1, carl)
+	require.ErrorIs(t, err, ErrLocked)
+	_, err = Lock(ctx, dbc, id, carl, time.Hour, "")
+	require.ErrorIs(t, err, ErrLocked)
+	_, err = RefreshLock(ctx, dbc, id, carl, time.Hour)
+	require.ErrorIs(t, err, ErrNotLocked)
+	require.ErrorIs(t, Unlock(ctx, dbc, id, carl), access_control.ErrAccessDenied)
+
+	refreshed, err := RefreshLock(ctx, dbc, id, jane, 2*time.Hour)
+	require.NoError(t, err)
+	require.True(t, refreshed.Expires.After(lock.Expires))
+	require.Equal(t, "quarterly numbers", refreshed.Reason)
+
+	owned, err := ListDocuments(ctx, dbc, john, models.Filter{}, firstPage)
+	require.NoError(t, err)
+	require.NotNil(t, owned.Documents[0].Lock)
+	require.Equal(t, jane, owned.Documents[0].Lock.User)
+	shared, err := ListSharedDocuments(ctx, dbc, carl, models.Filter{}, firstPage)
+	require.NoError(t, err)
+	require.NotNil(t, shared.Documents[0].Lock)
+
+	// The owner can break the lock.
+	require.NoError(t, Unlock(ctx, dbc, id, john))
+	require.NoError(t, update(carl))
+	owned, err = ListDocuments(ctx, dbc, john, models.Filter{}, firstPage)
+	require.NoError(t, err)
+	require.Nil(t, owned.Documents[0].Lock)
+
+	// So can an administrator, without any permissions on the document.
+	_, err = Lock(ctx, dbc, id, carl, 0, "")
+	require.NoError(t, err)
+	require.NoError(t, Unlock(ctx, dbc, id, admin))
+	require.NoError(t, update(jane))
+
+	// Expired locks don't count.
+	_, err = Lock(ctx, dbc, id, carl, time.Minute, "")
+	require.NoError(t, err)
+	_, err = dbc.ExecContext(ctx, "update `document_locks` set `expires_at` = ? where `document` = ?", time.Now().Add(-time.Minute), id)
+	require.NoError(t, err)
+	require.NoError(t, update(jane))
+
+	_, err = Lock(ctx, dbc, id, jane, 48*time.Hour, "")
+	require.Error(t, err)
+
+	// Locks on documents in the trash can't be extended.
+	_, err = Lock(ctx, dbc, id, john, time.Hour, "")
+	require.NoError(t, err)
+	require.NoError(t, Delete(ctx, dbc, id, john))
+	_, err = RefreshLock(ctx, dbc, id, john, time.Hour)
+	require.ErrorIs(t, err, ErrNotFound)
+}
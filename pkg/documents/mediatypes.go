@@ -0,0 +1,177 @@
+package documents
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/users"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var ErrMediaTypeNotAllowed = errors.New("media type not allowed")
+
+// mediaTypePolicy applies to every upload, along with the uploading user's own policy.
+var mediaTypePolicy models.MediaTypePolicy
+
+// sniffLength is the number of bytes http.DetectContentType considers.
+const sniffLength = 512
+
+// mediaTypeSniffer keeps the first bytes written to it, to detect their media type from.
+type mediaTypeSniffer struct {
+	head []byte
+}
+
+func (m *mediaTypeSniffer) Write(p []byte) (int, error) {
+	if n := sniffLength - len(m.head); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		m.head = append(m.head, p[:n]...)
+	}
+	return len(p), nil
+}
+
+func (m *mediaTypeSniffer) mediaType() string {
+	return http.DetectContentType(m.head)
+}
+
+// ParseMediaTypes parses a comma separated list of media types and patterns.
+func ParseMediaTypes(s string) []string {
+	var types []string
+	for _, t := range strings.Split(s, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// GetMediaTypePolicy returns the media types a user may and may not upload, on top of those set for every user.
+func GetMediaTypePolicy(ctx context.Context, dbc *sql.DB, userID int64) (models.MediaTypePolicy, error) {
+	allowed, denied, err := users.GetMediaTypePolicy(ctx, dbc, userID)
+	if err != nil {
+		return models.MediaTypePolicy{}, err
+	}
+	return models.MediaTypePolicy{Allowed: ParseMediaTypes(allowed), Denied: ParseMediaTypes(denied)}, nil
+}
+
+// SetMediaTypePolicy sets the media types a user may and may not upload, which only administrators may do.
+func SetMediaTypePolicy(ctx context.Context, dbc *sql.DB, adminID int64, userID int64, policy models.MediaTypePolicy) error {
+	admin, err := users.IsAdmin(ctx, dbc, adminID)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return fmt.Errorf("%w: only administrators can set media type policies", access_control.ErrAccessDenied)
+	}
+	return users.SetMediaTypePolicy(ctx, dbc, userID, strings.Join(policy.Allowed, ","), strings.Join(policy.Denied, ","))
+}
+
+// checkMediaType fails with ErrMediaTypeNotAllowed, and removes the staged contents, if either the claimed or the
+// detected media type of an upload is not allowed for the user.
+func checkMediaType(ctx context.Context, dbc *sql.DB, userID int64, claimed string, temp tempFile) error {
+	err := checkMediaTypes(ctx, dbc, userID, claimed, temp.mediaType)
+	if err != nil {
+		os.Remove(temp.path)
+	}
+	return err
+}
+
+// checkMediaTypes checks that the global policy and the user's policy allow each of the media types that is not empty.
+func checkMediaTypes(ctx context.Context, dbc *sql.DB, userID int64, mediaTypes ...string) error {
+	userPolicy, err := GetMediaTypePolicy(ctx, dbc, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, mediaType := range mediaTypes {
+		if mediaType == "" {
+			continue
+		}
+		if !mediaTypeAllowed(mediaTypePolicy, mediaType) || !mediaTypeAllowed(userPolicy, mediaType) {
+			return fmt.Errorf("%w: %s", ErrMediaTypeNotAllowed, essence(mediaType))
+		}
+	}
+	return nil
+}
+
+func mediaTypeAllowed(policy models.MediaTypePolicy, mediaType string) bool {
+	mediaType = essence(mediaType)
+	if matchesAny(policy.Denied, mediaType) {
+		return false
+	}
+	return len(policy.Allowed) == 0 || matchesAny(policy.Allowed, mediaType)
+}
+
+func matchesAny(patterns []string, mediaType string) bool {
+	for _, pattern := range patterns {
+		switch {
+		case pattern == "*" || pattern == "*/*":
+			return true
+		case strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*")):
+			return true
+		case pattern == mediaType:
+			return true
+		}
+	}
+	return false
+}
+
+// essence strips the parameters from a media type, leaving its lower case type and subtype.
+func essence(mediaType string) string {
+	parsed, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(strings.SplitN(mediaType, ";", 2)[0]))
+	}
+	return parsed
+}
+
+// Detection only recognises a handful of types, and reports anything it doesn't recognise as one of these.
+var genericMediaTypes = map[string]bool{
+	"application/octet-stream": true,
+	"text/plain":               true,
+	"application/zip":          true,
+}
+
+// Browsers run scripts in these types, so they are never served unless they were detected as well as claimed.
+var activeMediaTypes = map[string]bool{
+	"text/html":              true,
+	"application/xhtml+xml":  true,
+	"image/svg+xml":          true,
+	"text/xml":               true,
+	"application/xml":        true,
+	"text/javascript":        true,
+	"application/javascript": true,
+}
+
+// ContentType returns the media type to serve a document's contents as. The claimed type is used when the contents
+// agree with it. Contents that were detected as something else are served as what they are, or as
+// application/octet-stream if a browser would run scripts in them.
+func ContentType(claimed string, detected string) string {
+	if detected == "" {
+		// Contents stored before media types were detected.
+		return claimed
+	}
+	if claimed == "" {
+		return detected
+	}
+
+	c, d := essence(claimed), essence(detected)
+	switch {
+	case c == d:
+		return claimed
+	case genericMediaTypes[d] && !activeMediaTypes[c]:
+		return claimed
+	case activeMediaTypes[d]:
+		return "application/octet-stream"
+	default:
+		return detected
+	}
+}
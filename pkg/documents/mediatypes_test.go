@@ -0,0 +1,86 @@
+package documents
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
+	"github.com/EatonEmmerich/cloudStorage/pkg/db"
+	internalDB "github.com/EatonEmmerich/cloudStorage/pkg/documents/internal/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/users"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+)
+
+var pngHeader = []byte("\x89PNG\x0D\x0A\x1A\x0A\x00\x00\x00\x0DIHDR")
+
+func TestMediaTypePolicy(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	defer func(policy models.MediaTypePolicy) { mediaTypePolicy = policy }(mediaTypePolicy)
+	mediaTypePolicy = models.MediaTypePolicy{Denied: ParseMediaTypes("text/html, application/x-msdownload")}
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	admin, err := users.Register(ctx, dbc, "admin", "password")
+	require.NoError(t, err)
+	_, err = dbc.ExecContext(ctx, "update users set `admin` = true where `id` = ?", admin)
+	require.NoError(t, err)
+
+	upload := func(data []byte, mediaType string) (int, error) {
+		return Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader(data)), mediaType, "file")
+	}
+
+	// HTML claiming to be an image is detected, and denied.
+	_, err = upload([]byte("<html><script>alert(1)</script></html>"), "image/png")
+	require.True(t, errors.Is(err, ErrMediaTypeNotAllowed))
+	_, err = upload([]byte("plain"), "text/HTML; charset=utf-8")
+	require.True(t, errors.Is(err, ErrMediaTypeNotAllowed))
+
+	documentID, err := upload(pngHeader, "image/png")
+	require.NoError(t, err)
+	doc, err := Get(ctx, dbc, int64(documentID))
+	require.NoError(t, err)
+	require.Equal(t, "image/png", doc.MediaType)
+	require.Equal(t, "image/png", doc.DetectedMediaType)
+
+	policy := models.MediaTypePolicy{Allowed: []string{"image/*"}}
+	require.True(t, errors.Is(SetMediaTypePolicy(ctx, dbc, john, john, policy), access_control.ErrAccessDenied))
+	require.NoError(t, SetMediaTypePolicy(ctx, dbc, admin, john, policy))
+
+	_, err = upload([]byte("plain"), "text/plain")
+	require.True(t, errors.Is(err, ErrMediaTypeNotAllowed))
+	_, err = Update(ctx, dbc, int64(documentID), john, io.NopCloser(bytes.NewReader([]byte("plain"))), "image/png", "file")
+	require.True(t, errors.Is(err, ErrMediaTypeNotAllowed))
+	_, err = upload(pngHeader, "image/png")
+	require.NoError(t, err)
+
+	incomplete, err := internalDB.ListIncompleteDocuments(ctx, dbc)
+	require.NoError(t, err)
+	require.Empty(t, incomplete)
+}
+
+func TestContentType(t *testing.T) {
+	tests := []struct {
+		claimed  string
+		detected string
+		expected string
+	}{
+		{claimed: "image/png", detected: "image/png", expected: "image/png"},
+		{claimed: "text/plain; charset=utf-8", detected: "text/plain; charset=utf-8", expected: "text/plain; charset=utf-8"},
+		{claimed: "application/json", detected: "text/plain; charset=utf-8", expected: "application/json"},
+		{claimed: "application/vnd.openxmlformats-officedocument.wordprocessingml.document", detected: "application/zip",
+			expected: "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{claimed: "image/png", detected: "text/html; charset=utf-8", expected: "application/octet-stream"},
+		{claimed: "text/html", detected: "text/plain; charset=utf-8", expected: "text/plain; charset=utf-8"},
+		{claimed: "image/gif", detected: "image/png", expected: "image/png"},
+		{claimed: "", detected: "image/png", expected: "image/png"},
+		{claimed: "text/html", detected: "", expected: "text/html"},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, ContentType(tt.claimed, tt.detected), "claimed %q, detected %q", tt.claimed, tt.detected)
+	}
+}
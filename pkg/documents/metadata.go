@@ -0,0 +1,185 @@
+package documents
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
+	models2 "github.com/EatonEmmerich/cloudStorage/pkg/access_control/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/internal/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"sort"
+	"strings"
+)
+
+var ErrInvalidLabel = errors.New("invalid tag or metadata")
+
+// GetLabels returns the tags and metadata of a document the user can read.
+func GetLabels(ctx context.Context, dbc *sql.DB, documentID int64, userID int64) (models.Labels, error) {
+	doc, err := Get(ctx, dbc, documentID)
+	if err != nil {
+		return models.Labels{}, err
+	}
+
+	err = access_control.AuthoriseOrError(ctx, dbc, userID, doc, models2.READ)
+	if err != nil {
+		return models.Labels{}, err
+	}
+
+	documents := []models.Document{doc}
+	err = attachLabels(ctx, dbc, documents)
+	if err != nil {
+		return models.Labels{}, err
+	}
+
+	labels := models.Labels{Tags: documents[0].Tags, Metadata: documents[0].Metadata}
+	if labels.Tags == nil {
+		labels.Tags = []string{}
+	}
+	if labels.Metadata == nil {
+		labels.Metadata = map[string]string{}
+	}
+	return labels, nil
+}
+
+// SetTags replaces the tags of a document the user can write.
+func SetTags(ctx context.Context, dbc *sql.DB, documentID int64, userID int64, tags []string) error {
+	unique := make(map[string]bool)
+	var sorted []string
+	for _, tag := range tags {
+		err := checkLabel(tag, 64)
+		if err != nil {
+			return err
+		}
+		if !unique[tag] {
+			unique[tag] = true
+			sorted = append(sorted, tag)
+		}
+	}
+	sort.Strings(sorted)
+
+	err := editLabels(ctx, dbc, documentID, userID, "Tags - Set:"+strings.Join(sorted, ","), func(tx *sql.Tx) error {
+		return db.SetTags(ctx, tx, documentID, sorted)
+	})
+	if err != nil {
+		return err
+	}
+
+	indexTags(ctx, dbc, documentID, sorted)
+	return nil
+}
+
+// SetMetadata sets metadata properties of a document the user can write. Properties set to an empty value are removed,
+// and properties left out are kept.
+func SetMetadata(ctx context.Context, dbc *sql.DB, documentID int64, userID int64, metadata map[string]string) error {
+	var keys []string
+	for key, value := range metadata {
+		err := checkLabel(key, 64)
+		if err != nil {
+			return err
+		}
+		if len(value) > 255 {
+			return fmt.Errorf("%w: value of %q is too long", ErrInvalidLabel, key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return editLabels(ctx, dbc, documentID, userID, "Metadata - Set:"+strings.Join(keys, ","), func(tx *sql.Tx) error {
+		for _, key := range keys {
+			var err error
+			if metadata[key] == "" {
+				err = db.DeleteProperty(ctx, tx, documentID, key)
+			} else {
+				err = db.SetProperty(ctx, tx, documentID, key, metadata[key])
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// editLabels makes an edit to the labels of a document the user can write, which its lock applies to.
+func editLabels(ctx context.Context, dbc *sql.DB, documentID int64, userID int64, action string, edit func(tx *sql.Tx) error) error {
+	doc, err := Get(ctx, dbc, documentID)
+	if err != nil {
+		return err
+	}
+
+	err = access_control.AuthoriseOrError(ctx, dbc, userID, doc, models2.WRITE)
+	if err != nil {
+		return err
+	}
+
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = db.GetForUpdate(ctx, tx, documentID)
+	if err != nil {
+		return err
+	}
+
+	err = checkLock(ctx, tx, documentID, userID)
+	if err != nil {
+		return err
+	}
+
+	err = edit(tx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	return access_control.Log(ctx, dbc, userID, documentID, action)
+}
+
+// attachLabels sets the tags and metadata of each of the documents.
+func attachLabels(ctx context.Context, dbc *sql.DB, documents []models.Document) error {
+	var ids []int64
+	for _, doc := range documents {
+		ids = append(ids, doc.ID)
+	}
+
+	tags, err := db.ListTags(ctx, dbc, ids)
+	if err != nil {
+		return err
+	}
+
+	properties, err := db.ListMetadata(ctx, dbc, ids)
+	if err != nil {
+		return err
+	}
+
+	tagsByDocument := make(map[int64][]string)
+	for _, tag := range tags {
+		tagsByDocument[tag.Document] = append(tagsByDocument[tag.Document], tag.Tag)
+	}
+	metadataByDocument := make(map[int64]map[string]string)
+	for _, p := range properties {
+		if metadataByDocument[p.Document] == nil {
+			metadataByDocument[p.Document] = make(map[string]string)
+		}
+		metadataByDocument[p.Document][p.Key] = p.Value
+	}
+	for i := range documents {
+		documents[i].Tags = tagsByDocument[documents[i].ID]
+		documents[i].Metadata = metadataByDocument[documents[i].ID]
+	}
+	return nil
+}
+
+func checkLabel(label string, maxLength int) error {
+	if label == "" || len(label) > maxLength || strings.ContainsAny(label, ",=") {
+		return fmt.Errorf("%w: %q", ErrInvalidLabel, label)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package documents
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
+	accessModels "github.com/EatonEmmerich/cloudStorage/pkg/access_control/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/users"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestLabels(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+	carl, err := users.Register(ctx, dbc, "carl", "password")
+	require.NoError(t, err)
+
+	invoiceID, err := Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("invoice"))), "text/plain", "invoice.txt")
+	require.NoError(t, err)
+	reportID, err := Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("report"))), "text/plain", "report.txt")
+	require.NoError(t, err)
+	for id, perm := range map[int]accessModels.PERM{invoiceID: accessModels.READ | accessModels.WRITE, reportID: accessModels.READ} {
+		doc, err := Get(ctx, dbc, int64(id))
+		require.NoError(t, err)
+		require.NoError(t, access_control.ShareDocument(ctx, dbc, doc, john, jane, perm))
+	}
+
+	require.NoError(t, SetTags(ctx, dbc, int64(invoiceID), john, []string{"invoice", "2024", "invoice"}))
+	require.NoError(t, SetMetadata(ctx, dbc, int64(invoiceID), jane, map[string]string{"client": "acme", "due": "march"}))
+	require.NoError(t, SetTags(ctx, dbc, int64(reportID), john, []string{"2024"}))
+	require.NoError(t, SetMetadata(ctx, dbc, int64(reportID), john, map[string]string{"client": "initech"}))
+
+	// Editing labels needs write access, and reading them read access.
+	require.True(t, errors.Is(SetTags(ctx, dbc, int64(reportID), jane, []string{"mine"}), access_control.ErrAccessDenied))
+	_, err = GetLabels(ctx, dbc, int64(invoiceID), carl)
+	require.True(t, errors.Is(err, access_control.ErrAccessDenied))
+	require.True(t, errors.Is(SetTags(ctx, dbc, int64(invoiceID), john, []string{""}), ErrInvalidLabel))
+	require.True(t, errors.Is(SetMetadata(ctx, dbc, int64(invoiceID), john, map[string]string{"a=b": "c"}), ErrInvalidLabel))
+
+	labels, err := GetLabels(ctx, dbc, int64(invoiceID), jane)
+	require.NoError(t, err)
+	require.Equal(t, []string{"2024", "invoice"}, labels.Tags)
+	require.Equal(t, map[string]string{"client": "acme", "due": "march"}, labels.Metadata)
+
+	// Properties left out are kept and empty ones removed.
+	require.NoError(t, SetMetadata(ctx, dbc, int64(invoiceID), john, map[string]string{"due": ""}))
+	labels, err = GetLabels(ctx, dbc, int64(invoiceID), john)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"client": "acme"}, labels.Metadata)
+
+	owned, err := ListDocuments(ctx, dbc, john, models.Filter{Tags: []string{"2024"}}, firstPage)
+	require.NoError(t, err)
+	require.Len(t, owned.Documents, 2)
+	owned, err = ListDocuments(ctx, dbc, john, models.Filter{Tags: []string{"2024", "invoice"}}, firstPage)
+	require.NoError(t, err)
+	require.Len(t, owned.Documents, 1)
+	require.Equal(t, int64(invoiceID), owned.Documents[0].ID)
+	require.Equal(t, []string{"2024", "invoice"}, owned.Documents[0].Tags)
+	require.Equal(t, "acme", owned.Documents[0].Metadata["client"])
+	owned, err = ListDocuments(ctx, dbc, john, models.Filter{Metadata: map[string]string{"client": "acme", "due": "march"}}, firstPage)
+	require.NoError(t, err)
+	require.Empty(t, owned.Documents)
+
+	shared, err := ListSharedDocuments(ctx, dbc, jane, models.Filter{Tags: []string{"2024"}, Metadata: map[string]string{"client": "initech"}}, firstPage)
+	require.NoError(t, err)
+	require.Len(t, shared.Documents, 1)
+	require.Equal(t, int64(reportID), shared.Documents[0].ID)
+	require.Equal(t, []string{"2024"}, shared.Documents[0].Tags)
+
+	// Locks apply to labels like they do to contents.
+	_, err = Lock(ctx, dbc, int64(invoiceID), john, time.Minute, "editing")
+	require.NoError(t, err)
+	require.True(t, errors.Is(SetTags(ctx, dbc, int64(invoiceID), jane, []string{"paid"}), ErrLocked))
+}
@@ -1,11 +1,169 @@
 package models
 
+import "time"
+
 type Document struct {
 	ID    int64
 	Owner   int64
 	Path    string
 	Version int64
 	Size    int64
+	StoredSize int64
+	SHA256 string
+	MD5 string
 	MediaType string
+	// DetectedMediaType is sniffed from the contents, where MediaType is what the uploader claimed.
+	DetectedMediaType string
 	FileName string
-}
\ No newline at end of file
+	// Folder is the ID of the folder the document is in, or 0 if it is in its owner's root folder.
+	Folder int64
+	// Modified is when the version was stored, if it is known.
+	Modified time.Time
+	// ScanState is the outcome of scanning the version for malware. It is only set on documents that have been opened.
+	ScanState ScanState
+	// Lock is the document's current check-out lock, if it has one.
+	Lock *Lock
+	// Deleted is when the document was moved to the trash, if it is in the trash.
+	Deleted *time.Time
+	// Tags and Metadata label the document. They are only set on listed documents.
+	Tags     []string
+	Metadata map[string]string
+}
+
+type Version struct {
+	Document          int64
+	Version           int64
+	Size              int64
+	SHA256            string
+	MD5               string
+	MediaType         string
+	DetectedMediaType string
+	FileName          string
+	// Uploader is the ID of the user who uploaded the version, or 0 if that user no longer exists.
+	Uploader          int64
+	Created           time.Time
+	ScanState         ScanState
+	ScanSignature     string
+}
+
+// ScanState is the outcome of scanning a version's contents for malware. Versions stored while no scanner was
+// configured are ScanUnscanned.
+type ScanState string
+
+const (
+	ScanUnscanned ScanState = ""
+	ScanPending   ScanState = "pending"
+	ScanClean     ScanState = "clean"
+	ScanInfected  ScanState = "infected"
+	ScanError     ScanState = "error"
+)
+
+// RetentionPolicy decides which previous versions of a document are kept. A version is kept if any of the rules
+// keeps it, and the current version is always kept.
+type RetentionPolicy struct {
+	KeepForever bool
+	// KeepLast keeps the newest KeepLast versions.
+	KeepLast int64
+	// KeepDays keeps every version created in the last KeepDays days.
+	KeepDays int64
+	// DailySnapshots keeps the newest version created on each day.
+	DailySnapshots bool
+}
+
+// Lock gives a user the exclusive right to update a document until it expires.
+type Lock struct {
+	User    int64
+	Reason  string
+	Created time.Time
+	Expires time.Time
+}
+
+// Usage is how much a user stores. Quota and Remaining are nil if the user's storage is unlimited.
+type Usage struct {
+	Used      int64
+	Files     int64
+	Quota     *int64
+	Remaining *int64
+}
+
+// MediaTypePolicy restricts the media types that can be uploaded. Types are matched exactly, or by a pattern such as
+// "image/*". A type is allowed unless it matches Denied, or Allowed is not empty and it matches none of Allowed.
+type MediaTypePolicy struct {
+	Allowed []string
+	Denied  []string
+}
+
+// Folder groups documents of its owner's. Folders with a Parent of 0 are in the owner's root folder, which has an ID of
+// 0 itself.
+type Folder struct {
+	ID      int64
+	Owner   int64
+	Parent  int64
+	Name    string
+	Created time.Time
+}
+
+// FolderContents are the folders and documents directly inside a folder.
+type FolderContents struct {
+	Folder    Folder
+	Folders   []Folder
+	Documents []Document
+}
+
+// Labels are the tags and key-value metadata of a document.
+type Labels struct {
+	Tags     []string
+	Metadata map[string]string
+}
+
+// Filter narrows a document listing to the documents that match all of its fields that are set.
+type Filter struct {
+	// Tags are tags the documents must all have.
+	Tags []string
+	// Metadata are properties the documents must have set to these values.
+	Metadata map[string]string
+	// MediaType is a media type, or a pattern such as "image/*", that the documents' media type must match.
+	MediaType string
+	// MinSize and MaxSize bound the documents' size in bytes.
+	MinSize *int64
+	MaxSize *int64
+	// NamePrefix is how the documents' file names must start.
+	NamePrefix string
+}
+
+// Sort is the order a document listing is in. Listings are in the order documents were created unless sorted
+// otherwise, and documents that sort the same are in the order they were created, or its reverse if the listing is
+// descending.
+type Sort string
+
+const (
+	SortCreated  Sort = ""
+	SortName     Sort = "name"
+	SortSize     Sort = "size"
+	SortVersion  Sort = "version"
+	SortModified Sort = "modified"
+)
+
+// Page selects the next Limit documents of a listing, after the document at After, or from the start if it is nil.
+type Page struct {
+	Limit      int
+	Sort       Sort
+	Descending bool
+	After      *Cursor
+}
+
+// Cursor is the position of a document in a listing, which is the document's ID and the value it is sorted by.
+type Cursor struct {
+	ID       int64
+	Name     string
+	Size     int64
+	Version  int64
+	Modified time.Time
+}
+
+// DocumentPage is a page of a document listing. NextCursor continues the listing from the end of the page, and is
+// empty on its last page.
+type DocumentPage struct {
+	Documents  []Document `json:"documents"`
+	NextCursor string     `json:"next_cursor"`
+}
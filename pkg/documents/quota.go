@@ -0,0 +1,133 @@
+package documents
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/internal/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/users"
+	"io"
+)
+
+var ErrFileTooLarge = errors.New("file is too large")
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// maxFileSize limits the size of each version stored, and defaultQuota the bytes stored by users without a quota of
+// their own. Either is unlimited when 0.
+var (
+	maxFileSize  int64
+	defaultQuota int64
+)
+
+// MaxFileSize returns the largest number of bytes a version may hold, or 0 if there is no limit.
+func MaxFileSize() int64 {
+	return maxFileSize
+}
+
+// Usage reports how much a user stores, counting every version of the documents they own.
+func Usage(ctx context.Context, dbc *sql.DB, userID int64) (models.Usage, error) {
+	used, files, err := db.GetUsage(ctx, dbc, userID)
+	if err != nil {
+		return models.Usage{}, err
+	}
+
+	usage := models.Usage{Used: used, Files: files}
+	quota, ok, err := quotaOf(ctx, dbc, userID)
+	if err != nil || !ok {
+		return usage, err
+	}
+
+	remaining := quota - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	usage.Quota, usage.Remaining = &quota, &remaining
+	return usage, nil
+}
+
+// SetQuota sets the number of bytes a user may store, which only administrators may do. A nil quota removes it, so
+// the default quota applies again.
+func SetQuota(ctx context.Context, dbc *sql.DB, adminID int64, userID int64, quota *int64) error {
+	admin, err := users.IsAdmin(ctx, dbc, adminID)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return fmt.Errorf("%w: only administrators can set quotas", access_control.ErrAccessDenied)
+	}
+	if quota != nil && *quota < 0 {
+		return fmt.Errorf("invalid quota: %d", *quota)
+	}
+	return users.SetQuota(ctx, dbc, userID, quota)
+}
+
+func quotaOf(ctx context.Context, dbc *sql.DB, userID int64) (int64, bool, error) {
+	quota, ok, err := users.GetQuota(ctx, dbc, userID)
+	if err != nil || ok {
+		return quota, ok, err
+	}
+	return defaultQuota, defaultQuota > 0, nil
+}
+
+// CheckSize fails with ErrFileTooLarge or ErrQuotaExceeded if a version of size bytes can't be stored for a
+// document the owner owns.
+func CheckSize(ctx context.Context, dbc *sql.DB, ownerID int64, size int64) error {
+	reader, err := limitUpload(ctx, dbc, ownerID, nil)
+	if err != nil {
+		return err
+	}
+	return reader.check(size)
+}
+
+// limitUpload wraps reader so that it fails once it has read more bytes than a version may hold or than are left of
+// the owner's quota. Uploads running at the same time can take a user over their quota by up to one file each.
+func limitUpload(ctx context.Context, dbc *sql.DB, ownerID int64, reader io.Reader) (*limitedReader, error) {
+	l := &limitedReader{reader: reader, maxFileSize: maxFileSize, quota: -1}
+
+	quota, ok, err := quotaOf(ctx, dbc, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		used, _, err := db.GetUsage(ctx, dbc, ownerID)
+		if err != nil {
+			return nil, err
+		}
+		l.quota = quota - used
+		if l.quota < 0 {
+			l.quota = 0
+		}
+	}
+	return l, nil
+}
+
+type limitedReader struct {
+	reader io.Reader
+	read   int64
+	// maxFileSize is 0 and quota is -1 when they don't limit the reader.
+	maxFileSize int64
+	quota       int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.reader.Read(p)
+	l.read += int64(n)
+	if checkErr := l.check(l.read); checkErr != nil {
+		return n, checkErr
+	}
+	return n, err
+}
+
+func (l *limitedReader) check(size int64) error {
+	if l.maxFileSize > 0 && size > l.maxFileSize {
+		return fmt.Errorf("%w: more than %d bytes", ErrFileTooLarge, l.maxFileSize)
+	}
+	if l.quota >= 0 && size > l.quota {
+		return fmt.Errorf("%w: %d bytes remaining", ErrQuotaExceeded, l.quota)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package documents
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
+	accessModels "github.com/EatonEmmerich/cloudStorage/pkg/access_control/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/db"
+	internalDB "github.com/EatonEmmerich/cloudStorage/pkg/documents/internal/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/users"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+	admin, err := users.Register(ctx, dbc, "admin", "password")
+	require.NoError(t, err)
+	_, err = dbc.ExecContext(ctx, "update users set `admin` = true where `id` = ?", admin)
+	require.NoError(t, err)
+
+	staged, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+
+	usage, err := Usage(ctx, dbc, john)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), usage.Used)
+	require.Nil(t, usage.Quota)
+
+	quota := int64(10)
+	require.True(t, errors.Is(SetQuota(ctx, dbc, john, john, &quota), access_control.ErrAccessDenied))
+	require.NoError(t, SetQuota(ctx, dbc, admin, john, &quota))
+
+	documentID, err := Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("123456"))), "", "")
+	require.NoError(t, err)
+
+	usage, err = Usage(ctx, dbc, john)
+	require.NoError(t, err)
+	require.Equal(t, int64(6), usage.Used)
+	require.Equal(t, int64(1), usage.Files)
+	require.Equal(t, int64(4), *usage.Remaining)
+
+	_, err = Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("12345"))), "", "")
+	require.True(t, errors.Is(err, ErrQuotaExceeded))
+
+	// Versions count towards the owner's quota, whoever uploads them.
+	doc, err := Get(ctx, dbc, int64(documentID))
+	require.NoError(t, err)
+	require.NoError(t, access_control.ShareDocument(ctx, dbc, doc, john, jane, accessModels.READ|accessModels.WRITE))
+	_, err = Update(ctx, dbc, int64(documentID), jane, io.NopCloser(bytes.NewReader([]byte("12345"))), "", "")
+	require.True(t, errors.Is(err, ErrQuotaExceeded))
+	_, err = Update(ctx, dbc, int64(documentID), jane, io.NopCloser(bytes.NewReader([]byte("1234"))), "", "")
+	require.NoError(t, err)
+
+	usage, err = Usage(ctx, dbc, john)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), usage.Used)
+	require.Equal(t, int64(0), *usage.Remaining)
+
+	// Nothing is left behind by the uploads that failed.
+	incomplete, err := internalDB.ListIncompleteDocuments(ctx, dbc)
+	require.NoError(t, err)
+	require.Empty(t, incomplete)
+	files, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	require.Len(t, files, len(staged))
+
+	require.NoError(t, SetQuota(ctx, dbc, admin, john, nil))
+	usage, err = Usage(ctx, dbc, john)
+	require.NoError(t, err)
+	require.Nil(t, usage.Quota)
+}
+
+func TestMaxFileSize(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	defer func(size int64) { maxFileSize = size }(maxFileSize)
+	maxFileSize = 4
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	_, err = Upload(ctx, dbc, userID, io.NopCloser(bytes.NewReader([]byte("12345"))), "", "")
+	require.True(t, errors.Is(err, ErrFileTooLarge))
+
+	_, err = Upload(ctx, dbc, userID, io.NopCloser(bytes.NewReader([]byte("1234"))), "", "")
+	require.NoError(t, err)
+
+	require.True(t, errors.Is(CheckSize(ctx, dbc, userID, 5), ErrFileTooLarge))
+	require.NoError(t, CheckSize(ctx, dbc, userID, 4))
+}
@@ -0,0 +1,265 @@
+package documents
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
+	models2 "github.com/EatonEmmerich/cloudStorage/pkg/access_control/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/internal/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retentionPolicy applies to documents whose owner has not set a policy of their own. Without it, every version is
+// kept forever.
+var retentionPolicy *models.RetentionPolicy
+
+var pruneInterval = time.Hour
+
+// maxKeepDays is the most days a policy can keep versions for, which is longer than anyone would want to keep them.
+const maxKeepDays = 100 * 366
+
+// ParseRetentionPolicy parses a policy written as "forever", or as comma separated rules out of "last=N", "days=N"
+// and "daily". For example "last=10" keeps the last 10 versions and "days=30,daily" keeps every version for 30 days
+// and one version a day after that.
+func ParseRetentionPolicy(s string) (models.RetentionPolicy, error) {
+	var policy models.RetentionPolicy
+	for _, rule := range strings.Split(s, ",") {
+		rule = strings.TrimSpace(rule)
+		switch {
+		case rule == "forever":
+			policy.KeepForever = true
+		case rule == "daily":
+			policy.DailySnapshots = true
+		case strings.HasPrefix(rule, "last="):
+			n, err := strconv.ParseInt(strings.TrimPrefix(rule, "last="), 10, 64)
+			if err != nil || n < 1 {
+				return models.RetentionPolicy{}, fmt.Errorf("invalid retention rule: %q", rule)
+			}
+			policy.KeepLast = n
+		case strings.HasPrefix(rule, "days="):
+			n, err := strconv.ParseInt(strings.TrimPrefix(rule, "days="), 10, 64)
+			if err != nil || n < 1 || n > maxKeepDays {
+				return models.RetentionPolicy{}, fmt.Errorf("invalid retention rule: %q", rule)
+			}
+			policy.KeepDays = n
+		default:
+			return models.RetentionPolicy{}, fmt.Errorf("invalid retention rule: %q", rule)
+		}
+	}
+	return policy, nil
+}
+
+// SetRetentionPolicy sets the retention policy of a document, which only its owner may do. A nil policy removes it,
+// so the owner's policy applies again.
+func SetRetentionPolicy(ctx context.Context, dbc *sql.DB, documentID int64, userID int64, policy *models.RetentionPolicy) error {
+	doc, err := Get(ctx, dbc, documentID)
+	if err != nil {
+		return err
+	}
+	if doc.Owner != userID {
+		err = access_control.Log(ctx, dbc, userID, documentID, "Retention policy - Unauthorised")
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w", access_control.ErrAccessDenied)
+	}
+
+	if policy == nil {
+		err = db.DeleteDocumentRetentionPolicy(ctx, dbc, documentID)
+	} else {
+		err = db.SetDocumentRetentionPolicy(ctx, dbc, documentID, db.RetentionPolicy(*policy))
+	}
+	if err != nil {
+		return err
+	}
+	return access_control.Log(ctx, dbc, userID, documentID, "Retention policy - Authorised")
+}
+
+// SetUserRetentionPolicy sets the retention policy of all documents a user owns that have no policy of their own. A
+// nil policy removes it, so the global policy applies again.
+func SetUserRetentionPolicy(ctx context.Context, dbc *sql.DB, userID int64, policy *models.RetentionPolicy) error {
+	if policy == nil {
+		return db.DeleteUserRetentionPolicy(ctx, dbc, userID)
+	}
+	return db.SetUserRetentionPolicy(ctx, dbc, userID, db.RetentionPolicy(*policy))
+}
+
+// GetRetentionPolicy returns the retention policy that applies to a document.
+func GetRetentionPolicy(ctx context.Context, dbc *sql.DB, documentID int64, userID int64) (models.RetentionPolicy, error) {
+	doc, err := Get(ctx, dbc, documentID)
+	if err != nil {
+		return models.RetentionPolicy{}, err
+	}
+
+	err = access_control.AuthoriseOrError(ctx, dbc, userID, doc, models2.READ)
+	if err != nil {
+		return models.RetentionPolicy{}, err
+	}
+	return effectiveRetentionPolicy(ctx, dbc, doc.ID, doc.Owner)
+}
+
+func effectiveRetentionPolicy(ctx context.Context, dbc db.DBTransaction, documentID int64, ownerID int64) (models.RetentionPolicy, error) {
+	policy, ok, err := db.GetRetentionPolicy(ctx, dbc, documentID, ownerID)
+	if err != nil {
+		return models.RetentionPolicy{}, err
+	}
+	if ok {
+		return models.RetentionPolicy(policy), nil
+	}
+	if retentionPolicy != nil {
+		return *retentionPolicy, nil
+	}
+	return models.RetentionPolicy{KeepForever: true}, nil
+}
+
+// expiredVersions returns the versions, ordered newest first, that policy does not keep.
+func expiredVersions(policy models.RetentionPolicy, versions []db.Version, current int64, now time.Time) []db.Version {
+	if policy.KeepForever {
+		return nil
+	}
+
+	var expired []db.Version
+	days := make(map[string]bool)
+	for i, v := range versions {
+		day := v.Created.UTC().Format("2006-01-02")
+		newestOfDay := !days[day]
+		days[day] = true
+
+		switch {
+		case v.Version == current:
+		case int64(i) < policy.KeepLast:
+		case policy.KeepDays > 0 && v.Created.After(now.AddDate(0, 0, -int(policy.KeepDays))):
+		case policy.DailySnapshots && newestOfDay:
+		default:
+			expired = append(expired, v)
+		}
+	}
+	return expired
+}
+
+// Prune deletes the versions of documents that their retention policy no longer keeps, along with any contents no
+// other version references. It returns the number of versions deleted.
+func Prune(ctx context.Context, dbc *sql.DB, now time.Time) (int, error) {
+	documents, err := db.ListDocumentsWithHistory(ctx, dbc)
+	if err != nil {
+		return 0, err
+	}
+
+	var pruned int
+	for _, doc := range documents {
+		policy, err := effectiveRetentionPolicy(ctx, dbc, doc.ID, doc.Owner)
+		if err != nil {
+			return pruned, err
+		}
+		if policy.KeepForever {
+			continue
+		}
+
+		versions, err := db.ListVersions(ctx, dbc, doc.ID)
+		if err != nil {
+			return pruned, err
+		}
+
+		for _, v := range expiredVersions(policy, versions, doc.Version, now) {
+			ok, err := pruneVersion(ctx, dbc, v)
+			if err != nil {
+				return pruned, err
+			}
+			if ok {
+				pruned++
+			}
+		}
+	}
+	return pruned, nil
+}
+
+// pruneVersion deletes a version and releases its contents. It reports whether the version was deleted, which it is not
+// if it, or its document, has been deleted since it was listed.
+func pruneVersion(ctx context.Context, dbc *sql.DB, v db.Version) (bool, error) {
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Locking the document keeps its versions from being copied while their contents are released.
+	_, err = db.GetForUpdate(ctx, tx, v.Document)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	_, err = db.GetVersion(ctx, tx, v.Document, v.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	err = db.DeleteVersion(ctx, tx, v.Document, v.Version)
+	if err != nil {
+		return false, err
+	}
+
+	freedKeys, err := releaseContents(ctx, tx, v.Document, v.Version, v.Path)
+	if err != nil {
+		return false, err
+	}
+
+	// Contents stored before deduplication are not reference counted, but may have been restored as a later version.
+	if !strings.HasPrefix(v.Path, blobKeyPrefix) && !strings.HasPrefix(v.Path, manifestKeyPrefix) {
+		n, err := db.CountPathReferences(ctx, tx, v.Path)
+		if err != nil {
+			return false, err
+		}
+		if n == 0 {
+			freedKeys = append(freedKeys, v.Path)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	err = deleteBlobs(ctx, dbc, freedKeys)
+	if err != nil {
+		return true, err
+	}
+	return true, access_control.Log(ctx, dbc, 0, v.Document, fmt.Sprintf("Prune - Version:%d - Retention policy", v.Version))
+}
+
+// RunPruner prunes expired versions, and empties the trash of documents deleted more than trashDays ago, every
+// pruneInterval until ctx is done.
+func RunPruner(ctx context.Context, dbc *sql.DB) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := Prune(ctx, dbc, time.Now())
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Default().Println("pruning versions:", err)
+		} else if n > 0 {
+			log.Default().Printf("pruned %d versions\n", n)
+		}
+
+		n, err = EmptyTrash(ctx, dbc, time.Now())
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Default().Println("emptying trash:", err)
+		} else if n > 0 {
+			log.Default().Printf("purged %d documents from the trash\n", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package documents
+
+import (
+	"bytes"
+	"context"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
+	"github.com/EatonEmmerich/cloudStorage/pkg/db"
+	internalDB "github.com/EatonEmmerich/cloudStorage/pkg/documents/internal/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/storage"
+	"github.com/EatonEmmerich/cloudStorage/pkg/users"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestParseRetentionPolicy(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected models.RetentionPolicy
+		wantErr  bool
+	}{
+		{in: "forever", expected: models.RetentionPolicy{KeepForever: true}},
+		{in: "last=10", expected: models.RetentionPolicy{KeepLast: 10}},
+		{in: "days=30, daily", expected: models.RetentionPolicy{KeepDays: 30, DailySnapshots: true}},
+		{in: "", wantErr: true},
+		{in: "last=0", wantErr: true},
+		{in: "days=109500", wantErr: true},
+		{in: "weekly", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			policy, err := ParseRetentionPolicy(tt.in)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, policy)
+		})
+	}
+}
+
+func TestExpiredVersions(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	// Two versions a day for the last 5 days, newest first.
+	var versions []internalDB.Version
+	for i := 0; i < 10; i++ {
+		versions = append(versions, internalDB.Version{Version: int64(10 - i), Created: now.Add(-time.Duration(i) * 12 * time.Hour)})
+	}
+
+	expired := func(policy models.RetentionPolicy) []int64 {
+		var numbers []int64
+		for _, v := range expiredVersions(policy, versions, 10, now) {
+			numbers = append(numbers, v.Version)
+		}
+		return numbers
+	}
+
+	require.Empty(t, expired(models.RetentionPolicy{KeepForever: true}))
+	require.Equal(t, []int64{7, 6, 5, 4, 3, 2, 1}, expired(models.RetentionPolicy{KeepLast: 3}))
+	require.Equal(t, []int64{6, 5, 4, 3, 2, 1}, expired(models.RetentionPolicy{KeepDays: 2}))
+	require.Equal(t, []int64{5, 3, 1}, expired(models.RetentionPolicy{KeepDays: 2, DailySnapshots: true}))
+	// Policies that keep versions for longer than a Duration can hold keep every version.
+	require.Empty(t, expired(models.RetentionPolicy{KeepDays: 109500}))
+	// The current version is always kept.
+	require.Equal(t, []int64{9, 8, 7, 6, 5, 4, 3, 2, 1}, expired(models.RetentionPolicy{}))
+}
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	memory := storage.NewMemory()
+	SetBlobStore(memory)
+	t.Cleanup(func() { SetBlobStore(storage.NewFileSystem(workPath)) })
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+
+	var documentIDs []int64
+	for _, owner := range []int64{john, jane} {
+		documentID, err := Upload(ctx, dbc, owner, io.NopCloser(bytes.NewReader([]byte("v1"))), "text/plain", "a.txt")
+		require.NoError(t, err)
+		for _, contents := range []string{"v2", "v3", "v1"} {
+			_, err = Update(ctx, dbc, int64(documentID), owner, io.NopCloser(bytes.NewReader([]byte(contents))), "text/plain", "a.txt")
+			require.NoError(t, err)
+		}
+		documentIDs = append(documentIDs, int64(documentID))
+	}
+
+	// Without any policy, everything is kept.
+	n, err := Prune(ctx, dbc, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	require.NoError(t, SetUserRetentionPolicy(ctx, dbc, john, &models.RetentionPolicy{KeepLast: 2}))
+	require.ErrorIs(t, SetRetentionPolicy(ctx, dbc, documentIDs[1], john, &models.RetentionPolicy{KeepLast: 1}), access_control.ErrAccessDenied)
+
+	n, err = Prune(ctx, dbc, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	versions, err := ListVersions(ctx, dbc, documentIDs[0], john)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.Equal(t, int64(4), versions[0].Version)
+	require.Equal(t, int64(3), versions[1].Version)
+
+	// v2 is no longer referenced by any version, v1 still is.
+	blobs, err := memory.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, blobs, 3)
+
+	var logged int
+	require.NoError(t, dbc.QueryRow("select count(*) from `audit_log` where `user` is null and `document` = ? and `action` like 'Prune%'", documentIDs[0]).Scan(&logged))
+	require.Equal(t, 2, logged)
+
+	// A document's own policy takes precedence over its owner's.
+	require.NoError(t, SetRetentionPolicy(ctx, dbc, documentIDs[1], jane, &models.RetentionPolicy{KeepLast: 1}))
+	policy, err := GetRetentionPolicy(ctx, dbc, documentIDs[1], jane)
+	require.NoError(t, err)
+	require.Equal(t, models.RetentionPolicy{KeepLast: 1}, policy)
+	stale, err := internalDB.ListVersions(ctx, dbc, documentIDs[1])
+	require.NoError(t, err)
+	n, err = Prune(ctx, dbc, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+
+	// A version pruned since it was listed, such as by another pruner, is skipped rather than released again.
+	pruned, err := pruneVersion(ctx, dbc, stale[len(stale)-1])
+	require.NoError(t, err)
+	require.False(t, pruned)
+
+	blobs, err = memory.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, blobs, 2)
+
+	issues, err := Fsck(ctx, dbc, false, true)
+	require.NoError(t, err)
+	require.Empty(t, issues)
+}
@@ -0,0 +1,75 @@
+package documents
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/internal/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/scanner"
+	"log"
+	"os"
+)
+
+var ErrQuarantined = errors.New("document has not passed a malware scan")
+
+// malwareScanner scans every new version before anyone but its owner can download it. Versions are not scanned if it
+// is nil.
+var malwareScanner scanner.Scanner
+
+// SetScanner swaps the scanner that new versions are scanned with, or stops scanning them if it is nil.
+func SetScanner(s scanner.Scanner) {
+	malwareScanner = s
+}
+
+// initialScanState is the state a new version is stored in, before it is scanned.
+func initialScanState() models.ScanState {
+	if malwareScanner == nil {
+		return models.ScanUnscanned
+	}
+	return models.ScanPending
+}
+
+// scanVersion scans the staged contents of a stored version and records the outcome. Infected versions and versions
+// that could not be scanned are quarantined, which is recorded in the audit log. It returns the version's scan state.
+func scanVersion(ctx context.Context, dbc *sql.DB, temp tempFile, documentID int64, version int64, userID int64) (models.ScanState, error) {
+	if malwareScanner == nil {
+		return models.ScanUnscanned, nil
+	}
+
+	file, err := os.Open(temp.path)
+	if err != nil {
+		return models.ScanPending, err
+	}
+	defer file.Close()
+
+	state, action := models.ScanClean, ""
+	result, err := malwareScanner.Scan(ctx, file)
+	if err != nil {
+		log.Default().Printf("scanning version %d of document %d: %v\n", version, documentID, err)
+		state, action = models.ScanError, fmt.Sprintf("Quarantine - Version:%d - Scan failed", version)
+	} else if result.Infected {
+		state, action = models.ScanInfected, fmt.Sprintf("Quarantine - Version:%d - Signature:%s", version, result.Signature)
+	}
+
+	err = db.SetScanResult(ctx, dbc, documentID, version, string(state), result.Signature)
+	if err != nil {
+		return models.ScanPending, err
+	}
+
+	if action == "" {
+		return state, nil
+	}
+	return state, access_control.Log(ctx, dbc, userID, documentID, action)
+}
+
+// checkScanState refuses versions that have not been found clean to everyone but the document's owner. Versions that
+// were stored while no scanner was configured are served to everyone.
+func checkScanState(doc models.Document, state models.ScanState, userID int64) error {
+	if userID == doc.Owner || state == models.ScanUnscanned || state == models.ScanClean {
+		return nil
+	}
+	return fmt.Errorf("%w: document %d is %s", ErrQuarantined, doc.ID, state)
+}
@@ -0,0 +1,145 @@
+package documents
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
+	accessModels "github.com/EatonEmmerich/cloudStorage/pkg/access_control/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/scanner"
+	"github.com/EatonEmmerich/cloudStorage/pkg/users"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// fakeScanner finds contents containing "virus", and fails to scan contents containing "unscannable".
+type fakeScanner struct{}
+
+func (fakeScanner) Scan(_ context.Context, reader io.Reader) (scanner.Result, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return scanner.Result{}, err
+	}
+	switch {
+	case strings.Contains(string(data), "unscannable"):
+		return scanner.Result{}, errors.New("scanner unavailable")
+	case strings.Contains(string(data), "virus"):
+		return scanner.Result{Infected: true, Signature: "Fake.Virus"}, nil
+	}
+	return scanner.Result{}, nil
+}
+
+func TestScanning(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+
+	// Versions stored before scanning was set up are served to everyone.
+	documentID, err := Upload(ctx, dbc, john, io.NopCloser(strings.NewReader("virus")), "text/plain", "file.txt")
+	require.NoError(t, err)
+	doc, err := Get(ctx, dbc, int64(documentID))
+	require.NoError(t, err)
+	require.NoError(t, access_control.ShareDocument(ctx, dbc, doc, john, jane, accessModels.READ))
+
+	SetScanner(fakeScanner{})
+	t.Cleanup(func() { SetScanner(nil) })
+
+	open := func(userID int64) (models.Document, error) {
+		doc, reader, err := OpenDocument(ctx, dbc, int64(documentID), userID)
+		if err != nil {
+			return models.Document{}, err
+		}
+		return doc, reader.Close()
+	}
+	opened, err := open(jane)
+	require.NoError(t, err)
+	require.Equal(t, models.ScanUnscanned, opened.ScanState)
+
+	update := func(contents string) int64 {
+		version, err := Update(ctx, dbc, int64(documentID), john, io.NopCloser(bytes.NewReader([]byte(contents))), "text/plain", "file.txt")
+		require.NoError(t, err)
+		return version
+	}
+
+	update("clean")
+	opened, err = open(jane)
+	require.NoError(t, err)
+	require.Equal(t, models.ScanClean, opened.ScanState)
+
+	infected := update("a virus")
+	_, err = open(jane)
+	require.True(t, errors.Is(err, ErrQuarantined))
+	opened, err = open(john)
+	require.NoError(t, err)
+	require.Equal(t, models.ScanInfected, opened.ScanState)
+
+	update("unscannable")
+	_, err = open(jane)
+	require.True(t, errors.Is(err, ErrQuarantined))
+
+	// Restoring a quarantined version keeps it quarantined.
+	_, err = Restore(ctx, dbc, int64(documentID), infected, john)
+	require.NoError(t, err)
+	_, err = open(jane)
+	require.True(t, errors.Is(err, ErrQuarantined))
+
+	versions, err := ListVersions(ctx, dbc, int64(documentID), john)
+	require.NoError(t, err)
+	require.Equal(t, models.ScanInfected, versions[0].ScanState)
+	require.Equal(t, "Fake.Virus", versions[0].ScanSignature)
+	require.Equal(t, models.ScanError, versions[1].ScanState)
+
+	// Versions that were interrupted before being scanned stay pending.
+	_, err = dbc.ExecContext(ctx, "update `document_versions` set `scan_state` = ? where `document` = ? and `version` = ?",
+		models.ScanPending, documentID, versions[0].Version)
+	require.NoError(t, err)
+	_, err = open(jane)
+	require.True(t, errors.Is(err, ErrQuarantined))
+
+	var quarantines int
+	err = dbc.QueryRowContext(ctx, "select count(*) from `audit_log` where `document` = ? and `action` like 'Quarantine%'", documentID).Scan(&quarantines)
+	require.NoError(t, err)
+	require.Equal(t, 2, quarantines)
+}
+
+// cancellingScanner finds every version clean, but cancels the upload before the result can be recorded.
+type cancellingScanner struct {
+	cancel context.CancelFunc
+}
+
+func (s cancellingScanner) Scan(context.Context, io.Reader) (scanner.Result, error) {
+	s.cancel()
+	return scanner.Result{}, nil
+}
+
+func TestScanning_RecordFails(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	uploadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	SetScanner(cancellingScanner{cancel: cancel})
+	t.Cleanup(func() { SetScanner(nil) })
+
+	// The version is stored before it is scanned, so the upload succeeds and the version stays pending.
+	documentID, err := Upload(uploadCtx, dbc, john, io.NopCloser(strings.NewReader("contents")), "text/plain", "file.txt")
+	require.NoError(t, err)
+	require.NotZero(t, documentID)
+
+	versions, err := ListVersions(ctx, dbc, int64(documentID), john)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	require.Equal(t, models.ScanPending, versions[0].ScanState)
+}
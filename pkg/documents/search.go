@@ -0,0 +1,105 @@
+package documents
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/search"
+	"io"
+	"log"
+	"strings"
+)
+
+// Search returns the documents the user can read, their own and those shared with them, that contain every term in a
+// query in their name, tags or contents, best matches first. It returns at most limit documents, or
+// search.MaxResults if limit is 0.
+func Search(ctx context.Context, dbc *sql.DB, userID int64, query string, limit int) ([]models.Document, error) {
+	results, err := search.Search(ctx, dbc, userID, query, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	documents := []models.Document{}
+	for _, result := range results {
+		doc, err := Get(ctx, dbc, result.Document)
+		if errors.Is(err, ErrNotFound) {
+			// Deleted since it was found.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		documents = append(documents, doc)
+	}
+
+	err = attachLabels(ctx, dbc, documents)
+	if err != nil {
+		return nil, err
+	}
+	return documents, attachLocks(ctx, dbc, documents)
+}
+
+// The search index is kept up to date once changes are committed, so a change that fails to be indexed still stands,
+// and is only logged.
+
+func indexName(ctx context.Context, dbc *sql.DB, documentID int64, fileName string) {
+	err := search.Index(ctx, dbc, documentID, search.FieldName, fileName)
+	if err != nil {
+		log.Default().Printf("indexing the name of document %d: %v\n", documentID, err)
+	}
+}
+
+func indexTags(ctx context.Context, dbc *sql.DB, documentID int64, tags []string) {
+	err := search.Index(ctx, dbc, documentID, search.FieldTags, strings.Join(tags, " "))
+	if err != nil {
+		log.Default().Printf("indexing the tags of document %d: %v\n", documentID, err)
+	}
+}
+
+// indexContents indexes the text of a document's current contents. Contents that text can't be extracted from, or
+// that have not passed a malware scan, are left out of the index.
+func indexContents(ctx context.Context, dbc *sql.DB, documentID int64, claimed string, detected string, state models.ScanState,
+	open func() (io.ReadCloser, error)) {
+	var text string
+	mediaType, ok := textMediaType(claimed, detected)
+	if ok && (state == models.ScanUnscanned || state == models.ScanClean) {
+		contents, err := open()
+		if err != nil {
+			log.Default().Printf("indexing the contents of document %d: %v\n", documentID, err)
+			return
+		}
+		defer contents.Close()
+
+		text, err = search.Extract(mediaType, contents)
+		if err != nil {
+			log.Default().Printf("indexing the contents of document %d: %v\n", documentID, err)
+			return
+		}
+	}
+
+	err := search.Index(ctx, dbc, documentID, search.FieldText, text)
+	if err != nil {
+		log.Default().Printf("indexing the contents of document %d: %v\n", documentID, err)
+	}
+}
+
+// indexCopiedContents indexes the text of a copy of a document's current contents, which is indexed already.
+func indexCopiedContents(ctx context.Context, dbc *sql.DB, fromDocumentID int64, documentID int64) {
+	err := search.CopyIndex(ctx, dbc, fromDocumentID, documentID, search.FieldText)
+	if err != nil {
+		log.Default().Printf("indexing the contents of document %d: %v\n", documentID, err)
+	}
+}
+
+// textMediaType picks the media type to extract text from contents as. The detected media type is sniffed from the
+// contents, so contents it finds are not text are not indexed whatever the uploader claimed, while the claimed type
+// tells text formats like JSON and CSV apart, which are all detected as plain text.
+func textMediaType(claimed string, detected string) (string, bool) {
+	if detected != "" && !search.Extractable(detected) {
+		return "", false
+	}
+	if search.Extractable(claimed) {
+		return claimed, true
+	}
+	return detected, detected != ""
+}
@@ -0,0 +1,99 @@
+package documents
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
+	accessModels "github.com/EatonEmmerich/cloudStorage/pkg/access_control/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/search"
+	"github.com/EatonEmmerich/cloudStorage/pkg/users"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+	carl, err := users.Register(ctx, dbc, "carl", "password")
+	require.NoError(t, err)
+
+	upload := func(userID int64, contents string, mediaType string, name string) int64 {
+		id, err := Upload(ctx, dbc, userID, io.NopCloser(bytes.NewReader([]byte(contents))), mediaType, name)
+		require.NoError(t, err)
+		return int64(id)
+	}
+	find := func(userID int64, query string) []int64 {
+		found, err := Search(ctx, dbc, userID, query, 0)
+		require.NoError(t, err)
+		ids := []int64{}
+		for _, doc := range found {
+			ids = append(ids, doc.ID)
+		}
+		return ids
+	}
+
+	notesID := upload(john, "Meeting notes about the acme contract", "text/markdown", "notes.md")
+	invoiceID := upload(john, `{"client": "acme", "total": 1200}`, "application/json", "invoice.json")
+	contractID := upload(john, "signed,contract\nacme,2024\n", "text/csv", "acme.csv")
+	imageID := upload(john, "\x89PNG\r\n\x1a\nacme", "text/plain", "logo.png")
+	janeID := upload(jane, "acme", "text/plain", "mine.txt")
+
+	// Matches in names outrank matches in contents, and documents that are not text are only found by name.
+	require.Equal(t, []int64{contractID, invoiceID, notesID}, find(john, "acme"))
+	require.Equal(t, []int64{notesID}, find(john, "ACME contract notes"))
+	require.Equal(t, []int64{imageID}, find(john, "logo"))
+	require.Empty(t, find(john, "acme missing"))
+	_, err = Search(ctx, dbc, john, "  ", 0)
+	require.True(t, errors.Is(err, search.ErrEmptyQuery))
+
+	// Shared documents are found by their grantees only.
+	require.Equal(t, []int64{janeID}, find(jane, "acme"))
+	doc, err := Get(ctx, dbc, invoiceID)
+	require.NoError(t, err)
+	require.NoError(t, access_control.ShareDocument(ctx, dbc, doc, john, jane, accessModels.READ))
+	require.ElementsMatch(t, []int64{invoiceID, janeID}, find(jane, "acme"))
+	require.Empty(t, find(carl, "acme"))
+
+	// Updates, renames, tags and copies are indexed as they are committed.
+	_, err = Update(ctx, dbc, notesID, john, io.NopCloser(bytes.NewReader([]byte("Agenda for the board"))), "text/markdown", "notes.md")
+	require.NoError(t, err)
+	require.Empty(t, find(john, "contract notes"))
+	require.Equal(t, []int64{notesID}, find(john, "board"))
+
+	require.NoError(t, Rename(ctx, dbc, notesID, john, "agenda.md"))
+	require.Empty(t, find(john, "notes"))
+	require.NoError(t, SetTags(ctx, dbc, notesID, john, []string{"quarterly-review"}))
+	require.Equal(t, []int64{notesID}, find(john, "quarterly"))
+
+	copyID, err := Copy(ctx, dbc, invoiceID, jane, 0, "copy.json")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []int64{invoiceID, copyID}, find(jane, "1200"))
+
+	_, err = Restore(ctx, dbc, notesID, 1, john)
+	require.NoError(t, err)
+	require.Equal(t, []int64{notesID}, find(john, "contract notes"))
+
+	// Documents in the trash are not found.
+	require.NoError(t, Delete(ctx, dbc, invoiceID, john))
+	require.Equal(t, []int64{copyID}, find(jane, "1200"))
+
+	// Contents that have not passed a malware scan are not indexed.
+	SetScanner(fakeScanner{})
+	t.Cleanup(func() { SetScanner(nil) })
+	infectedID := upload(john, "virus in the acme files", "text/plain", "infected.txt")
+	require.Empty(t, find(john, "virus"))
+	require.Equal(t, []int64{infectedID}, find(john, "infected"))
+
+	found, err := Search(ctx, dbc, john, "acme", 1)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	require.Equal(t, contractID, found[0].ID)
+}
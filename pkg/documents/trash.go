@@ -0,0 +1,228 @@
+package documents
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/internal/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"strings"
+	"time"
+)
+
+// Documents are purged once they have been in the trash for trashDays, or kept there until they are purged or restored
+// if it is 0.
+var trashDays = 30
+
+// Delete moves a document of the user's to their trash. Documents in the trash can't be opened, updated or shared, and
+// are left out of document listings, but count towards their owner's quota until they are purged.
+func Delete(ctx context.Context, dbc *sql.DB, documentID int64, userID int64) error {
+	doc, err := Get(ctx, dbc, documentID)
+	if err != nil {
+		return err
+	}
+	if doc.Owner != userID {
+		return fmt.Errorf("%w: only the owner can delete a document", access_control.ErrAccessDenied)
+	}
+
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = db.GetForUpdate(ctx, tx, documentID)
+	if err != nil {
+		return err
+	}
+
+	err = checkLock(ctx, tx, documentID, userID)
+	if err != nil {
+		return err
+	}
+
+	err = db.SetDeleted(ctx, tx, documentID, sql.NullTime{Time: time.Now(), Valid: true})
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	return access_control.Log(ctx, dbc, userID, documentID, "Delete - Moved to trash")
+}
+
+// ListTrash returns the documents in the user's trash, most recently deleted first.
+func ListTrash(ctx context.Context, dbc *sql.DB, userID int64) ([]models.Document, error) {
+	dbDocuments, err := db.ListTrash(ctx, dbc, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	documents := []models.Document{}
+	for _, doc := range dbDocuments {
+		documents = append(documents, documentModel(doc))
+	}
+	return documents, nil
+}
+
+// RestoreDeleted moves a document out of the user's trash, back into the folder it was deleted from.
+func RestoreDeleted(ctx context.Context, dbc *sql.DB, documentID int64, userID int64) error {
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	doc, err := trashed(ctx, tx, documentID, userID)
+	if err != nil {
+		return err
+	}
+
+	// The document's name may have been taken in its folder while it was in the trash.
+	if doc.Folder.Valid {
+		_, err = ownFolder(ctx, tx, doc.Folder.Int64, userID, db.GetFolderForUpdate)
+		if err != nil {
+			return err
+		}
+	}
+	err = checkDocumentNameFree(ctx, tx, userID, doc.Folder, doc.FileName)
+	if err != nil {
+		return err
+	}
+
+	err = db.SetDeleted(ctx, tx, documentID, sql.NullTime{})
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	return access_control.Log(ctx, dbc, userID, documentID, "Restore - From trash")
+}
+
+// Purge permanently deletes a document in the user's trash, with every version of it and any contents no other
+// document references.
+func Purge(ctx context.Context, dbc *sql.DB, documentID int64, userID int64) error {
+	_, err := trashed(ctx, dbc, documentID, userID)
+	if err != nil {
+		return err
+	}
+	return purge(ctx, dbc, documentID, userID)
+}
+
+// EmptyTrash purges the documents that were moved to the trash more than trashDays before now, and returns how many
+// were purged.
+func EmptyTrash(ctx context.Context, dbc *sql.DB, now time.Time) (int, error) {
+	if trashDays == 0 {
+		return 0, nil
+	}
+
+	documents, err := db.ListDeletedBefore(ctx, dbc, now.Add(-time.Duration(trashDays)*24*time.Hour))
+	if err != nil {
+		return 0, err
+	}
+
+	for i, doc := range documents {
+		err = purge(ctx, dbc, doc.ID, 0)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(documents), nil
+}
+
+// trashed reads a document in the user's trash, as if it does not exist if it is not.
+func trashed(ctx context.Context, dbc db.DBTransaction, documentID int64, userID int64) (db.Document, error) {
+	doc, err := db.GetForUpdate(ctx, dbc, documentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return db.Document{}, fmt.Errorf("%w: %d", ErrNotFound, documentID)
+	} else if err != nil {
+		return db.Document{}, err
+	}
+	if doc.Owner != userID || !doc.Deleted.Valid {
+		return db.Document{}, fmt.Errorf("%w: %d is not in the trash", ErrNotFound, documentID)
+	}
+	return doc, nil
+}
+
+// purge deletes a document in the trash. The userID purging it is 0 when the trash is emptied automatically.
+func purge(ctx context.Context, dbc *sql.DB, documentID int64, userID int64) error {
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	doc, err := db.GetForUpdate(ctx, tx, documentID)
+	if err != nil {
+		return err
+	}
+	if !doc.Deleted.Valid {
+		return fmt.Errorf("%w: %d is not in the trash", ErrNotFound, documentID)
+	}
+
+	versions, err := db.ListVersions(ctx, tx, documentID)
+	if err != nil {
+		return err
+	}
+	// The current version of a document stored before versions were recorded has no row of its own.
+	unversioned := len(versions) == 0 && doc.Version > 0
+	if unversioned {
+		versions = append(versions, versionOf(doc, sql.NullInt64{}, time.Time{}))
+	}
+
+	var freedKeys []string
+	legacyPaths := make(map[string]bool)
+	for _, v := range versions {
+		if !unversioned {
+			err = db.DeleteVersion(ctx, tx, v.Document, v.Version)
+			if err != nil {
+				return err
+			}
+		}
+
+		freed, err := releaseContents(ctx, tx, v.Document, v.Version, v.Path)
+		if err != nil {
+			return err
+		}
+		freedKeys = append(freedKeys, freed...)
+
+		if !strings.HasPrefix(v.Path, blobKeyPrefix) && !strings.HasPrefix(v.Path, manifestKeyPrefix) {
+			legacyPaths[v.Path] = true
+		}
+	}
+
+	// Contents stored before deduplication are not reference counted, but may have been copied to another document.
+	for path := range legacyPaths {
+		n, err := db.CountPathReferences(ctx, tx, path)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			freedKeys = append(freedKeys, path)
+		}
+	}
+
+	err = db.DeleteDocument(ctx, tx, documentID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	err = deleteBlobs(ctx, dbc, freedKeys)
+	if err != nil {
+		return err
+	}
+	// The document no longer exists for the entry to refer to.
+	return access_control.Log(ctx, dbc, userID, 0, fmt.Sprintf("Purge - Document:%d - File name:%s", documentID, doc.FileName))
+}
@@ -0,0 +1,128 @@
+package documents
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
+	accessModels "github.com/EatonEmmerich/cloudStorage/pkg/access_control/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/storage"
+	"github.com/EatonEmmerich/cloudStorage/pkg/users"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	memory := storage.NewMemory()
+	SetBlobStore(memory)
+	t.Cleanup(func() { SetBlobStore(storage.NewFileSystem(workPath)) })
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+
+	folder, err := CreateFolder(ctx, dbc, john, 0, "reports")
+	require.NoError(t, err)
+	documentID, err := Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("first"))), "text/plain", "report.txt")
+	require.NoError(t, err)
+	_, err = Update(ctx, dbc, int64(documentID), john, io.NopCloser(bytes.NewReader([]byte("second"))), "text/plain", "report.txt")
+	require.NoError(t, err)
+	require.NoError(t, MoveDocument(ctx, dbc, int64(documentID), folder.ID, john))
+	doc, err := Get(ctx, dbc, int64(documentID))
+	require.NoError(t, err)
+	require.NoError(t, access_control.ShareDocument(ctx, dbc, doc, john, jane, accessModels.READ))
+
+	require.True(t, errors.Is(Delete(ctx, dbc, int64(documentID), jane), access_control.ErrAccessDenied))
+	require.NoError(t, Delete(ctx, dbc, int64(documentID), john))
+
+	_, _, err = OpenDocument(ctx, dbc, int64(documentID), john)
+	require.True(t, errors.Is(err, ErrNotFound))
+	_, err = Update(ctx, dbc, int64(documentID), john, io.NopCloser(bytes.NewReader([]byte("third"))), "text/plain", "report.txt")
+	require.True(t, errors.Is(err, ErrNotFound))
+	listed, err := ListDocuments(ctx, dbc, john, models.Filter{}, firstPage)
+	require.NoError(t, err)
+	require.Empty(t, listed.Documents)
+	shared, err := ListSharedDocuments(ctx, dbc, jane, models.Filter{}, firstPage)
+	require.NoError(t, err)
+	require.Empty(t, shared.Documents)
+
+	trash, err := ListTrash(ctx, dbc, john)
+	require.NoError(t, err)
+	require.Len(t, trash, 1)
+	require.NotNil(t, trash[0].Deleted)
+	trash, err = ListTrash(ctx, dbc, jane)
+	require.NoError(t, err)
+	require.Empty(t, trash)
+
+	// The name is free while the document is in the trash, so it can't be restored into the folder while it is taken.
+	otherID, err := Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("other"))), "text/plain", "report.txt")
+	require.NoError(t, err)
+	require.NoError(t, MoveDocument(ctx, dbc, int64(otherID), folder.ID, john))
+	require.True(t, errors.Is(RestoreDeleted(ctx, dbc, int64(documentID), john), ErrNameTaken))
+	require.NoError(t, MoveDocument(ctx, dbc, int64(otherID), 0, john))
+
+	require.True(t, errors.Is(RestoreDeleted(ctx, dbc, int64(documentID), jane), ErrNotFound))
+	require.NoError(t, RestoreDeleted(ctx, dbc, int64(documentID), john))
+	_, id, err := ResolvePath(ctx, dbc, john, "reports/report.txt")
+	require.NoError(t, err)
+	require.Equal(t, int64(documentID), id)
+	shared, err = ListSharedDocuments(ctx, dbc, jane, models.Filter{}, firstPage)
+	require.NoError(t, err)
+	require.Len(t, shared.Documents, 1)
+
+	// Only documents in the trash can be purged.
+	require.True(t, errors.Is(Purge(ctx, dbc, int64(documentID), john), ErrNotFound))
+	require.NoError(t, Delete(ctx, dbc, int64(documentID), john))
+	require.NoError(t, Purge(ctx, dbc, int64(documentID), john))
+
+	_, err = Get(ctx, dbc, int64(documentID))
+	require.True(t, errors.Is(err, ErrNotFound))
+	trash, err = ListTrash(ctx, dbc, john)
+	require.NoError(t, err)
+	require.Empty(t, trash)
+	var permissions int
+	require.NoError(t, dbc.QueryRow("select count(*) from `permissions` where `document` = ?", documentID).Scan(&permissions))
+	require.Equal(t, 0, permissions)
+
+	// Only the other document's contents are left.
+	blobs, err := memory.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, blobs, 1)
+	usage, err := Usage(ctx, dbc, john)
+	require.NoError(t, err)
+	require.Equal(t, int64(len("other")), usage.Used)
+}
+
+func TestEmptyTrash(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	documentID, err := Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("old"))), "text/plain", "old.txt")
+	require.NoError(t, err)
+	require.NoError(t, Delete(ctx, dbc, int64(documentID), john))
+
+	n, err := EmptyTrash(ctx, dbc, time.Now().Add(time.Duration(trashDays-1)*24*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	n, err = EmptyTrash(ctx, dbc, time.Now().Add(time.Duration(trashDays+1)*24*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	_, err = ListTrash(ctx, dbc, john)
+	require.NoError(t, err)
+
+	var purges int
+	require.NoError(t, dbc.QueryRow("select count(*) from `audit_log` where `document` is null and `action` like 'Purge%'").Scan(&purges))
+	require.Equal(t, 1, purges)
+}
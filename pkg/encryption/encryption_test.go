@@ -0,0 +1,114 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func encrypt(t *testing.T, key []byte, plaintext []byte) []byte {
+	reader, err := NewEncryptingReader(key, bytes.NewReader(plaintext))
+	require.NoError(t, err)
+	ciphertext, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	return ciphertext
+}
+
+func decrypt(key []byte, ciphertext []byte) ([]byte, error) {
+	reader, err := NewDecryptingReader(key, bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
+
+func TestStream_RoundTrip(t *testing.T) {
+	key, err := NewDataKey()
+	require.NoError(t, err)
+
+	for _, size := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3*segmentSize + 17} {
+		plaintext := make([]byte, size)
+		rand.New(rand.NewSource(int64(size))).Read(plaintext)
+
+		ciphertext := encrypt(t, key, plaintext)
+		require.False(t, size > 16 && bytes.Contains(ciphertext, plaintext[:16]))
+
+		got, err := decrypt(key, ciphertext)
+		require.NoError(t, err, size)
+		require.True(t, bytes.Equal(plaintext, got), size)
+	}
+}
+
+func TestStream_Tampered(t *testing.T) {
+	key, err := NewDataKey()
+	require.NoError(t, err)
+	otherKey, err := NewDataKey()
+	require.NoError(t, err)
+
+	plaintext := make([]byte, 2*segmentSize+100)
+	ciphertext := encrypt(t, key, plaintext)
+	segmentLength := segmentSize + 16
+
+	flipped := append([]byte(nil), ciphertext...)
+	flipped[100] ^= 1
+
+	tests := map[string]struct {
+		key        []byte
+		ciphertext []byte
+	}{
+		"wrong key":            {key: otherKey, ciphertext: ciphertext},
+		"flipped bit":          {key: key, ciphertext: flipped},
+		"truncated":            {key: key, ciphertext: ciphertext[:len(ciphertext)-1]},
+		"truncated at segment": {key: key, ciphertext: ciphertext[:noncePrefixSize+segmentLength]},
+		"empty":                {key: key, ciphertext: nil},
+		"dropped segment":      {key: key, ciphertext: append(append([]byte(nil), ciphertext[:noncePrefixSize+segmentLength]...), ciphertext[noncePrefixSize+2*segmentLength:]...)},
+		"appended after last":  {key: key, ciphertext: append(append([]byte(nil), ciphertext...), 0)},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := decrypt(tt.key, tt.ciphertext)
+			require.True(t, errors.Is(err, ErrCorrupt), err)
+		})
+	}
+}
+
+func TestFileKeyProvider_Rotation(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "old.key")
+	newPath := filepath.Join(dir, "new.key")
+	require.NoError(t, GenerateMasterKeyFile(oldPath))
+	require.NoError(t, GenerateMasterKeyFile(newPath))
+	require.Error(t, GenerateMasterKeyFile(newPath))
+
+	oldProvider, err := NewFileKeyProvider(oldPath)
+	require.NoError(t, err)
+	dataKey, err := NewDataKey()
+	require.NoError(t, err)
+	wrapped, oldKeyID, err := oldProvider.Wrap(ctx, dataKey)
+	require.NoError(t, err)
+
+	rotating, err := NewFileKeyProvider(newPath, oldPath)
+	require.NoError(t, err)
+	unwrapped, err := rotating.Unwrap(ctx, wrapped, oldKeyID)
+	require.NoError(t, err)
+	require.Equal(t, dataKey, unwrapped)
+
+	rewrapped, newKeyID, err := rotating.Wrap(ctx, unwrapped)
+	require.NoError(t, err)
+	require.NotEqual(t, oldKeyID, newKeyID)
+
+	newProvider, err := NewFileKeyProvider(newPath)
+	require.NoError(t, err)
+	unwrapped, err = newProvider.Unwrap(ctx, rewrapped, newKeyID)
+	require.NoError(t, err)
+	require.Equal(t, dataKey, unwrapped)
+
+	_, err = newProvider.Unwrap(ctx, wrapped, oldKeyID)
+	require.True(t, errors.Is(err, ErrUnknownKey))
+}
@@ -0,0 +1,132 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const DataKeySize = 32
+
+var ErrUnknownKey = errors.New("unknown master key")
+
+// KeyProvider protects data keys with a master key that never leaves the provider.
+type KeyProvider interface {
+	// Wrap encrypts dataKey under the current master key and returns it with the ID of that master key.
+	Wrap(ctx context.Context, dataKey []byte) ([]byte, string, error)
+	// Unwrap decrypts a data key wrapped under the master key with ID keyID.
+	Unwrap(ctx context.Context, wrapped []byte, keyID string) ([]byte, error)
+	// CurrentKeyID is the ID of the master key Wrap uses.
+	CurrentKeyID() string
+}
+
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, DataKeySize)
+	_, err := io.ReadFull(rand.Reader, key)
+	return key, err
+}
+
+// FileKeyProvider uses master keys read from local files, each holding a base64 encoded 256 bit key. New data keys are
+// wrapped with the first key, the others are only used to unwrap keys that have not been rotated yet.
+type FileKeyProvider struct {
+	currentID string
+	keys      map[string]cipher.AEAD
+}
+
+var _ KeyProvider = (*FileKeyProvider)(nil)
+
+func NewFileKeyProvider(current string, previous ...string) (*FileKeyProvider, error) {
+	provider := &FileKeyProvider{keys: make(map[string]cipher.AEAD)}
+	for i, path := range append([]string{current}, previous...) {
+		id, aead, err := readMasterKey(path)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			provider.currentID = id
+		}
+		provider.keys[id] = aead
+	}
+	return provider, nil
+}
+
+// GenerateMasterKeyFile writes a new random master key to path, failing if the file already exists.
+func GenerateMasterKeyFile(path string) error {
+	key, err := NewDataKey()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(file, base64.StdEncoding.EncodeToString(key)+"\n")
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// readMasterKey returns the AEAD for the key in path, identified by a fingerprint of the key.
+func readMasterKey(path string) (string, cipher.AEAD, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return "", nil, fmt.Errorf("master key %s: %w", path, err)
+	}
+	if len(key) != 32 {
+		return "", nil, fmt.Errorf("master key %s: expected 32 bytes, got %d", path, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", nil, err
+	}
+
+	fingerprint := sha256.Sum256(key)
+	return hex.EncodeToString(fingerprint[:8]), aead, nil
+}
+
+func (f *FileKeyProvider) CurrentKeyID() string {
+	return f.currentID
+}
+
+func (f *FileKeyProvider) Wrap(ctx context.Context, dataKey []byte) ([]byte, string, error) {
+	aead := f.keys[f.currentID]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(dataKey)+aead.Overhead())
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, "", err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(f.currentID)), f.currentID, nil
+}
+
+func (f *FileKeyProvider) Unwrap(ctx context.Context, wrapped []byte, keyID string) ([]byte, error) {
+	aead, ok := f.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(keyID))
+}
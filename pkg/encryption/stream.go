@@ -0,0 +1,196 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Streams are split into segments that are sealed separately, so that contents of any size can be encrypted and
+// decrypted with constant memory. Each segment's nonce is the stream's random prefix, the segment number and a flag
+// marking the final segment, which stops segments from being reordered, dropped or the stream from being truncated.
+const segmentSize = 64 << 10
+
+const noncePrefixSize = 7
+
+var ErrCorrupt = errors.New("encrypted stream is corrupt")
+
+func newAEAD(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func segmentNonce(prefix []byte, segment uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], segment)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type encryptingReader struct {
+	aead      cipher.AEAD
+	source    io.Reader
+	prefix    []byte
+	segment   uint32
+	plaintext []byte
+	next      []byte
+	sealed    []byte
+	pending   []byte
+	done      bool
+}
+
+// NewEncryptingReader returns a reader of plaintext encrypted under dataKey.
+func NewEncryptingReader(dataKey []byte, plaintext io.Reader) (io.Reader, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, noncePrefixSize)
+	_, err = io.ReadFull(rand.Reader, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptingReader{
+		aead:      aead,
+		source:    plaintext,
+		prefix:    prefix,
+		plaintext: make([]byte, segmentSize),
+		next:      make([]byte, 1),
+		sealed:    make([]byte, 0, segmentSize+aead.Overhead()),
+		pending:   prefix,
+	}, nil
+}
+
+func (e *encryptingReader) Read(p []byte) (int, error) {
+	for len(e.pending) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		err := e.seal()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, e.pending)
+	e.pending = e.pending[n:]
+	return n, nil
+}
+
+// seal encrypts the next segment. A segment is only known to be the last once the byte after it can't be read.
+func (e *encryptingReader) seal() error {
+	buf := e.plaintext[:0]
+	if e.segment > 0 {
+		buf = append(buf, e.next...)
+	}
+	n, err := io.ReadFull(e.source, e.plaintext[len(buf):])
+	buf = e.plaintext[:len(buf)+n]
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	last := err != nil
+	if !last {
+		n, err = io.ReadFull(e.source, e.next)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		last = n == 0
+	}
+
+	e.pending = e.aead.Seal(e.sealed[:0], segmentNonce(e.prefix, e.segment, last), buf, nil)
+	e.segment++
+	e.done = last
+	return nil
+}
+
+type decryptingReader struct {
+	aead       cipher.AEAD
+	source     io.Reader
+	prefix     []byte
+	segment    uint32
+	ciphertext []byte
+	plaintext  []byte
+	pending    []byte
+	done       bool
+}
+
+// NewDecryptingReader returns a reader of the plaintext of a stream produced by NewEncryptingReader. Reads fail with
+// ErrCorrupt if the stream was modified.
+func NewDecryptingReader(dataKey []byte, ciphertext io.Reader) (io.Reader, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptingReader{
+		aead:       aead,
+		source:     ciphertext,
+		ciphertext: make([]byte, segmentSize+aead.Overhead()+1),
+		plaintext:  make([]byte, 0, segmentSize),
+	}, nil
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.pending) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		err := d.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+// open decrypts the next segment. One byte past the segment is read ahead to know whether it is the last.
+func (d *decryptingReader) open() error {
+	if d.prefix == nil {
+		d.prefix = make([]byte, noncePrefixSize)
+		_, err := io.ReadFull(d.source, d.prefix)
+		if err != nil {
+			return ErrCorrupt
+		}
+	}
+
+	segmentLength := segmentSize + d.aead.Overhead()
+	buf := d.ciphertext[:0]
+	if d.segment > 0 {
+		d.ciphertext[0] = d.ciphertext[segmentLength]
+		buf = d.ciphertext[:1]
+	}
+	n, err := io.ReadFull(d.source, d.ciphertext[len(buf):segmentLength+1])
+	buf = d.ciphertext[:len(buf)+n]
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	last := len(buf) <= segmentLength
+	if !last {
+		buf = buf[:segmentLength]
+	}
+
+	plaintext, err := d.aead.Open(d.plaintext[:0], segmentNonce(d.prefix, d.segment, last), buf, nil)
+	if err != nil {
+		return ErrCorrupt
+	}
+	d.pending = plaintext
+	d.segment++
+	d.done = last
+	return nil
+}
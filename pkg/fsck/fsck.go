@@ -0,0 +1,39 @@
+package fsck
+
+import (
+	"context"
+	"database/sql"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents"
+	"github.com/EatonEmmerich/cloudStorage/pkg/fsck/models"
+)
+
+// Run checks stored documents and the permissions and audit log referring to them. Document rows are checked and
+// repaired first, so permissions left behind by a repair are picked up in the same run.
+func Run(ctx context.Context, dbc *sql.DB, repair bool, verifyChecksums bool) (models.Report, error) {
+	report := models.Report{Repair: repair, Issues: []models.Issue{}}
+
+	issues, err := documents.Fsck(ctx, dbc, repair, verifyChecksums)
+	if err != nil {
+		return report, err
+	}
+	report.Issues = append(report.Issues, issues...)
+
+	issues, err = access_control.Fsck(ctx, dbc, repair)
+	if err != nil {
+		return report, err
+	}
+	report.Issues = append(report.Issues, issues...)
+	return report, nil
+}
+
+// Unrepaired returns the number of issues in the report that still need attention.
+func Unrepaired(report models.Report) int {
+	var n int
+	for _, issue := range report.Issues {
+		if !issue.Repaired {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,37 @@
+package models
+
+type Kind string
+
+const (
+	// IncompleteUpload is a document row whose upload never completed, so it has no version.
+	IncompleteUpload Kind = "incomplete_upload"
+	// MissingBlob is contents referenced by a document that are not in the blob store.
+	MissingBlob Kind = "missing_blob"
+	// OrphanBlob is contents in the blob store that no document references.
+	OrphanBlob       Kind = "orphan_blob"
+	SizeMismatch     Kind = "size_mismatch"
+	ChecksumMismatch Kind = "checksum_mismatch"
+	// RefCountMismatch is a blob whose reference count differs from the number of versions referencing it.
+	RefCountMismatch Kind = "ref_count_mismatch"
+	// DanglingPermission is a permission granted on a document or to a user that no longer exists.
+	DanglingPermission Kind = "dangling_permission"
+	// DanglingAuditLog is an audit log entry referring to a document or user that no longer exists.
+	DanglingAuditLog Kind = "dangling_audit_log"
+)
+
+type Issue struct {
+	Kind     Kind   `json:"kind"`
+	Document int64  `json:"document,omitempty"`
+	User     int64  `json:"user,omitempty"`
+	Key      string `json:"key,omitempty"`
+	Detail   string `json:"detail"`
+	// Repair describes what the repair mode does, or did, about the issue. It is empty when the issue can't be repaired.
+	Repair   string `json:"repair,omitempty"`
+	Repaired bool   `json:"repaired"`
+}
+
+type Report struct {
+	// Repair is false for a dry run, in which case no issue has been repaired.
+	Repair bool    `json:"repair"`
+	Issues []Issue `json:"issues"`
+}
@@ -0,0 +1,145 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultChunkSize = 64 << 10
+
+const defaultTimeout = time.Minute
+
+type ClamdConfig struct {
+	// Network is "tcp" or "unix", and Address the host and port or socket path clamd listens on.
+	Network string
+	Address string
+	// Timeout limits how long a scan may take, including connecting.
+	Timeout time.Duration
+	// ChunkSize is the number of bytes sent per INSTREAM chunk. It must be below clamd's StreamMaxLength.
+	ChunkSize int
+}
+
+// ClamdConfigFromEnv reads the address of clamd from CLOUD_STORAGE_CLAMD_ADDRESS, written as tcp://host:port or
+// unix:///path/to/clamd.sock. The address is empty if it is not set.
+func ClamdConfigFromEnv() (ClamdConfig, error) {
+	address := os.Getenv("CLOUD_STORAGE_CLAMD_ADDRESS")
+	cfg := ClamdConfig{}
+	switch {
+	case address == "":
+	case strings.HasPrefix(address, "tcp://"):
+		cfg.Network, cfg.Address = "tcp", strings.TrimPrefix(address, "tcp://")
+	case strings.HasPrefix(address, "unix://"):
+		cfg.Network, cfg.Address = "unix", strings.TrimPrefix(address, "unix://")
+	default:
+		return ClamdConfig{}, fmt.Errorf("invalid CLOUD_STORAGE_CLAMD_ADDRESS: %q", address)
+	}
+
+	if timeout := os.Getenv("CLOUD_STORAGE_CLAMD_TIMEOUT"); timeout != "" {
+		var err error
+		cfg.Timeout, err = time.ParseDuration(timeout)
+		if err != nil {
+			return ClamdConfig{}, err
+		}
+	}
+	return cfg, nil
+}
+
+// Clamd scans contents with a ClamAV daemon, streaming them to it with the INSTREAM command.
+type Clamd struct {
+	cfg ClamdConfig
+}
+
+func NewClamd(cfg ClamdConfig) *Clamd {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.ChunkSize == 0 {
+		cfg.ChunkSize = defaultChunkSize
+	}
+	return &Clamd{cfg: cfg}
+}
+
+func (c *Clamd) Scan(ctx context.Context, reader io.Reader) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.cfg.Network, c.cfg.Address)
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// clamd stops reading and replies with an error once a stream is too long, so a failed write may still have a
+	// reply worth reading.
+	writeErr := c.stream(conn, reader)
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !errors.Is(err, io.EOF) || reply == "" {
+		if writeErr != nil {
+			return Result{}, writeErr
+		}
+		return Result{}, fmt.Errorf("reading clamd reply: %w", err)
+	}
+	return parseReply(reply)
+}
+
+// stream sends an INSTREAM command: the contents as chunks, each preceded by its length as a 4 byte big endian
+// integer, ending with a chunk of length 0.
+func (c *Clamd) stream(conn net.Conn, reader io.Reader) error {
+	_, err := conn.Write([]byte("zINSTREAM\x00"))
+	if err != nil {
+		return err
+	}
+
+	chunk := make([]byte, 4+c.cfg.ChunkSize)
+	for {
+		n, readErr := io.ReadFull(reader, chunk[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(chunk[:4], uint32(n))
+			_, err = conn.Write(chunk[:4+n])
+			if err != nil {
+				return err
+			}
+		}
+		if errors.Is(readErr, io.EOF) || errors.Is(readErr, io.ErrUnexpectedEOF) {
+			break
+		} else if readErr != nil {
+			return readErr
+		}
+	}
+
+	_, err = conn.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+// parseReply parses clamd's reply to INSTREAM, which is "stream: OK", "stream: <signature> FOUND" or a message ending
+// in "ERROR".
+func parseReply(reply string) (Result, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	switch {
+	case strings.HasSuffix(reply, "ERROR"):
+		return Result{}, fmt.Errorf("clamd: %s", reply)
+	case reply == "stream: OK":
+		return Result{}, nil
+	case strings.HasPrefix(reply, "stream: ") && strings.HasSuffix(reply, " FOUND"):
+		signature := strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), " FOUND")
+		return Result{Infected: true, Signature: signature}, nil
+	default:
+		return Result{}, fmt.Errorf("unexpected clamd reply: %q", reply)
+	}
+}
@@ -0,0 +1,139 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd is an in-process stand-in for clamd. It answers INSTREAM commands, finding the EICAR test file, and
+// errors once a stream is longer than maxLength.
+type fakeClamd struct {
+	listener  net.Listener
+	maxLength int
+}
+
+func newFakeClamd(t *testing.T, maxLength int) *fakeClamd {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	fake := &fakeClamd{listener: listener, maxLength: maxLength}
+	go fake.serve()
+	return fake
+}
+
+func (f *fakeClamd) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeClamd) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	command, err := reader.ReadString(0)
+	if err != nil {
+		return
+	}
+	if command != "zINSTREAM\x00" {
+		_, _ = io.WriteString(conn, "UNKNOWN COMMAND\x00")
+		return
+	}
+
+	var stream bytes.Buffer
+	for {
+		var length uint32
+		err = binary.Read(reader, binary.BigEndian, &length)
+		if err != nil {
+			return
+		}
+		if length == 0 {
+			break
+		}
+		if stream.Len()+int(length) > f.maxLength {
+			_, _ = io.WriteString(conn, "INSTREAM size limit exceeded. ERROR\x00")
+			return
+		}
+		_, err = io.CopyN(&stream, reader, int64(length))
+		if err != nil {
+			return
+		}
+	}
+
+	if strings.Contains(stream.String(), eicar) {
+		_, _ = io.WriteString(conn, "stream: Eicar-Signature FOUND\x00")
+		return
+	}
+	_, _ = io.WriteString(conn, "stream: OK\x00")
+}
+
+func (f *fakeClamd) address() string {
+	return f.listener.Addr().String()
+}
+
+func TestClamd_Scan(t *testing.T) {
+	fake := newFakeClamd(t, 1<<20)
+	clamd := NewClamd(ClamdConfig{Network: "tcp", Address: fake.address(), ChunkSize: 7})
+
+	tests := map[string]struct {
+		contents string
+		expected Result
+	}{
+		"Clean":    {contents: "hello world"},
+		"Empty":    {contents: ""},
+		"Infected": {contents: "prefix " + eicar + " suffix", expected: Result{Infected: true, Signature: "Eicar-Signature"}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			result, err := clamd.Scan(context.Background(), strings.NewReader(test.contents))
+			require.NoError(t, err)
+			require.Equal(t, test.expected, result)
+		})
+	}
+}
+
+func TestClamd_ScanTooLong(t *testing.T) {
+	fake := newFakeClamd(t, 16)
+	clamd := NewClamd(ClamdConfig{Network: "tcp", Address: fake.address(), ChunkSize: 8})
+
+	_, err := clamd.Scan(context.Background(), strings.NewReader(strings.Repeat("a", 1<<20)))
+	require.Error(t, err)
+}
+
+func TestClamd_ScanUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	clamd := NewClamd(ClamdConfig{Network: "tcp", Address: address, Timeout: time.Second})
+	_, err = clamd.Scan(context.Background(), strings.NewReader("hello world"))
+	require.Error(t, err)
+}
+
+func TestParseReply(t *testing.T) {
+	result, err := parseReply("stream: Win.Test.EICAR_HDB-1 FOUND\x00")
+	require.NoError(t, err)
+	require.Equal(t, Result{Infected: true, Signature: "Win.Test.EICAR_HDB-1"}, result)
+
+	_, err = parseReply("stream: lstat() failed: No such file or directory. ERROR\x00")
+	require.Error(t, err)
+
+	_, err = parseReply("PONG\x00")
+	require.Error(t, err)
+}
@@ -0,0 +1,18 @@
+package scanner
+
+import (
+	"context"
+	"io"
+)
+
+// Result is the outcome of scanning contents for malware.
+type Result struct {
+	Infected bool
+	// Signature names the malware found in infected contents.
+	Signature string
+}
+
+// Scanner scans contents for malware. An error means the contents could not be scanned, not that they are infected.
+type Scanner interface {
+	Scan(ctx context.Context, reader io.Reader) (Result, error)
+}
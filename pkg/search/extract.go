@@ -0,0 +1,112 @@
+package search
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"strings"
+)
+
+// MaxTextSize is how many bytes of a document's contents text is extracted from. The rest is not indexed.
+const MaxTextSize = 1 << 20
+
+// Extractable reports whether text can be extracted from contents of a media type: text of any kind, JSON, CSV and
+// Markdown.
+func Extractable(mediaType string) bool {
+	t := baseType(mediaType)
+	return strings.HasPrefix(t, "text/") || isJSON(t) || t == "application/csv" || t == "application/x-markdown"
+}
+
+// Extract returns the text to index from contents of a media type, which must be Extractable. JSON contributes its
+// keys and string and number values and CSV its fields. Contents that don't parse as their media type are indexed as
+// plain text, and only the first MaxTextSize bytes of any contents are read.
+func Extract(mediaType string, r io.Reader) (string, error) {
+	if !Extractable(mediaType) {
+		return "", fmt.Errorf("can't extract text from %s", mediaType)
+	}
+
+	contents, err := ioutil.ReadAll(io.LimitReader(r, MaxTextSize))
+	if err != nil {
+		return "", err
+	}
+
+	t := baseType(mediaType)
+	switch {
+	case isJSON(t):
+		text, err := jsonText(contents)
+		if err == nil {
+			return text, nil
+		}
+	case t == "text/csv" || t == "application/csv":
+		text, err := csvText(contents)
+		if err == nil {
+			return text, nil
+		}
+	}
+	// Markdown's markup, like the punctuation of any other text, is not part of any term.
+	return string(contents), nil
+}
+
+func jsonText(contents []byte) (string, error) {
+	var value interface{}
+	decoder := json.NewDecoder(strings.NewReader(string(contents)))
+	decoder.UseNumber()
+	err := decoder.Decode(&value)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	var walk func(value interface{})
+	walk = func(value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for key, child := range v {
+				b.WriteString(key + " ")
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		case string:
+			b.WriteString(v + " ")
+		case json.Number:
+			b.WriteString(v.String() + " ")
+		}
+	}
+	walk(value)
+	return b.String(), nil
+}
+
+func csvText(contents []byte) (string, error) {
+	reader := csv.NewReader(strings.NewReader(string(contents)))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	var b strings.Builder
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return b.String(), nil
+		} else if err != nil {
+			return "", err
+		}
+		b.WriteString(strings.Join(record, " ") + " ")
+	}
+}
+
+func baseType(mediaType string) string {
+	t, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(mediaType))
+	}
+	return t
+}
+
+func isJSON(t string) bool {
+	return t == "application/json" || strings.HasSuffix(t, "+json")
+}
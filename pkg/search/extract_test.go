@@ -0,0 +1,69 @@
+package search
+
+import (
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestTerms(t *testing.T) {
+	require.Equal(t, []string{"invoice", "2024", "03", "pdf", "café"}, Terms("Invoice_2024-03.pdf, INVOICE café"))
+	require.Empty(t, Terms(" -- "))
+	require.Equal(t, []string{"short"}, Terms("short "+strings.Repeat("x", maxTermLength+1)))
+}
+
+func TestExtract(t *testing.T) {
+	testCases := []struct {
+		name      string
+		mediaType string
+		contents  string
+		expected  []string
+	}{
+		{
+			name:      "plain text",
+			mediaType: "text/plain; charset=utf-8",
+			contents:  "Quarterly report",
+			expected:  []string{"quarterly", "report"},
+		},
+		{
+			name:      "markdown",
+			mediaType: "text/markdown",
+			contents:  "# Release notes\n\n* **fixed** [links](http://example.com)",
+			expected:  []string{"release", "notes", "fixed", "links", "http", "example", "com"},
+		},
+		{
+			name:      "json",
+			mediaType: "application/json",
+			contents:  `{"client": "acme", "lines": [{"total": 42}], "paid": true}`,
+			expected:  []string{"client", "acme", "lines", "total", "42", "paid"},
+		},
+		{
+			name:      "invalid json",
+			mediaType: "application/json",
+			contents:  `{"client": "acme"`,
+			expected:  []string{"client", "acme"},
+		},
+		{
+			name:      "csv",
+			mediaType: "text/csv",
+			contents:  "name,city\n\"Smith, John\",Cape Town\n",
+			expected:  []string{"name", "city", "smith", "john", "cape", "town"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			text, err := Extract(tc.mediaType, strings.NewReader(tc.contents))
+			require.NoError(t, err)
+			require.ElementsMatch(t, tc.expected, Terms(text))
+		})
+	}
+
+	require.False(t, Extractable("image/png"))
+	require.False(t, Extractable("application/octet-stream"))
+	_, err := Extract("application/pdf", strings.NewReader("%PDF-1.4"))
+	require.Error(t, err)
+
+	text, err := Extract("text/plain", strings.NewReader(strings.Repeat("a ", MaxTextSize)))
+	require.NoError(t, err)
+	require.Len(t, text, MaxTextSize)
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"github.com/EatonEmmerich/cloudStorage/pkg/search/models"
+	"strings"
+)
+
+type DBTransaction interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, querty string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// insertBatch is how many terms are inserted per statement.
+const insertBatch = 500
+
+// ReplaceTerms replaces the terms indexed for a field of a document with terms and their weights.
+func ReplaceTerms(ctx context.Context, dbc DBTransaction, documentID int64, field string, terms map[string]int64) error {
+	_, err := dbc.ExecContext(ctx, "delete from `search_terms` where `document` = ? and `field` = ?", documentID, field)
+	if err != nil {
+		return err
+	}
+
+	var values []string
+	var args []interface{}
+	for term, weight := range terms {
+		values = append(values, "(?, ?, ?, ?)")
+		args = append(args, term, documentID, field, weight)
+		if len(values) == insertBatch {
+			err = insertTerms(ctx, dbc, values, args)
+			if err != nil {
+				return err
+			}
+			values, args = nil, nil
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return insertTerms(ctx, dbc, values, args)
+}
+
+func insertTerms(ctx context.Context, dbc DBTransaction, values []string, args []interface{}) error {
+	_, err := dbc.ExecContext(ctx, "insert into `search_terms` (`term`, `document`, `field`, `weight`) values "+strings.Join(values, ", "), args...)
+	return err
+}
+
+// CopyTerms indexes a field of a document with the terms indexed for that field of another.
+func CopyTerms(ctx context.Context, dbc DBTransaction, fromDocumentID int64, documentID int64, field string) error {
+	_, err := dbc.ExecContext(ctx, "delete from `search_terms` where `document` = ? and `field` = ?", documentID, field)
+	if err != nil {
+		return err
+	}
+
+	_, err = dbc.ExecContext(ctx, "insert into `search_terms` (`term`, `document`, `field`, `weight`) "+
+		"select `term`, ?, `field`, `weight` from `search_terms` where `document` = ? and `field` = ?", documentID, fromDocumentID, field)
+	return err
+}
+
+// Find returns the documents the user owns, or has been granted all of perms on, that are not in the trash and have
+// every one of terms indexed, best scoring first.
+func Find(ctx context.Context, dbc DBTransaction, userID int64, perms int, terms []string, limit int) ([]models.Result, error) {
+	args := []interface{}{}
+	for _, term := range terms {
+		args = append(args, term)
+	}
+	args = append(args, userID, userID, perms, perms, len(terms), limit)
+
+	rows, err := dbc.QueryContext(ctx, "select t.`document`, sum(t.`weight`) as `score` from `search_terms` t "+
+		"join `documents` d on d.`id` = t.`document` "+
+		"where t.`term` in (?"+strings.Repeat(", ?", len(terms)-1)+") and d.`deleted_at` is null and (d.`owner` = ? or exists "+
+		"(select 1 from `permissions` p where p.`document` = d.`id` and p.`user` = ? and p.`permissions` & ? = ?)) "+
+		"group by t.`document` having count(distinct t.`term`) = ? "+
+		"order by `score` desc, t.`document` desc limit ?", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []models.Result
+	for rows.Next() {
+		var result models.Result
+		err = rows.Scan(&result.Document, &result.Score)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
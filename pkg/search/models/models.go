@@ -0,0 +1,8 @@
+package models
+
+// Result is a document that matches a search. Documents that match in their names score higher than those that match
+// in their tags, which score higher than those that only match in their contents.
+type Result struct {
+	Document int64
+	Score    int64
+}
@@ -0,0 +1,138 @@
+package search
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	accessModels "github.com/EatonEmmerich/cloudStorage/pkg/access_control/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/search/internal/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/search/models"
+	"strings"
+	"unicode"
+)
+
+var ErrEmptyQuery = errors.New("search query has no terms")
+
+// The fields of a document that are indexed.
+const (
+	FieldName = "name"
+	FieldTags = "tags"
+	FieldText = "text"
+)
+
+// fieldWeights are how much each occurrence of a term in a field adds to a document's score.
+var fieldWeights = map[string]int64{
+	FieldName: 10,
+	FieldTags: 5,
+	FieldText: 1,
+}
+
+// maxOccurrences caps how many occurrences of a term in a field count towards a document's score, so a term repeated
+// throughout a document's contents doesn't outweigh its name.
+const maxOccurrences = 5
+
+// maxTermLength is the longest term indexed, in bytes. Longer words are left out of the index and queries.
+const maxTermLength = 64
+
+// maxQueryTerms is the most terms a query can have.
+const maxQueryTerms = 16
+
+// MaxResults is the most results a search returns.
+const MaxResults = 100
+
+// Index replaces what is indexed for a field of a document with the terms in text.
+func Index(ctx context.Context, dbc *sql.DB, documentID int64, field string, text string) error {
+	weights := make(map[string]int64)
+	for term, n := range countTerms(text) {
+		if n > maxOccurrences {
+			n = maxOccurrences
+		}
+		weights[term] = n * fieldWeights[field]
+	}
+
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = db.ReplaceTerms(ctx, tx, documentID, field, weights)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CopyIndex indexes a field of a document with what is indexed for that field of another, such as the text of a copy,
+// without extracting it again.
+func CopyIndex(ctx context.Context, dbc *sql.DB, fromDocumentID int64, documentID int64, field string) error {
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = db.CopyTerms(ctx, tx, fromDocumentID, documentID, field)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Search finds the documents the user can read that contain every term in a query, in their name, tags or contents.
+// It returns at most limit results, or MaxResults if limit is 0 or more than that, best matches first.
+func Search(ctx context.Context, dbc *sql.DB, userID int64, query string, limit int) ([]models.Result, error) {
+	terms := Terms(query)
+	if len(terms) == 0 {
+		return nil, ErrEmptyQuery
+	}
+	if len(terms) > maxQueryTerms {
+		terms = terms[:maxQueryTerms]
+	}
+	if limit <= 0 || limit > MaxResults {
+		limit = MaxResults
+	}
+
+	results, err := db.Find(ctx, dbc, userID, int(accessModels.READ), terms, limit)
+	if err != nil {
+		return nil, err
+	}
+	if results == nil {
+		results = []models.Result{}
+	}
+	return results, nil
+}
+
+// Terms splits text into the distinct terms it is indexed by, in the order they first appear. Terms are runs of
+// letters and digits, in lower case.
+func Terms(text string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, term := range words(text) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+func countTerms(text string) map[string]int64 {
+	counts := make(map[string]int64)
+	for _, term := range words(text) {
+		counts[term]++
+	}
+	return counts
+}
+
+func words(text string) []string {
+	var words []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(word) <= maxTermLength {
+			words = append(words, word)
+		}
+	}
+	return words
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type FileSystem struct {
+	root string
+}
+
+var _ BlobStore = (*FileSystem)(nil)
+
+func NewFileSystem(root string) *FileSystem {
+	return &FileSystem{root: root}
+}
+
+func (f *FileSystem) path(key string) (string, error) {
+	p := filepath.Join(f.root, filepath.FromSlash(key))
+	if key == "" || !strings.HasPrefix(p, filepath.Clean(f.root)+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid blob key: %q", key)
+	}
+	return p, nil
+}
+
+// Put writes to a temporary file next to the blob and renames it into place, so readers never see a partial blob.
+func (f *FileSystem) Put(ctx context.Context, key string, reader io.Reader) (int64, error) {
+	p, err := f.path(key)
+	if err != nil {
+		return 0, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(p), 0o755)
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(p), ".put-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+
+	n, err := io.Copy(file, reader)
+	if err != nil {
+		file.Close()
+		return 0, err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	return n, os.Rename(file.Name(), p)
+}
+
+func (f *FileSystem) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotExist, key)
+	}
+	return file, err
+}
+
+func (f *FileSystem) Delete(ctx context.Context, key string) error {
+	p, err := f.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrNotExist, key)
+	}
+	return err
+}
+
+func (f *FileSystem) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	p, err := f.path(key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	info, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return BlobInfo{}, fmt.Errorf("%w: %s", ErrNotExist, key)
+	} else if err != nil {
+		return BlobInfo{}, err
+	}
+
+	return BlobInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (f *FileSystem) List(ctx context.Context, prefix string) ([]BlobInfo, error) {
+	var blobs []BlobInfo
+	err := filepath.WalkDir(f.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".put-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(f.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, BlobInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Key < blobs[j].Key })
+	return blobs, nil
+}
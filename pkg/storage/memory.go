@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryBlob struct {
+	data    []byte
+	modTime time.Time
+}
+
+// Memory keeps blobs in process memory, for tests and throwaway deployments.
+type Memory struct {
+	mu    sync.RWMutex
+	blobs map[string]memoryBlob
+}
+
+var _ BlobStore = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{blobs: make(map[string]memoryBlob)}
+}
+
+func (m *Memory) Put(ctx context.Context, key string, reader io.Reader) (int64, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs[key] = memoryBlob{data: data, modTime: time.Now()}
+	return int64(len(data)), nil
+}
+
+func (m *Memory) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	blob, ok := m.blobs[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotExist, key)
+	}
+	return io.NopCloser(bytes.NewReader(blob.data)), nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.blobs[key]; !ok {
+		return fmt.Errorf("%w: %s", ErrNotExist, key)
+	}
+	delete(m.blobs, key)
+	return nil
+}
+
+func (m *Memory) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	blob, ok := m.blobs[key]
+	if !ok {
+		return BlobInfo{}, fmt.Errorf("%w: %s", ErrNotExist, key)
+	}
+	return BlobInfo{Key: key, Size: int64(len(blob.data)), ModTime: blob.modTime}, nil
+}
+
+func (m *Memory) List(ctx context.Context, prefix string) ([]BlobInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var blobs []BlobInfo
+	for key, blob := range m.blobs {
+		if strings.HasPrefix(key, prefix) {
+			blobs = append(blobs, BlobInfo{Key: key, Size: int64(len(blob.data)), ModTime: blob.modTime})
+		}
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Key < blobs[j].Key })
+	return blobs, nil
+}
@@ -0,0 +1,387 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parts smaller than this are rejected by S3, except for the last part of an upload.
+const minPartSize = 5 << 20
+
+const defaultPartSize = 8 << 20
+
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+type S3Config struct {
+	// Endpoint is the base URL of the S3-compatible service, e.g. https://s3.eu-west-1.amazonaws.com
+	Endpoint        string
+	Bucket          string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PartSize is the amount of a blob buffered per request. Blobs larger than this are sent as a multipart upload.
+	PartSize int64
+}
+
+func S3ConfigFromEnv() S3Config {
+	cfg := S3Config{
+		Endpoint:        os.Getenv("CLOUD_STORAGE_S3_ENDPOINT"),
+		Bucket:          os.Getenv("CLOUD_STORAGE_S3_BUCKET"),
+		Region:          os.Getenv("CLOUD_STORAGE_S3_REGION"),
+		AccessKeyID:     os.Getenv("CLOUD_STORAGE_S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("CLOUD_STORAGE_S3_SECRET_ACCESS_KEY"),
+	}
+	partSize, err := strconv.ParseInt(os.Getenv("CLOUD_STORAGE_S3_PART_SIZE"), 10, 64)
+	if err == nil {
+		cfg.PartSize = partSize
+	}
+	return cfg
+}
+
+// S3 stores blobs as objects in a bucket of an S3-compatible object store, using path-style addressing.
+type S3 struct {
+	cfg    S3Config
+	client *http.Client
+	now    func() time.Time
+}
+
+var _ BlobStore = (*S3)(nil)
+
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("s3 endpoint and bucket are required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.PartSize == 0 {
+		cfg.PartSize = defaultPartSize
+	}
+	if cfg.PartSize < minPartSize {
+		return nil, fmt.Errorf("s3 part size must be at least %d bytes", minPartSize)
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+
+	return &S3{cfg: cfg, client: http.DefaultClient, now: time.Now}, nil
+}
+
+// Put sends blobs no larger than one part in a single request and larger ones as a multipart upload, so at most one
+// part is held in memory at a time.
+func (s *S3) Put(ctx context.Context, key string, reader io.Reader) (int64, error) {
+	buf := make([]byte, s.cfg.PartSize)
+	n, err := io.ReadFull(reader, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		resp, err := s.do(ctx, http.MethodPut, key, nil, buf[:n])
+		if err != nil {
+			return 0, err
+		}
+		return int64(n), resp.Body.Close()
+	} else if err != nil {
+		return 0, err
+	}
+
+	return s.putMultipart(ctx, key, buf, reader)
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+func (s *S3) putMultipart(ctx context.Context, key string, first []byte, reader io.Reader) (written int64, err error) {
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return 0, err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = decodeXML(resp, &initiated)
+	if err != nil {
+		return 0, err
+	}
+
+	// S3 keeps, and bills for, the parts of an upload until it is completed or aborted.
+	defer func() {
+		if err != nil {
+			abortResp, abortErr := s.do(context.Background(), http.MethodDelete, key, url.Values{"uploadId": {initiated.UploadID}}, nil)
+			if abortErr == nil {
+				abortResp.Body.Close()
+			}
+		}
+	}()
+
+	written, parts, err := s.uploadParts(ctx, key, initiated.UploadID, first, reader)
+	if err != nil {
+		return 0, err
+	}
+
+	body, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		return 0, err
+	}
+	resp, err = s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {initiated.UploadID}}, body)
+	if err != nil {
+		return 0, err
+	}
+
+	// S3 can report a failed completion inside a 200 response.
+	var completed struct {
+		XMLName xml.Name
+		Message string
+	}
+	err = decodeXML(resp, &completed)
+	if err != nil {
+		return 0, err
+	}
+	if completed.XMLName.Local == "Error" {
+		return 0, fmt.Errorf("s3 complete multipart upload: %s", completed.Message)
+	}
+	return written, nil
+}
+
+func (s *S3) uploadParts(ctx context.Context, key string, uploadID string, buf []byte, reader io.Reader) (int64, []completedPart, error) {
+	var written int64
+	var parts []completedPart
+	n := len(buf)
+	for partNumber := 1; ; partNumber++ {
+		resp, err := s.do(ctx, http.MethodPut, key, url.Values{
+			"partNumber": {strconv.Itoa(partNumber)},
+			"uploadId":   {uploadID},
+		}, buf[:n])
+		if err != nil {
+			return 0, nil, err
+		}
+		err = resp.Body.Close()
+		if err != nil {
+			return 0, nil, err
+		}
+		parts = append(parts, completedPart{PartNumber: partNumber, ETag: resp.Header.Get("ETag")})
+		written += int64(n)
+
+		n, err = io.ReadFull(reader, buf)
+		if errors.Is(err, io.EOF) {
+			return written, parts, nil
+		} else if errors.Is(err, io.ErrUnexpectedEOF) {
+			// The short read is the final part, send it on the next iteration and then stop.
+			reader = bytes.NewReader(nil)
+		} else if err != nil {
+			return 0, nil, err
+		}
+	}
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete stats the object first, as S3 reports success when deleting keys that do not exist.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	_, err := s.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	modTime, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		modTime = time.Time{}
+	}
+	return BlobInfo{Key: key, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]BlobInfo, error) {
+	var blobs []BlobInfo
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result listBucketResult
+		err = decodeXML(resp, &result)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			blobs = append(blobs, BlobInfo{Key: object.Key, Size: object.Size, ModTime: object.LastModified})
+		}
+		if !result.IsTruncated {
+			return blobs, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func decodeXML(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	return xml.NewDecoder(resp.Body).Decode(v)
+}
+
+// do sends a signed request for key in the configured bucket. Responses other than 2xx are returned as errors.
+func (s *S3) do(ctx context.Context, method string, key string, query url.Values, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.URL.Path += "/" + s.cfg.Bucket + "/" + key
+	req.URL.RawPath = uriEncode(req.URL.Path, false)
+	req.URL.RawQuery = query.Encode()
+	req.ContentLength = int64(len(body))
+
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		payloadHash = hashHex(body)
+	}
+	signV4(req, payloadHash, s.cfg, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrNotExist, key)
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, key, resp.Status, message)
+	}
+	return resp, nil
+}
+
+// signV4 adds an AWS Signature Version 4 Authorization header to req.
+func signV4(req *http.Request, payloadHash string, cfg S3Config, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-type" || name == "content-md5" || name == "range" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + cfg.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hashHex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+cfg.SecretAccessKey), date)
+	key = hmacSHA256(key, cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+cfg.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func canonicalQuery(query url.Values) string {
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// uriEncode escapes everything except the unreserved characters of RFC 3986, as required for signing.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotExist = errors.New("blob does not exist")
+
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// BlobStore holds the contents of documents, addressed by key.
+type BlobStore interface {
+	// Put stores everything read from reader under key, replacing any existing blob.
+	Put(ctx context.Context, key string, reader io.Reader) (int64, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	Stat(ctx context.Context, key string) (BlobInfo, error)
+	// List returns all blobs whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]BlobInfo, error)
+}
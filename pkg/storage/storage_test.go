@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	fuzz "github.com/google/gofuzz"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"testing"
+)
+
+func blobStores(t *testing.T) map[string]BlobStore {
+	return map[string]BlobStore{
+		"FileSystem": NewFileSystem(t.TempDir()),
+		"Memory":     NewMemory(),
+	}
+}
+
+func TestBlobStore_PutGet(t *testing.T) {
+	ctx := context.Background()
+	fuzzer := fuzz.New()
+
+	var data []byte
+	fuzzer.Fuzz(&data)
+
+	for name, store := range blobStores(t) {
+		t.Run(name, func(t *testing.T) {
+			n, err := store.Put(ctx, "1_v1", bytes.NewReader(data))
+			require.NoError(t, err)
+			require.Equal(t, int64(len(data)), n)
+
+			reader, err := store.Get(ctx, "1_v1")
+			require.NoError(t, err)
+			got, err := ioutil.ReadAll(reader)
+			require.NoError(t, err)
+			require.NoError(t, reader.Close())
+			require.Equal(t, len(data), len(got))
+			require.True(t, bytes.Equal(data, got))
+
+			info, err := store.Stat(ctx, "1_v1")
+			require.NoError(t, err)
+			require.Equal(t, int64(len(data)), info.Size)
+		})
+	}
+}
+
+func TestBlobStore_Overwrite(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range blobStores(t) {
+		t.Run(name, func(t *testing.T) {
+			_, err := store.Put(ctx, "1_v1", bytes.NewReader([]byte("first")))
+			require.NoError(t, err)
+			_, err = store.Put(ctx, "1_v1", bytes.NewReader([]byte("second")))
+			require.NoError(t, err)
+
+			reader, err := store.Get(ctx, "1_v1")
+			require.NoError(t, err)
+			got, err := ioutil.ReadAll(reader)
+			require.NoError(t, err)
+			require.Equal(t, "second", string(got))
+		})
+	}
+}
+
+func TestBlobStore_DeleteNotExist(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range blobStores(t) {
+		t.Run(name, func(t *testing.T) {
+			_, err := store.Put(ctx, "1_v1", bytes.NewReader([]byte{1}))
+			require.NoError(t, err)
+			require.NoError(t, store.Delete(ctx, "1_v1"))
+
+			_, err = store.Get(ctx, "1_v1")
+			require.True(t, errors.Is(err, ErrNotExist))
+			_, err = store.Stat(ctx, "1_v1")
+			require.True(t, errors.Is(err, ErrNotExist))
+			err = store.Delete(ctx, "1_v1")
+			require.True(t, errors.Is(err, ErrNotExist))
+		})
+	}
+}
+
+func TestBlobStore_List(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range blobStores(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, key := range []string{"1_v1", "1_v2", "2_v1", "nested/1_v1"} {
+				_, err := store.Put(ctx, key, bytes.NewReader([]byte(key)))
+				require.NoError(t, err)
+			}
+
+			blobs, err := store.List(ctx, "1_")
+			require.NoError(t, err)
+			require.Len(t, blobs, 2)
+			require.Equal(t, "1_v1", blobs[0].Key)
+			require.Equal(t, "1_v2", blobs[1].Key)
+
+			blobs, err = store.List(ctx, "")
+			require.NoError(t, err)
+			require.Len(t, blobs, 4)
+			require.Equal(t, "nested/1_v1", blobs[3].Key)
+		})
+	}
+}
+
+func TestFileSystem_InvalidKey(t *testing.T) {
+	ctx := context.Background()
+	store := NewFileSystem(t.TempDir())
+
+	for _, key := range []string{"", "../escape", "a/../../escape"} {
+		_, err := store.Put(ctx, key, bytes.NewReader([]byte{1}))
+		require.Error(t, err, key)
+	}
+}
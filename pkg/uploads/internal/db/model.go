@@ -0,0 +1,39 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Upload struct {
+	ID        string
+	User      int64
+	Document  sql.NullInt64
+	Length    int64
+	Offset    int64
+	MediaType string
+	FileName  string
+	Result    sql.NullInt64
+	Created   time.Time
+	Expires   time.Time
+}
+
+type MultipartUpload struct {
+	ID        string
+	User      int64
+	Document  sql.NullInt64
+	MediaType string
+	FileName  string
+	Result    sql.NullInt64
+	Created   time.Time
+	Expires   time.Time
+}
+
+type Part struct {
+	Upload  string
+	Number  int
+	Size    int64
+	MD5     string
+	SHA256  string
+	Created time.Time
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const multipartColumns = "`id`, `user`, `document`, `media_type`, `file_name`, `result`, `created_at`, `expires_at`"
+
+func InsertMultipartUpload(ctx context.Context, dbc DBTransaction, u MultipartUpload) error {
+	res, err := dbc.ExecContext(ctx, "insert into `multipart_uploads` ("+multipartColumns+") values (?, ?, ?, ?, ?, ?, ?, ?)",
+		u.ID, u.User, u.Document, u.MediaType, u.FileName, u.Result, u.Created, u.Expires)
+	if err != nil {
+		return err
+	}
+
+	numberOfRows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numberOfRows != 1 {
+		return fmt.Errorf("incorrect number of rows inserted, expected: 1, got:%d", numberOfRows)
+	}
+	return nil
+}
+
+func GetMultipartUpload(ctx context.Context, dbc DBTransaction, id string) (MultipartUpload, error) {
+	return getMultipartUpload(ctx, dbc, "select "+multipartColumns+" from `multipart_uploads` where `id` = ?", id)
+}
+
+// GetMultipartUploadForUpdate reads a multipart upload and locks its row until the transaction ends.
+func GetMultipartUploadForUpdate(ctx context.Context, dbc DBTransaction, id string) (MultipartUpload, error) {
+	return getMultipartUpload(ctx, dbc, "select "+multipartColumns+" from `multipart_uploads` where `id` = ? for update", id)
+}
+
+func getMultipartUpload(ctx context.Context, dbc DBTransaction, query string, id string) (MultipartUpload, error) {
+	row := dbc.QueryRowContext(ctx, query, id)
+	err := row.Err()
+	if err != nil {
+		return MultipartUpload{}, err
+	}
+
+	var u MultipartUpload
+	err = row.Scan(&u.ID, &u.User, &u.Document, &u.MediaType, &u.FileName, &u.Result, &u.Created, &u.Expires)
+	if err != nil {
+		return MultipartUpload{}, err
+	}
+	return u, nil
+}
+
+func SetMultipartExpiry(ctx context.Context, dbc DBTransaction, id string, expires time.Time) error {
+	_, err := dbc.ExecContext(ctx, "update `multipart_uploads` set `expires_at` = ? where `id` = ?", expires, id)
+	return err
+}
+
+// SetMultipartResult records the document a multipart upload was stored as, and deletes its parts, which are no longer
+// needed. The upload is kept until it expires.
+func SetMultipartResult(ctx context.Context, dbc DBTransaction, id string, documentID int64, expires time.Time) error {
+	_, err := dbc.ExecContext(ctx, "delete from `multipart_parts` where `upload` = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = dbc.ExecContext(ctx, "update `multipart_uploads` set `result` = ?, `expires_at` = ? where `id` = ?", documentID, expires, id)
+	return err
+}
+
+// DeleteMultipartUpload deletes a multipart upload along with its parts.
+func DeleteMultipartUpload(ctx context.Context, dbc DBTransaction, id string) error {
+	_, err := dbc.ExecContext(ctx, "delete from `multipart_parts` where `upload` = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = dbc.ExecContext(ctx, "delete from `multipart_uploads` where `id` = ?", id)
+	return err
+}
+
+// ListExpiredMultipartUploads returns the IDs of multipart uploads that expired before now.
+func ListExpiredMultipartUploads(ctx context.Context, dbc DBTransaction, now time.Time) ([]string, error) {
+	rows, err := dbc.QueryContext(ctx, "select `id` from `multipart_uploads` where `expires_at` <= ?", now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// PutPart records a part, replacing any part previously uploaded with the same number.
+func PutPart(ctx context.Context, dbc DBTransaction, p Part) error {
+	_, err := dbc.ExecContext(ctx, "insert into `multipart_parts` (`upload`, `number`, `size`, `md5`, `sha256`, `created_at`) values (?, ?, ?, ?, ?, ?) "+
+		"on duplicate key update `size` = values(`size`), `md5` = values(`md5`), `sha256` = values(`sha256`), `created_at` = values(`created_at`)",
+		p.Upload, p.Number, p.Size, p.MD5, p.SHA256, p.Created)
+	return err
+}
+
+func GetPart(ctx context.Context, dbc DBTransaction, uploadID string, number int) (Part, error) {
+	row := dbc.QueryRowContext(ctx, "select `upload`, `number`, `size`, `md5`, `sha256`, `created_at` from `multipart_parts` where `upload` = ? and `number` = ?",
+		uploadID, number)
+	var p Part
+	err := row.Scan(&p.Upload, &p.Number, &p.Size, &p.MD5, &p.SHA256, &p.Created)
+	return p, err
+}
+
+// ListParts returns the parts of a multipart upload, ordered by number.
+func ListParts(ctx context.Context, dbc DBTransaction, uploadID string) ([]Part, error) {
+	rows, err := dbc.QueryContext(ctx, "select `upload`, `number`, `size`, `md5`, `sha256`, `created_at` from `multipart_parts` where `upload` = ? order by `number`", uploadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var parts []Part
+	for rows.Next() {
+		var p Part
+		err = rows.Scan(&p.Upload, &p.Number, &p.Size, &p.MD5, &p.SHA256, &p.Created)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, p)
+	}
+	return parts, rows.Err()
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type DBTransaction interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, querty string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+const uploadColumns = "`id`, `user`, `document`, `length`, `offset`, `media_type`, `file_name`, `result`, `created_at`, `expires_at`"
+
+func InsertUpload(ctx context.Context, dbc DBTransaction, u Upload) error {
+	res, err := dbc.ExecContext(ctx, "insert into `uploads` ("+uploadColumns+") values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		u.ID, u.User, u.Document, u.Length, u.Offset, u.MediaType, u.FileName, u.Result, u.Created, u.Expires)
+	if err != nil {
+		return err
+	}
+
+	numberOfRows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numberOfRows != 1 {
+		return fmt.Errorf("incorrect number of rows inserted, expected: 1, got:%d", numberOfRows)
+	}
+	return nil
+}
+
+func GetUpload(ctx context.Context, dbc DBTransaction, id string) (Upload, error) {
+	return getUpload(ctx, dbc, "select "+uploadColumns+" from `uploads` where `id` = ?", id)
+}
+
+// GetUploadForUpdate reads an upload and locks its row until the transaction ends.
+func GetUploadForUpdate(ctx context.Context, dbc DBTransaction, id string) (Upload, error) {
+	return getUpload(ctx, dbc, "select "+uploadColumns+" from `uploads` where `id` = ? for update", id)
+}
+
+func getUpload(ctx context.Context, dbc DBTransaction, query string, id string) (Upload, error) {
+	row := dbc.QueryRowContext(ctx, query, id)
+	err := row.Err()
+	if err != nil {
+		return Upload{}, err
+	}
+
+	var u Upload
+	err = row.Scan(&u.ID, &u.User, &u.Document, &u.Length, &u.Offset, &u.MediaType, &u.FileName, &u.Result, &u.Created, &u.Expires)
+	if err != nil {
+		return Upload{}, err
+	}
+	return u, nil
+}
+
+func SetOffset(ctx context.Context, dbc DBTransaction, id string, offset int64, expires time.Time) error {
+	_, err := dbc.ExecContext(ctx, "update `uploads` set `offset` = ?, `expires_at` = ? where `id` = ?", offset, expires, id)
+	return err
+}
+
+// SetResult records the document a complete upload of length bytes was stored as.
+func SetResult(ctx context.Context, dbc DBTransaction, id string, length int64, documentID int64) error {
+	_, err := dbc.ExecContext(ctx, "update `uploads` set `offset` = ?, `result` = ? where `id` = ?", length, documentID, id)
+	return err
+}
+
+func DeleteUpload(ctx context.Context, dbc DBTransaction, id string) error {
+	_, err := dbc.ExecContext(ctx, "delete from `uploads` where `id` = ?", id)
+	return err
+}
+
+// ListExpiredUploads returns the IDs of uploads that expired before now.
+func ListExpiredUploads(ctx context.Context, dbc DBTransaction, now time.Time) ([]string, error) {
+	rows, err := dbc.QueryContext(ctx, "select `id` from `uploads` where `expires_at` <= ?", now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// Upload is a resumable upload of a document's contents, which are sent in any number of requests.
+type Upload struct {
+	ID   string
+	User int64
+	// Document is the document the upload updates, or 0 if it creates a new document.
+	Document  int64
+	Length    int64
+	Offset    int64
+	MediaType string
+	FileName  string
+	// Result is the document the upload was stored as once it completed, or 0 while it is in progress.
+	Result  int64
+	Expires time.Time
+}
+
+// MultipartUpload is an upload of a document's contents as numbered parts, which can be sent in any order and in
+// parallel, and are joined when the upload is completed.
+type MultipartUpload struct {
+	ID   string
+	User int64
+	// Document is the document the upload updates, or 0 if it creates a new document.
+	Document  int64
+	MediaType string
+	FileName  string
+	Expires   time.Time
+}
+
+// Part is a part of a multipart upload. Its ETag is the quoted MD5 of its contents.
+type Part struct {
+	Number  int
+	Size    int64
+	ETag    string
+	MD5     string
+	SHA256  string
+	Created time.Time
+}
+
+// CompletedPart names a part to include when completing a multipart upload, along with the ETag it was uploaded as.
+type CompletedPart struct {
+	Number int
+	ETag   string
+}
@@ -0,0 +1,385 @@
+package uploads
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents"
+	"github.com/EatonEmmerich/cloudStorage/pkg/uploads/internal/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/uploads/models"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidPart = errors.New("invalid part")
+
+// Parts are numbered from 1 to MaxPartNumber.
+const MaxPartNumber = 10000
+
+// Multipart uploads that receive no parts for multipartTTL are deleted.
+var multipartTTL = 24 * time.Hour
+
+// InitiateMultipart starts a multipart upload. If documentID is not 0 the completed upload is stored as the document's
+// next version, otherwise as a new document.
+func InitiateMultipart(ctx context.Context, dbc *sql.DB, userID int64, documentID int64, mediaType string, filename string) (models.MultipartUpload, error) {
+	_, err := uploadOwner(ctx, dbc, userID, documentID)
+	if err != nil {
+		return models.MultipartUpload{}, err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return models.MultipartUpload{}, err
+	}
+
+	err = os.MkdirAll(partsPath(id), 0700)
+	if err != nil {
+		return models.MultipartUpload{}, err
+	}
+
+	now := time.Now()
+	u := db.MultipartUpload{
+		ID:        id,
+		User:      userID,
+		Document:  sql.NullInt64{Int64: documentID, Valid: documentID != 0},
+		MediaType: mediaType,
+		FileName:  filename,
+		Created:   now,
+		Expires:   now.Add(multipartTTL),
+	}
+	err = db.InsertMultipartUpload(ctx, dbc, u)
+	if err != nil {
+		_ = os.RemoveAll(partsPath(id))
+		return models.MultipartUpload{}, err
+	}
+	return multipartModel(u), nil
+}
+
+// GetMultipart returns a multipart upload of the user's that has not expired.
+func GetMultipart(ctx context.Context, dbc *sql.DB, uploadID string, userID int64) (models.MultipartUpload, error) {
+	u, err := getMultipart(ctx, dbc, uploadID, userID, db.GetMultipartUpload)
+	if err != nil {
+		return models.MultipartUpload{}, err
+	}
+	return multipartModel(u), nil
+}
+
+func getMultipart(ctx context.Context, dbc db.DBTransaction, uploadID string, userID int64,
+	getter func(context.Context, db.DBTransaction, string) (db.MultipartUpload, error)) (db.MultipartUpload, error) {
+	u, err := getter(ctx, dbc, uploadID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return db.MultipartUpload{}, fmt.Errorf("%w: %s", ErrNotFound, uploadID)
+	} else if err != nil {
+		return db.MultipartUpload{}, err
+	}
+	if u.User != userID || u.Result.Valid || !u.Expires.After(time.Now()) {
+		return db.MultipartUpload{}, fmt.Errorf("%w: %s", ErrNotFound, uploadID)
+	}
+	return u, nil
+}
+
+// completedDocument returns the document a multipart upload of the user's was stored as, and reports whether it has
+// been completed.
+func completedDocument(ctx context.Context, dbc *sql.DB, uploadID string, userID int64) (int64, bool, error) {
+	u, err := db.GetMultipartUpload(ctx, dbc, uploadID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	if u.User != userID || !u.Result.Valid {
+		return 0, false, nil
+	}
+	return u.Result.Int64, true, nil
+}
+
+// UploadPart stores a part of a multipart upload, replacing any part uploaded before with the same number. The part
+// is rejected with documents.ErrChecksumMismatch if its contents don't match the expected checksums.
+func UploadPart(ctx context.Context, dbc *sql.DB, uploadID string, userID int64, number int, reader io.Reader, expected documents.Checksums) (models.Part, error) {
+	if number < 1 || number > MaxPartNumber {
+		return models.Part{}, fmt.Errorf("%w: part number must be between 1 and %d", ErrInvalidPart, MaxPartNumber)
+	}
+
+	u, err := getMultipart(ctx, dbc, uploadID, userID, db.GetMultipartUpload)
+	if err != nil {
+		return models.Part{}, err
+	}
+
+	ownerID, err := uploadOwner(ctx, dbc, userID, u.Document.Int64)
+	if err != nil {
+		return models.Part{}, err
+	}
+	parts, err := db.ListParts(ctx, dbc, u.ID)
+	if err != nil {
+		return models.Part{}, err
+	}
+	var otherParts int64
+	for _, p := range parts {
+		if p.Number != number {
+			otherParts += p.Size
+		}
+	}
+
+	// Parts are written to a file of their own first, so a part that fails half way doesn't replace one received before.
+	temp, err := os.CreateTemp(partsPath(uploadID), "part-")
+	if err != nil {
+		return models.Part{}, err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	// Stop reading a byte past the largest size allowed, which is enough for CheckSize to reject the part.
+	verified := io.Reader(documents.NewVerifyingReader(io.NopCloser(reader), expected))
+	if max := documents.MaxFileSize(); max > 0 {
+		verified = io.LimitReader(verified, max-otherParts+1)
+	}
+
+	md5Hash := md5.New()
+	sha256Hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(temp, md5Hash, sha256Hash), verified)
+	if err != nil {
+		return models.Part{}, err
+	}
+	err = temp.Sync()
+	if err != nil {
+		return models.Part{}, err
+	}
+
+	// The joined parts are checked again as they are stored, but there is no point keeping parts that can't fit.
+	err = documents.CheckSize(ctx, dbc, ownerID, otherParts+size)
+	if err != nil {
+		return models.Part{}, err
+	}
+
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Part{}, err
+	}
+	defer tx.Rollback()
+
+	u, err = getMultipart(ctx, tx, uploadID, userID, db.GetMultipartUploadForUpdate)
+	if err != nil {
+		return models.Part{}, err
+	}
+
+	previous, err := db.GetPart(ctx, tx, u.ID, number)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return models.Part{}, err
+	}
+
+	part := db.Part{
+		Upload:  u.ID,
+		Number:  number,
+		Size:    size,
+		MD5:     hex.EncodeToString(md5Hash.Sum(nil)),
+		SHA256:  hex.EncodeToString(sha256Hash.Sum(nil)),
+		Created: time.Now(),
+	}
+	err = db.PutPart(ctx, tx, part)
+	if err != nil {
+		return models.Part{}, err
+	}
+	err = db.SetMultipartExpiry(ctx, tx, u.ID, part.Created.Add(multipartTTL))
+	if err != nil {
+		return models.Part{}, err
+	}
+
+	// Part files are named after their contents, so the file of a part received before is only replaced by the same
+	// contents, and stays in place for its row if the transaction fails.
+	err = os.Rename(temp.Name(), partPath(u.ID, part))
+	if err != nil {
+		return models.Part{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return models.Part{}, err
+	}
+
+	// Files left behind are removed with the rest of the upload.
+	if previous.SHA256 != "" && previous.SHA256 != part.SHA256 {
+		err = os.Remove(partPath(u.ID, previous))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Default().Printf("removing part %d of multipart upload %s: %v\n", number, u.ID, err)
+		}
+	}
+	return partModel(part), nil
+}
+
+// ListParts returns the parts uploaded so far, ordered by number.
+func ListParts(ctx context.Context, dbc *sql.DB, uploadID string, userID int64) ([]models.Part, error) {
+	u, err := getMultipart(ctx, dbc, uploadID, userID, db.GetMultipartUpload)
+	if err != nil {
+		return nil, err
+	}
+
+	parts, err := db.ListParts(ctx, dbc, u.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp []models.Part
+	for _, p := range parts {
+		resp = append(resp, partModel(p))
+	}
+	return resp, nil
+}
+
+// CompleteMultipart joins the given parts, in ascending order of number, and stores them as a document. Parts that
+// were uploaded but not given are discarded. If no parts are given, every part uploaded is used. It returns the
+// document the upload was stored as. The document is recorded in the same transaction that stores it, so completing
+// an upload again, until it expires, returns the same document.
+func CompleteMultipart(ctx context.Context, dbc *sql.DB, uploadID string, userID int64, completed []models.CompletedPart) (int64, error) {
+	documentID, ok, err := completedDocument(ctx, dbc, uploadID, userID)
+	if err != nil || ok {
+		return documentID, err
+	}
+
+	u, err := getMultipart(ctx, dbc, uploadID, userID, db.GetMultipartUpload)
+	if err != nil {
+		return 0, err
+	}
+
+	parts, err := db.ListParts(ctx, dbc, u.ID)
+	if err != nil {
+		return 0, err
+	}
+	selected, err := selectParts(parts, completed)
+	if err != nil {
+		return 0, err
+	}
+
+	var readers []io.Reader
+	for _, p := range selected {
+		file, err := os.Open(partPath(u.ID, p))
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+		readers = append(readers, file)
+	}
+	contents := io.NopCloser(io.MultiReader(readers...))
+
+	documentID, err = documents.Store(ctx, dbc, u.Document.Int64, u.User, contents, u.MediaType, u.FileName,
+		func(ctx context.Context, tx *sql.Tx, documentID int64) error {
+			_, err := getMultipart(ctx, tx, u.ID, userID, db.GetMultipartUploadForUpdate)
+			if err != nil {
+				return err
+			}
+			return db.SetMultipartResult(ctx, tx, u.ID, documentID, time.Now().Add(multipartTTL))
+		})
+	if err != nil {
+		// Another request may have completed the upload in the meantime.
+		completedID, ok, completedErr := completedDocument(ctx, dbc, uploadID, userID)
+		if completedErr == nil && ok {
+			return completedID, nil
+		}
+		return 0, err
+	}
+	return documentID, os.RemoveAll(partsPath(u.ID))
+}
+
+// selectParts returns the uploaded parts that completed names, checking that they are in ascending order and that
+// their ETags match.
+func selectParts(parts []db.Part, completed []models.CompletedPart) ([]db.Part, error) {
+	if len(completed) == 0 {
+		if len(parts) == 0 {
+			return nil, fmt.Errorf("%w: no parts have been uploaded", ErrInvalidPart)
+		}
+		return parts, nil
+	}
+
+	byNumber := make(map[int]db.Part)
+	for _, p := range parts {
+		byNumber[p.Number] = p
+	}
+
+	var selected []db.Part
+	for i, c := range completed {
+		if i > 0 && c.Number <= completed[i-1].Number {
+			return nil, fmt.Errorf("%w: parts must be in ascending order", ErrInvalidPart)
+		}
+		p, ok := byNumber[c.Number]
+		if !ok {
+			return nil, fmt.Errorf("%w: part %d has not been uploaded", ErrInvalidPart, c.Number)
+		}
+		if c.ETag != "" && c.ETag != partETag(p.MD5) {
+			return nil, fmt.Errorf("%w: part %d has ETag %s", ErrInvalidPart, c.Number, partETag(p.MD5))
+		}
+		selected = append(selected, p)
+	}
+	return selected, nil
+}
+
+// AbortMultipart deletes a multipart upload and its parts.
+func AbortMultipart(ctx context.Context, dbc *sql.DB, uploadID string, userID int64) error {
+	u, err := getMultipart(ctx, dbc, uploadID, userID, db.GetMultipartUpload)
+	if err != nil {
+		return err
+	}
+	return removeMultipart(ctx, dbc, u.ID)
+}
+
+func deleteExpiredMultipart(ctx context.Context, dbc *sql.DB, now time.Time) (int, error) {
+	ids, err := db.ListExpiredMultipartUploads(ctx, dbc, now)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, id := range ids {
+		err = removeMultipart(ctx, dbc, id)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+func removeMultipart(ctx context.Context, dbc *sql.DB, uploadID string) error {
+	err := db.DeleteMultipartUpload(ctx, dbc, uploadID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(partsPath(uploadID))
+}
+
+func partsPath(uploadID string) string {
+	return filepath.Join(uploadPath, "multipart", uploadID)
+}
+
+func partPath(uploadID string, p db.Part) string {
+	return filepath.Join(partsPath(uploadID), strconv.Itoa(p.Number)+"-"+p.SHA256)
+}
+
+func partETag(md5Hex string) string {
+	return `"` + md5Hex + `"`
+}
+
+func multipartModel(u db.MultipartUpload) models.MultipartUpload {
+	return models.MultipartUpload{
+		ID:        u.ID,
+		User:      u.User,
+		Document:  u.Document.Int64,
+		MediaType: u.MediaType,
+		FileName:  u.FileName,
+		Expires:   u.Expires,
+	}
+}
+
+func partModel(p db.Part) models.Part {
+	return models.Part{
+		Number:  p.Number,
+		Size:    p.Size,
+		ETag:    partETag(p.MD5),
+		MD5:     p.MD5,
+		SHA256:  p.SHA256,
+		Created: p.Created,
+	}
+}
@@ -0,0 +1,149 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"github.com/EatonEmmerich/cloudStorage/pkg/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents"
+	"github.com/EatonEmmerich/cloudStorage/pkg/uploads/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/users"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompleteMultipart(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	upload, err := InitiateMultipart(ctx, dbc, userID, 0, "text/plain", "file.txt")
+	require.NoError(t, err)
+
+	// Parts can arrive in any order, and be uploaded again.
+	_, err = UploadPart(ctx, dbc, upload.ID, userID, 3, strings.NewReader("ghi"), documents.Checksums{})
+	require.NoError(t, err)
+	_, err = UploadPart(ctx, dbc, upload.ID, userID, 1, strings.NewReader("xxx"), documents.Checksums{})
+	require.NoError(t, err)
+	sum := md5.Sum([]byte("abc"))
+	first, err := UploadPart(ctx, dbc, upload.ID, userID, 1, strings.NewReader("abc"), documents.Checksums{MD5: sum[:]})
+	require.NoError(t, err)
+	_, err = UploadPart(ctx, dbc, upload.ID, userID, 2, strings.NewReader("def"), documents.Checksums{MD5: sum[:]})
+	require.True(t, errors.Is(err, documents.ErrChecksumMismatch))
+	second, err := UploadPart(ctx, dbc, upload.ID, userID, 2, strings.NewReader("def"), documents.Checksums{})
+	require.NoError(t, err)
+	_, err = UploadPart(ctx, dbc, upload.ID, userID, 0, strings.NewReader("def"), documents.Checksums{})
+	require.True(t, errors.Is(err, ErrInvalidPart))
+
+	parts, err := ListParts(ctx, dbc, upload.ID, userID)
+	require.NoError(t, err)
+	require.Len(t, parts, 3)
+	require.Equal(t, first.ETag, parts[0].ETag)
+	require.Equal(t, 1, parts[0].Number)
+	require.Equal(t, int64(3), parts[0].Size)
+	// Only the last contents of each part are kept.
+	files, err := os.ReadDir(partsPath(upload.ID))
+	require.NoError(t, err)
+	require.Len(t, files, 3)
+
+	_, err = CompleteMultipart(ctx, dbc, upload.ID, userID, []models.CompletedPart{{Number: 2}, {Number: 1}})
+	require.True(t, errors.Is(err, ErrInvalidPart))
+	_, err = CompleteMultipart(ctx, dbc, upload.ID, userID, []models.CompletedPart{{Number: 1, ETag: second.ETag}})
+	require.True(t, errors.Is(err, ErrInvalidPart))
+
+	// Part 3 is left out, and discarded.
+	documentID, err := CompleteMultipart(ctx, dbc, upload.ID, userID, []models.CompletedPart{{Number: 1, ETag: first.ETag}, {Number: 2, ETag: second.ETag}})
+	require.NoError(t, err)
+
+	doc, reader, err := documents.OpenDocument(ctx, dbc, documentID, userID)
+	require.NoError(t, err)
+	defer reader.Close()
+	contents, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, "abcdef", string(contents))
+	require.Equal(t, "file.txt", doc.FileName)
+
+	_, err = ListParts(ctx, dbc, upload.ID, userID)
+	require.True(t, errors.Is(err, ErrNotFound))
+	_, err = os.Stat(partsPath(upload.ID))
+	require.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestCompleteMultipart_Update(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	documentID, err := documents.Upload(ctx, dbc, userID, io.NopCloser(bytes.NewReader([]byte("first"))), "text/plain", "file.txt")
+	require.NoError(t, err)
+
+	upload, err := InitiateMultipart(ctx, dbc, userID, int64(documentID), "text/plain", "file.txt")
+	require.NoError(t, err)
+	_, err = UploadPart(ctx, dbc, upload.ID, userID, 1, strings.NewReader("sec"), documents.Checksums{})
+	require.NoError(t, err)
+	_, err = UploadPart(ctx, dbc, upload.ID, userID, 2, strings.NewReader("ond"), documents.Checksums{})
+	require.NoError(t, err)
+
+	id, err := CompleteMultipart(ctx, dbc, upload.ID, userID, nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(documentID), id)
+
+	doc, reader, err := documents.OpenDocument(ctx, dbc, id, userID)
+	require.NoError(t, err)
+	defer reader.Close()
+	contents, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, "second", string(contents))
+	require.Equal(t, int64(2), doc.Version)
+
+	// Completing the upload again, such as after a lost response, returns the same document without updating it.
+	id, err = CompleteMultipart(ctx, dbc, upload.ID, userID, nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(documentID), id)
+	doc, err = documents.Get(ctx, dbc, id)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), doc.Version)
+
+	_, err = UploadPart(ctx, dbc, upload.ID, userID, 3, strings.NewReader("more"), documents.Checksums{})
+	require.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestAbortMultipart(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+
+	upload, err := InitiateMultipart(ctx, dbc, john, 0, "", "")
+	require.NoError(t, err)
+	_, err = UploadPart(ctx, dbc, upload.ID, john, 1, strings.NewReader("abc"), documents.Checksums{})
+	require.NoError(t, err)
+
+	require.True(t, errors.Is(AbortMultipart(ctx, dbc, upload.ID, jane), ErrNotFound))
+	require.NoError(t, AbortMultipart(ctx, dbc, upload.ID, john))
+
+	_, err = GetMultipart(ctx, dbc, upload.ID, john)
+	require.True(t, errors.Is(err, ErrNotFound))
+	_, err = os.Stat(partsPath(upload.ID))
+	require.True(t, errors.Is(err, os.ErrNotExist))
+
+	expired, err := InitiateMultipart(ctx, dbc, john, 0, "", "")
+	require.NoError(t, err)
+	n, err := DeleteExpired(ctx, dbc, time.Now().Add(multipartTTL+time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	_, err = os.Stat(partsPath(expired.ID))
+	require.True(t, errors.Is(err, os.ErrNotExist))
+}
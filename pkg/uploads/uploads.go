@@ -0,0 +1,483 @@
+package uploads
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/EatonEmmerich/cloudStorage/pkg/access_control"
+	accessModels "github.com/EatonEmmerich/cloudStorage/pkg/access_control/models"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents"
+	"github.com/EatonEmmerich/cloudStorage/pkg/uploads/internal/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/uploads/models"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("upload not found")
+
+var ErrOffsetMismatch = errors.New("upload offset does not match")
+
+var ErrTooLarge = errors.New("upload is larger than its declared length")
+
+// The data of uploads in progress is kept in files under uploadPath, so that they survive restarts of the server.
+var uploadPath string
+
+// Uploads that receive no data for expiry are deleted.
+var expiry = 24 * time.Hour
+
+var cleanInterval = time.Hour
+
+func init() {
+	uploadPath = os.Getenv("CLOUD_STORAGE_UPLOAD_PATH")
+	if uploadPath == "" {
+		uploadPath = filepath.Join(os.TempDir(), "cloudStorage-uploads")
+	}
+	err := os.MkdirAll(uploadPath, 0700)
+	if err != nil {
+		panic(err)
+	}
+
+	if d := os.Getenv("CLOUD_STORAGE_UPLOAD_EXPIRY"); d != "" {
+		expiry, err = time.ParseDuration(d)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if d := os.Getenv("CLOUD_STORAGE_MULTIPART_TTL"); d != "" {
+		multipartTTL, err = time.ParseDuration(d)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Create starts an upload of length bytes. If documentID is not 0 the finished upload is stored as the document's
+// next version, otherwise as a new document.
+func Create(ctx context.Context, dbc *sql.DB, userID int64, length int64, documentID int64, mediaType string, filename string) (models.Upload, error) {
+	if length < 0 {
+		return models.Upload{}, fmt.Errorf("invalid upload length: %d", length)
+	}
+
+	ownerID, err := uploadOwner(ctx, dbc, userID, documentID)
+	if err != nil {
+		return models.Upload{}, err
+	}
+
+	err = documents.CheckSize(ctx, dbc, ownerID, length)
+	if err != nil {
+		return models.Upload{}, err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return models.Upload{}, err
+	}
+
+	file, err := os.OpenFile(dataPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return models.Upload{}, err
+	}
+	err = file.Close()
+	if err != nil {
+		return models.Upload{}, err
+	}
+
+	now := time.Now()
+	u := db.Upload{
+		ID:        id,
+		User:      userID,
+		Document:  sql.NullInt64{Int64: documentID, Valid: documentID != 0},
+		Length:    length,
+		MediaType: mediaType,
+		FileName:  filename,
+		Created:   now,
+		Expires:   now.Add(expiry),
+	}
+	err = db.InsertUpload(ctx, dbc, u)
+	if err != nil {
+		_ = os.Remove(dataPath(id))
+		return models.Upload{}, err
+	}
+
+	// An empty upload is complete as soon as it is created.
+	if length == 0 {
+		return Append(ctx, dbc, id, userID, 0, eofReader{})
+	}
+	return uploadModel(u), nil
+}
+
+// uploadOwner returns the user whose quota an upload counts towards, checking that the user may update the document if
+// the upload is of a new version.
+func uploadOwner(ctx context.Context, dbc *sql.DB, userID int64, documentID int64) (int64, error) {
+	if documentID == 0 {
+		return userID, nil
+	}
+
+	doc, err := documents.Get(ctx, dbc, documentID)
+	if err != nil {
+		return 0, err
+	}
+	err = access_control.AuthoriseOrError(ctx, dbc, userID, doc, accessModels.WRITE)
+	if err != nil {
+		return 0, err
+	}
+	return doc.Owner, nil
+}
+
+// Get returns an upload of the user's that has not expired.
+func Get(ctx context.Context, dbc *sql.DB, uploadID string, userID int64) (models.Upload, error) {
+	u, err := get(ctx, dbc, uploadID, userID, db.GetUpload)
+	if err != nil {
+		return models.Upload{}, err
+	}
+	return uploadModel(u), nil
+}
+
+func get(ctx context.Context, dbc db.DBTransaction, uploadID string, userID int64,
+	getter func(context.Context, db.DBTransaction, string) (db.Upload, error)) (db.Upload, error) {
+	u, err := getter(ctx, dbc, uploadID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return db.Upload{}, fmt.Errorf("%w: %s", ErrNotFound, uploadID)
+	} else if err != nil {
+		return db.Upload{}, err
+	}
+	if u.User != userID || (!u.Result.Valid && !u.Expires.After(time.Now())) {
+		return db.Upload{}, fmt.Errorf("%w: %s", ErrNotFound, uploadID)
+	}
+	return u, nil
+}
+
+// Append writes data to an upload at offset, which must be the number of bytes received so far. Once the upload has
+// received all of its bytes it is stored as a document, and the returned upload's Result is set to the document.
+//
+// The data is staged in a file of its own without holding a lock on the upload, and only added to the upload if no
+// other request has added data at the offset in the meantime. Each request's data is kept as a segment of the upload,
+// in a file named after the offset it starts at.
+func Append(ctx context.Context, dbc *sql.DB, uploadID string, userID int64, offset int64, reader io.Reader) (models.Upload, error) {
+	u, err := get(ctx, dbc, uploadID, userID, db.GetUpload)
+	if err != nil {
+		return models.Upload{}, err
+	}
+	if u.Result.Valid || offset != u.Offset {
+		return models.Upload{}, fmt.Errorf("%w: expected %d, got %d", ErrOffsetMismatch, u.Offset, offset)
+	}
+
+	staged, err := os.CreateTemp(uploadPath, u.ID+".staged-*")
+	if err != nil {
+		return models.Upload{}, err
+	}
+	defer os.Remove(staged.Name())
+
+	n, err := io.Copy(staged, io.LimitReader(reader, u.Length-u.Offset))
+	if err == nil {
+		var extra [1]byte
+		if m, _ := reader.Read(extra[:]); m > 0 {
+			err = fmt.Errorf("%w: %d bytes", ErrTooLarge, u.Length)
+		}
+	}
+	if err == nil {
+		err = staged.Sync()
+	}
+	closeErr := staged.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Keep what was received, so the client can resume from there.
+		if n > 0 && closeErr == nil && !errors.Is(err, ErrTooLarge) {
+			_, _ = addSegment(ctx, dbc, u, staged.Name(), n)
+		}
+		return models.Upload{}, err
+	}
+
+	if u.Offset+n < u.Length {
+		if n == 0 {
+			return uploadModel(u), nil
+		}
+		u, err = addSegment(ctx, dbc, u, staged.Name(), n)
+		if err != nil {
+			return models.Upload{}, err
+		}
+		return uploadModel(u), nil
+	}
+
+	documentID, err := finish(ctx, dbc, u, staged.Name(), n)
+	if err != nil {
+		return models.Upload{}, err
+	}
+	u.Offset = u.Length
+	u.Result = sql.NullInt64{Int64: documentID, Valid: true}
+
+	// The upload has been stored, so data left behind is only removed again once the upload expires.
+	err = removeData(u.ID)
+	if err != nil {
+		log.Default().Printf("removing the data of upload %s: %v\n", u.ID, err)
+	}
+	return uploadModel(u), nil
+}
+
+// addSegment adds the n bytes staged for an upload at its offset, and returns the upload with its new offset.
+func addSegment(ctx context.Context, dbc *sql.DB, u db.Upload, stagedPath string, n int64) (db.Upload, error) {
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return db.Upload{}, err
+	}
+	defer tx.Rollback()
+
+	err = checkOffset(ctx, tx, u)
+	if err != nil {
+		return db.Upload{}, err
+	}
+
+	// A segment left by a request whose offset failed to be recorded is replaced.
+	err = os.Rename(stagedPath, segmentPath(u.ID, u.Offset))
+	if err != nil {
+		return db.Upload{}, err
+	}
+
+	u.Offset += n
+	u.Expires = time.Now().Add(expiry)
+	err = db.SetOffset(ctx, tx, u.ID, u.Offset, u.Expires)
+	if err != nil {
+		return db.Upload{}, err
+	}
+	return u, tx.Commit()
+}
+
+// checkOffset locks an upload until the transaction ends, checking that no data has been added to it since u was read.
+func checkOffset(ctx context.Context, tx *sql.Tx, u db.Upload) error {
+	locked, err := get(ctx, tx, u.ID, u.User, db.GetUploadForUpdate)
+	if err != nil {
+		return err
+	}
+	if locked.Result.Valid || locked.Offset != u.Offset {
+		return fmt.Errorf("%w: expected %d, got %d", ErrOffsetMismatch, locked.Offset, u.Offset)
+	}
+	return nil
+}
+
+// finish stores an upload whose last n bytes are staged as a document. The upload's result is recorded in the same
+// transaction as the document, so an upload is never stored twice.
+func finish(ctx context.Context, dbc *sql.DB, u db.Upload, stagedPath string, n int64) (int64, error) {
+	paths, lengths, err := segments(u.ID, u.Offset)
+	if err != nil {
+		return 0, err
+	}
+	contents := &segmentReader{paths: append(paths, stagedPath), lengths: append(lengths, n)}
+
+	return documents.Store(ctx, dbc, u.Document.Int64, u.User, contents, u.MediaType, u.FileName,
+		func(ctx context.Context, tx *sql.Tx, documentID int64) error {
+			err := checkOffset(ctx, tx, u)
+			if err != nil {
+				return err
+			}
+			return db.SetResult(ctx, tx, u.ID, u.Length, documentID)
+		})
+}
+
+// Terminate deletes an upload and the data it has received.
+func Terminate(ctx context.Context, dbc *sql.DB, uploadID string, userID int64) error {
+	u, err := get(ctx, dbc, uploadID, userID, db.GetUpload)
+	if err != nil {
+		return err
+	}
+	return remove(ctx, dbc, u.ID)
+}
+
+// DeleteExpired deletes the uploads and multipart uploads that expired before now, and returns how many were deleted.
+func DeleteExpired(ctx context.Context, dbc *sql.DB, now time.Time) (int, error) {
+	ids, err := db.ListExpiredUploads(ctx, dbc, now)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, id := range ids {
+		err = remove(ctx, dbc, id)
+		if err != nil {
+			return i, err
+		}
+	}
+
+	n, err := deleteExpiredMultipart(ctx, dbc, now)
+	return len(ids) + n, err
+}
+
+// RunCleaner deletes expired uploads every cleanInterval until ctx is done.
+func RunCleaner(ctx context.Context, dbc *sql.DB) {
+	ticker := time.NewTicker(cleanInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := DeleteExpired(ctx, dbc, time.Now())
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Default().Println("deleting expired uploads:", err)
+		} else if n > 0 {
+			log.Default().Printf("deleted %d expired uploads\n", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func remove(ctx context.Context, dbc *sql.DB, uploadID string) error {
+	err := db.DeleteUpload(ctx, dbc, uploadID)
+	if err != nil {
+		return err
+	}
+	return removeData(uploadID)
+}
+
+// removeData deletes the segments of an upload and any data staged for it.
+func removeData(uploadID string) error {
+	entries, err := os.ReadDir(uploadPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() != uploadID && !strings.HasPrefix(entry.Name(), uploadID+".") {
+			continue
+		}
+		err = os.Remove(filepath.Join(uploadPath, entry.Name()))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// dataPath is the path of the first segment of an upload's data, which is created along with the upload.
+func dataPath(uploadID string) string {
+	return filepath.Join(uploadPath, uploadID)
+}
+
+func segmentPath(uploadID string, offset int64) string {
+	if offset == 0 {
+		return dataPath(uploadID)
+	}
+	return dataPath(uploadID) + "." + strconv.FormatInt(offset, 10)
+}
+
+// segments returns the paths of the segments holding an upload's first offset bytes, in order, along with the number
+// of bytes of each that belong to the upload.
+func segments(uploadID string, offset int64) ([]string, []int64, error) {
+	if offset == 0 {
+		return nil, nil, nil
+	}
+
+	entries, err := os.ReadDir(uploadPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	starts := []int64{0}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), uploadID+".") {
+			continue
+		}
+		start, err := strconv.ParseInt(strings.TrimPrefix(entry.Name(), uploadID+"."), 10, 64)
+		// Segments from offset on were left by requests whose offset failed to be recorded.
+		if err == nil && start > 0 && start < offset {
+			starts = append(starts, start)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	var paths []string
+	var lengths []int64
+	for i, start := range starts {
+		end := offset
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		paths = append(paths, segmentPath(uploadID, start))
+		lengths = append(lengths, end-start)
+	}
+	return paths, lengths, nil
+}
+
+// segmentReader reads the given number of bytes from each of the files in turn, opening one at a time.
+type segmentReader struct {
+	paths   []string
+	lengths []int64
+	file    *os.File
+	reader  *io.LimitedReader
+}
+
+func (r *segmentReader) Read(p []byte) (int, error) {
+	for {
+		if r.reader == nil {
+			if len(r.paths) == 0 {
+				return 0, io.EOF
+			}
+			file, err := os.Open(r.paths[0])
+			if err != nil {
+				return 0, err
+			}
+			r.file = file
+			r.reader = &io.LimitedReader{R: file, N: r.lengths[0]}
+			r.paths, r.lengths = r.paths[1:], r.lengths[1:]
+		}
+
+		n, err := r.reader.Read(p)
+		if !errors.Is(err, io.EOF) {
+			return n, err
+		}
+		if r.reader.N > 0 {
+			return n, fmt.Errorf("%w: segment %s", io.ErrUnexpectedEOF, r.file.Name())
+		}
+		err = r.file.Close()
+		r.file, r.reader = nil, nil
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+func (r *segmentReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+func uploadModel(u db.Upload) models.Upload {
+	return models.Upload{
+		ID:        u.ID,
+		User:      u.User,
+		Document:  u.Document.Int64,
+		Length:    u.Length,
+		Offset:    u.Offset,
+		MediaType: u.MediaType,
+		FileName:  u.FileName,
+		Result:    u.Result.Int64,
+		Expires:   u.Expires,
+	}
+}
@@ -0,0 +1,157 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/EatonEmmerich/cloudStorage/pkg/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/documents"
+	documentModels "github.com/EatonEmmerich/cloudStorage/pkg/documents/models"
+	internalDB "github.com/EatonEmmerich/cloudStorage/pkg/uploads/internal/db"
+	"github.com/EatonEmmerich/cloudStorage/pkg/users"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppend(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	upload, err := Create(ctx, dbc, userID, 10, 0, "text/plain", "file.txt")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), upload.Offset)
+
+	_, err = Append(ctx, dbc, upload.ID, userID, 0, strings.NewReader("01234"))
+	require.NoError(t, err)
+
+	_, err = Append(ctx, dbc, upload.ID, userID, 3, strings.NewReader("34567"))
+	require.True(t, errors.Is(err, ErrOffsetMismatch))
+
+	_, err = Append(ctx, dbc, upload.ID, userID, 5, strings.NewReader("56789abc"))
+	require.True(t, errors.Is(err, ErrTooLarge))
+
+	upload, err = Get(ctx, dbc, upload.ID, userID)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), upload.Offset)
+
+	upload, err = Append(ctx, dbc, upload.ID, userID, 5, strings.NewReader("56789"))
+	require.NoError(t, err)
+	require.True(t, upload.Result > 0)
+
+	_, err = os.Stat(dataPath(upload.ID))
+	require.True(t, errors.Is(err, os.ErrNotExist))
+
+	doc, reader, err := documents.OpenDocument(ctx, dbc, upload.Result, userID)
+	require.NoError(t, err)
+	defer reader.Close()
+	contents, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(contents))
+	require.Equal(t, "file.txt", doc.FileName)
+}
+
+func TestAppend_Update(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	john, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+	jane, err := users.Register(ctx, dbc, "jane", "password")
+	require.NoError(t, err)
+
+	documentID, err := documents.Upload(ctx, dbc, john, io.NopCloser(bytes.NewReader([]byte("first"))), "text/plain", "file.txt")
+	require.NoError(t, err)
+
+	_, err = Create(ctx, dbc, jane, 6, int64(documentID), "text/plain", "file.txt")
+	require.Error(t, err)
+
+	upload, err := Create(ctx, dbc, john, 6, int64(documentID), "text/plain", "file.txt")
+	require.NoError(t, err)
+
+	_, err = Get(ctx, dbc, upload.ID, jane)
+	require.True(t, errors.Is(err, ErrNotFound))
+
+	upload, err = Append(ctx, dbc, upload.ID, john, 0, strings.NewReader("second"))
+	require.NoError(t, err)
+	require.Equal(t, int64(documentID), upload.Result)
+
+	doc, reader, err := documents.OpenDocument(ctx, dbc, int64(documentID), john)
+	require.NoError(t, err)
+	defer reader.Close()
+	contents, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, "second", string(contents))
+	require.Equal(t, int64(2), doc.Version)
+}
+
+func TestAppend_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	upload, err := Create(ctx, dbc, userID, 10, 0, "text/plain", "file.txt")
+	require.NoError(t, err)
+	stale, err := internalDB.GetUpload(ctx, dbc, upload.ID)
+	require.NoError(t, err)
+
+	// Data staged by a request that started at the same offset as one that has added its data since is discarded.
+	_, err = Append(ctx, dbc, upload.ID, userID, 0, strings.NewReader("01234"))
+	require.NoError(t, err)
+	staged := dataPath(upload.ID) + ".staged-test"
+	require.NoError(t, os.WriteFile(staged, []byte("abcde"), 0600))
+	_, err = addSegment(ctx, dbc, stale, staged, 5)
+	require.True(t, errors.Is(err, ErrOffsetMismatch))
+
+	upload, err = Append(ctx, dbc, upload.ID, userID, 5, strings.NewReader("56789"))
+	require.NoError(t, err)
+	require.True(t, upload.Result > 0)
+
+	// A request that sent all of the data at once doesn't store another document.
+	require.NoError(t, os.WriteFile(staged, []byte("abcdefghij"), 0600))
+	_, err = finish(ctx, dbc, stale, staged, 10)
+	require.True(t, errors.Is(err, ErrOffsetMismatch))
+	require.NoError(t, os.Remove(staged))
+	listed, err := documents.ListDocuments(ctx, dbc, userID, documentModels.Filter{}, documentModels.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, listed.Documents, 1)
+
+	_, reader, err := documents.OpenDocument(ctx, dbc, upload.Result, userID)
+	require.NoError(t, err)
+	defer reader.Close()
+	contents, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(contents))
+}
+
+func TestDeleteExpired(t *testing.T) {
+	ctx := context.Background()
+	dbc := db.ConnectForTesting(t)
+
+	userID, err := users.Register(ctx, dbc, "john", "password")
+	require.NoError(t, err)
+
+	upload, err := Create(ctx, dbc, userID, 10, 0, "", "")
+	require.NoError(t, err)
+
+	n, err := DeleteExpired(ctx, dbc, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	n, err = DeleteExpired(ctx, dbc, time.Now().Add(expiry+time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	_, err = Get(ctx, dbc, upload.ID, userID)
+	require.True(t, errors.Is(err, ErrNotFound))
+	_, err = os.Stat(dataPath(upload.ID))
+	require.True(t, errors.Is(err, os.ErrNotExist))
+}
@@ -23,4 +23,59 @@ func GetUserByUsername(ctx context.Context, dbc *sql.DB, username string) (User,
 	}
 
 	return  user, nil
-}
\ No newline at end of file
+}
+
+func IsAdmin(ctx context.Context, dbc *sql.DB, userID int64) (bool, error) {
+	row := dbc.QueryRowContext(ctx, "select `admin` from users where `id` = ?", userID)
+	err := row.Err()
+	if err != nil {
+		return false, err
+	}
+
+	var admin bool
+	err = row.Scan(&admin)
+	if err != nil {
+		return false, err
+	}
+	return admin, nil
+}
+
+func GetQuota(ctx context.Context, dbc *sql.DB, userID int64) (sql.NullInt64, error) {
+	row := dbc.QueryRowContext(ctx, "select `quota` from users where `id` = ?", userID)
+	err := row.Err()
+	if err != nil {
+		return sql.NullInt64{}, err
+	}
+
+	var quota sql.NullInt64
+	err = row.Scan(&quota)
+	if err != nil {
+		return sql.NullInt64{}, err
+	}
+	return quota, nil
+}
+
+func SetQuota(ctx context.Context, dbc *sql.DB, userID int64, quota sql.NullInt64) error {
+	_, err := dbc.ExecContext(ctx, "update users set `quota` = ? where `id` = ?", quota, userID)
+	return err
+}
+
+func GetMediaTypePolicy(ctx context.Context, dbc *sql.DB, userID int64) (string, string, error) {
+	row := dbc.QueryRowContext(ctx, "select `allowed_media_types`, `denied_media_types` from users where `id` = ?", userID)
+	err := row.Err()
+	if err != nil {
+		return "", "", err
+	}
+
+	var allowed, denied string
+	err = row.Scan(&allowed, &denied)
+	if err != nil {
+		return "", "", err
+	}
+	return allowed, denied, nil
+}
+
+func SetMediaTypePolicy(ctx context.Context, dbc *sql.DB, userID int64, allowed string, denied string) error {
+	_, err := dbc.ExecContext(ctx, "update users set `allowed_media_types` = ?, `denied_media_types` = ? where `id` = ?", allowed, denied, userID)
+	return err
+}
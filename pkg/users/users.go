@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"github.com/EatonEmmerich/cloudStorage/pkg/users/internal/db"
 )
 
 
@@ -25,4 +26,35 @@ func Register(ctx context.Context, dbc *sql.DB, username string ,password string
 	}
 
 	return userID, SetAuthorisation(ctx, dbc, password, userID)
-}
\ No newline at end of file
+}
+
+// IsAdmin reports whether a user is an administrator. Administrators are set in the `admin` column of the users table.
+func IsAdmin(ctx context.Context, dbc *sql.DB, userID int64) (bool, error) {
+	return db.IsAdmin(ctx, dbc, userID)
+}
+
+// GetQuota returns the number of bytes a user may store, if the user has a quota of their own.
+func GetQuota(ctx context.Context, dbc *sql.DB, userID int64) (int64, bool, error) {
+	quota, err := db.GetQuota(ctx, dbc, userID)
+	return quota.Int64, quota.Valid, err
+}
+
+// SetQuota sets the number of bytes a user may store. A nil quota removes it, so the default quota applies again.
+func SetQuota(ctx context.Context, dbc *sql.DB, userID int64, quota *int64) error {
+	var q sql.NullInt64
+	if quota != nil {
+		q = sql.NullInt64{Int64: *quota, Valid: true}
+	}
+	return db.SetQuota(ctx, dbc, userID, q)
+}
+
+// GetMediaTypePolicy returns the comma separated media types a user may and may not upload. Empty lists don't restrict
+// the user.
+func GetMediaTypePolicy(ctx context.Context, dbc *sql.DB, userID int64) (string, string, error) {
+	return db.GetMediaTypePolicy(ctx, dbc, userID)
+}
+
+// SetMediaTypePolicy sets the comma separated media types a user may and may not upload.
+func SetMediaTypePolicy(ctx context.Context, dbc *sql.DB, userID int64, allowed string, denied string) error {
+	return db.SetMediaTypePolicy(ctx, dbc, userID, allowed, denied)
+}